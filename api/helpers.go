@@ -49,6 +49,10 @@ func ErrorUnauthorizedResp(c *fiber.Ctx, message ...string) error {
 	return ErrorCodeResp(c, fiber.StatusUnauthorized, message...)
 }
 
+func ErrorForbiddenResp(c *fiber.Ctx, message ...string) error {
+	return ErrorCodeResp(c, fiber.StatusForbidden, message...)
+}
+
 func ErrorBadRequestResp(c *fiber.Ctx, message ...string) error {
 	return ErrorCodeResp(c, fiber.StatusBadRequest, message...)
 }
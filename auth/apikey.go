@@ -0,0 +1,223 @@
+package auth
+
+import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/arqut/common/api"
+	"github.com/arqut/common/system"
+	"github.com/arqut/common/types"
+	"github.com/arqut/common/utils"
+	"github.com/gofiber/fiber/v2"
+	"gorm.io/gorm"
+)
+
+const (
+	apiKeyPrefixLength = 8
+	apiKeySecretLength = 32
+	apiKeyMaxAttempts  = 3
+
+	// apiKeyLastUsedInterval limits the LastUsedAt writes of busy keys.
+	apiKeyLastUsedInterval = time.Minute
+)
+
+// APIKey is a stored API key. Keys have the "<prefix>.<secret>" shape checked
+// by IsApiKey, only the SHA-256 of the secret is stored.
+type APIKey struct {
+	ID         uint       `json:"id" gorm:"primaryKey"`
+	Prefix     string     `json:"prefix" gorm:"type:varchar(8);uniqueIndex"`
+	SecretHash string     `json:"-" gorm:"type:varchar(64)"`
+	Name       string     `json:"name" gorm:"type:varchar(128)"`
+	AccountID  uint64     `json:"accountId" gorm:"index"`
+	Scopes     []string   `json:"scopes,omitempty" gorm:"serializer:json"`
+	Meta       *types.Map `json:"meta,omitempty" gorm:"serializer:json"`
+	ExpiresAt  *time.Time `json:"expiresAt,omitempty"`
+	LastUsedAt *time.Time `json:"lastUsedAt,omitempty"`
+	RevokedAt  *time.Time `json:"revokedAt,omitempty"`
+	CreatedAt  time.Time  `json:"createdAt"`
+}
+
+// APIKeyOptions describes the API key to generate.
+type APIKeyOptions struct {
+	Name      string
+	AccountID uint64
+	Scopes    []string
+	Meta      *types.Map
+	ExpiresIn time.Duration // Zero never expires
+}
+
+// APIKeyManager generates and validates API keys stored with GORM.
+type APIKeyManager struct {
+	db *gorm.DB
+}
+
+// NewAPIKeyManager initializes a new APIKeyManager and migrates the APIKey schema.
+func NewAPIKeyManager(db *gorm.DB) *APIKeyManager {
+	db.AutoMigrate(&APIKey{})
+	return &APIKeyManager{db: db}
+}
+
+// Generate creates a new API key. The returned key is the only copy of the
+// secret, it cannot be retrieved later.
+func (m *APIKeyManager) Generate(opts APIKeyOptions) (string, *APIKey, error) {
+	secret, err := utils.GenerateRandomString(apiKeySecretLength)
+	if err != nil {
+		return "", nil, fmt.Errorf("failed to generate API key secret: %w", err)
+	}
+
+	entry := &APIKey{
+		SecretHash: hashAPIKeySecret(secret),
+		Name:       opts.Name,
+		AccountID:  opts.AccountID,
+		Scopes:     opts.Scopes,
+		Meta:       opts.Meta,
+	}
+	if opts.ExpiresIn > 0 {
+		expiresAt := time.Now().Add(opts.ExpiresIn)
+		entry.ExpiresAt = &expiresAt
+	}
+
+	// Retry on the unlikely prefix collision
+	for attempt := 1; ; attempt++ {
+		entry.Prefix, err = utils.GenerateRandomString(apiKeyPrefixLength)
+		if err != nil {
+			return "", nil, fmt.Errorf("failed to generate API key prefix: %w", err)
+		}
+
+		err = m.db.Create(entry).Error
+		if err == nil {
+			break
+		}
+		if attempt == apiKeyMaxAttempts {
+			return "", nil, fmt.Errorf("failed to save API key: %w", err)
+		}
+		entry.ID = 0
+	}
+
+	return entry.Prefix + "." + secret, entry, nil
+}
+
+// Validate returns the API key matching key if it is neither revoked nor
+// expired, and records its use. Failing to record the use is only logged.
+func (m *APIKeyManager) Validate(key string) (*APIKey, error) {
+	if !IsApiKey(key) {
+		return nil, ErrInvalidAPIKey
+	}
+	prefix, secret, _ := strings.Cut(key, ".")
+
+	entry, err := m.Get(prefix)
+	if err != nil {
+		return nil, err
+	}
+
+	if subtle.ConstantTimeCompare([]byte(entry.SecretHash), []byte(hashAPIKeySecret(secret))) != 1 {
+		return nil, ErrInvalidAPIKey
+	}
+	if entry.RevokedAt != nil {
+		return nil, ErrAPIKeyRevoked
+	}
+
+	now := time.Now()
+	if entry.ExpiresAt != nil && now.After(*entry.ExpiresAt) {
+		return nil, ErrAPIKeyExpired
+	}
+
+	if entry.LastUsedAt == nil || now.Sub(*entry.LastUsedAt) > apiKeyLastUsedInterval {
+		if err := m.db.Model(entry).Update("last_used_at", now).Error; err != nil {
+			system.Logger.Errorf("Error updating API key last use: %v", err)
+		}
+	}
+
+	return entry, nil
+}
+
+// Get returns the API key with the given prefix.
+func (m *APIKeyManager) Get(prefix string) (*APIKey, error) {
+	entry := &APIKey{}
+	err := m.db.Where("prefix = ?", prefix).First(entry).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, ErrInvalidAPIKey
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get API key: %w", err)
+	}
+	return entry, nil
+}
+
+// List returns the API keys of an account, including revoked and expired ones.
+func (m *APIKeyManager) List(accountID uint64) ([]APIKey, error) {
+	var keys []APIKey
+	err := m.db.Where("account_id = ?", accountID).Order("created_at DESC").Find(&keys).Error
+	return keys, err
+}
+
+// Revoke revokes the API key with the given prefix.
+func (m *APIKeyManager) Revoke(prefix string) error {
+	result := m.db.Model(&APIKey{}).
+		Where("prefix = ? AND revoked_at IS NULL", prefix).
+		Update("revoked_at", time.Now())
+	if result.Error != nil {
+		return fmt.Errorf("failed to revoke API key: %w", result.Error)
+	}
+	if result.RowsAffected == 0 {
+		return ErrInvalidAPIKey
+	}
+	return nil
+}
+
+// UpdateMeta replaces the metadata of the API key with the given prefix.
+func (m *APIKeyManager) UpdateMeta(prefix string, meta *types.Map) error {
+	entry, err := m.Get(prefix)
+	if err != nil {
+		return err
+	}
+	entry.Meta = meta
+	return m.db.Model(entry).Select("meta").Updates(entry).Error
+}
+
+// APIKeyMiddleware validates API keys locally, by default from the
+// Authorization header or the apikey query. The account of the key is set
+// like RemoteAPIKeyMiddleware does, the key itself is read with CurrentAPIKey.
+func APIKeyMiddleware(manager *APIKeyManager, extractTokens ...string) fiber.Handler {
+	if len(extractTokens) == 0 {
+		extractTokens = []string{"header:Authorization,query:apikey"}
+	}
+	extractToken := MustCompileTokenLookup(extractTokens...)
+
+	return func(ctx *fiber.Ctx) error {
+		token := extractToken(ctx)
+		if token == "" {
+			return api.ErrorUnauthorizedResp(ctx, "Missing apikey")
+		}
+
+		key, err := manager.Validate(token)
+		if errors.Is(err, ErrInvalidAPIKey) || errors.Is(err, ErrAPIKeyExpired) || errors.Is(err, ErrAPIKeyRevoked) {
+			return api.ErrorUnauthorizedResp(ctx, err.Error())
+		}
+		if err != nil {
+			system.Logger.Errorf("Error validating API key: %v", err)
+			return api.ErrorInternalServerErrorResp(ctx, "Failed to validate apikey")
+		}
+
+		act := &AuthTokenData{
+			ID:     key.AccountID,
+			Scopes: key.Scopes,
+			Meta:   key.Meta,
+		}
+
+		SetAccount(ctx, act, token)
+		SetAPIKey(ctx, key)
+
+		return ctx.Next()
+	}
+}
+
+func hashAPIKeySecret(secret string) string {
+	sum := sha256.Sum256([]byte(secret))
+	return hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,158 @@
+package auth
+
+import (
+	"errors"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/arqut/common/types"
+	"github.com/gofiber/fiber/v2"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+)
+
+func setupAPIKeyManager(t *testing.T) (*APIKeyManager, *gorm.DB) {
+	db, err := gorm.Open(sqlite.Open(":memory:"), &gorm.Config{})
+	require.NoError(t, err, "Should connect to in-memory SQLite without error")
+	return NewAPIKeyManager(db), db
+}
+
+func TestAPIKeyManager_GenerateAndValidate(t *testing.T) {
+	manager, db := setupAPIKeyManager(t)
+
+	meta := &types.Map{"integration": "billing"}
+	key, entry, err := manager.Generate(APIKeyOptions{
+		Name:      "billing",
+		AccountID: 7,
+		Scopes:    []string{"read:invoices"},
+		Meta:      meta,
+	})
+	require.NoError(t, err, "Generate should not return an error")
+	assert.True(t, IsApiKey(key), "Generated key should have the API key shape")
+	assert.Equal(t, entry.Prefix, key[:8], "Key should start with its prefix")
+
+	var stored APIKey
+	require.NoError(t, db.First(&stored, entry.ID).Error)
+	assert.NotContains(t, stored.SecretHash, key[9:], "Secret should not be stored in clear")
+
+	validated, err := manager.Validate(key)
+	require.NoError(t, err, "Validate should not return an error")
+	assert.Equal(t, uint64(7), validated.AccountID, "Validated key should belong to the account")
+	assert.Equal(t, []string{"read:invoices"}, validated.Scopes, "Scopes should be stored")
+	assert.Equal(t, meta, validated.Meta, "Metadata should be stored")
+
+	require.NoError(t, db.First(&stored, entry.ID).Error)
+	assert.NotNil(t, stored.LastUsedAt, "Validate should record the last use")
+
+	_, err = manager.Validate(entry.Prefix + ".wrong-secret")
+	assert.ErrorIs(t, err, ErrInvalidAPIKey, "Wrong secret should be rejected")
+	_, err = manager.Validate("unknown1.secret")
+	assert.ErrorIs(t, err, ErrInvalidAPIKey, "Unknown prefix should be rejected")
+	_, err = manager.Validate("not-an-api-key")
+	assert.ErrorIs(t, err, ErrInvalidAPIKey, "Malformed key should be rejected")
+}
+
+func TestAPIKeyManager_ExpiryAndRevocation(t *testing.T) {
+	manager, _ := setupAPIKeyManager(t)
+
+	expiring, _, err := manager.Generate(APIKeyOptions{AccountID: 1, ExpiresIn: 50 * time.Millisecond})
+	require.NoError(t, err, "Generate should not return an error")
+	time.Sleep(100 * time.Millisecond)
+	_, err = manager.Validate(expiring)
+	assert.ErrorIs(t, err, ErrAPIKeyExpired, "Expired key should be rejected")
+
+	revoked, entry, err := manager.Generate(APIKeyOptions{AccountID: 1})
+	require.NoError(t, err, "Generate should not return an error")
+	require.NoError(t, manager.Revoke(entry.Prefix), "Revoke should not return an error")
+	_, err = manager.Validate(revoked)
+	assert.ErrorIs(t, err, ErrAPIKeyRevoked, "Revoked key should be rejected")
+	assert.ErrorIs(t, manager.Revoke(entry.Prefix), ErrInvalidAPIKey, "Revoking twice should fail")
+
+	keys, err := manager.List(1)
+	require.NoError(t, err, "List should not return an error")
+	assert.Len(t, keys, 2, "List should return all the keys of the account")
+}
+
+func TestAPIKeyManager_UpdateMeta(t *testing.T) {
+	manager, _ := setupAPIKeyManager(t)
+
+	key, entry, err := manager.Generate(APIKeyOptions{AccountID: 1})
+	require.NoError(t, err, "Generate should not return an error")
+
+	meta := &types.Map{"owner": "ops"}
+	require.NoError(t, manager.UpdateMeta(entry.Prefix, meta), "UpdateMeta should not return an error")
+
+	validated, err := manager.Validate(key)
+	require.NoError(t, err, "Validate should not return an error")
+	assert.Equal(t, meta, validated.Meta, "Metadata should be updated")
+}
+
+func TestAPIKeyMiddleware(t *testing.T) {
+	manager, _ := setupAPIKeyManager(t)
+
+	key, _, err := manager.Generate(APIKeyOptions{AccountID: 9, Scopes: []string{"read:users"}})
+	require.NoError(t, err, "Generate should not return an error")
+
+	app := fiber.New()
+	app.Get("/", APIKeyMiddleware(manager), RequireScopes("read:users"), func(c *fiber.Ctx) error {
+		act := c.Locals("account").(*AuthTokenData)
+		assert.Equal(t, uint64(9), act.ID, "Account should be the key owner")
+		apiKey, ok := CurrentAPIKey(c)
+		require.True(t, ok, "API key should be set")
+		assert.Equal(t, uint64(9), apiKey.AccountID, "API key should be the validated key")
+		ctxKey, ok := APIKeyFromContext(c.UserContext())
+		require.True(t, ok, "API key should be propagated to the user context")
+		assert.Same(t, apiKey, ctxKey, "User context should carry the API key")
+		return c.SendStatus(fiber.StatusOK)
+	})
+
+	req := httptest.NewRequest("GET", "/", nil)
+	req.Header.Set("Authorization", "Bearer "+key)
+	resp, err := app.Test(req)
+	require.NoError(t, err, "Request should not fail")
+	assert.Equal(t, fiber.StatusOK, resp.StatusCode, "Valid key should be accepted")
+
+	resp, err = app.Test(httptest.NewRequest("GET", "/?apikey="+key, nil))
+	require.NoError(t, err, "Request should not fail")
+	assert.Equal(t, fiber.StatusOK, resp.StatusCode, "Key should be read from the query")
+
+	resp, err = app.Test(httptest.NewRequest("GET", "/?apikey="+key[:9]+"invalid", nil))
+	require.NoError(t, err, "Request should not fail")
+	assert.Equal(t, fiber.StatusUnauthorized, resp.StatusCode, "Invalid key should be rejected")
+
+	resp, err = app.Test(httptest.NewRequest("GET", "/", nil))
+	require.NoError(t, err, "Request should not fail")
+	assert.Equal(t, fiber.StatusUnauthorized, resp.StatusCode, "Missing key should be rejected")
+}
+
+func TestAPIKeyMiddleware_StoreErrors(t *testing.T) {
+	manager, db := setupAPIKeyManager(t)
+
+	key, _, err := manager.Generate(APIKeyOptions{AccountID: 9})
+	require.NoError(t, err, "Generate should not return an error")
+
+	app := fiber.New()
+	app.Get("/", APIKeyMiddleware(manager), func(c *fiber.Ctx) error {
+		return c.SendStatus(fiber.StatusOK)
+	})
+	request := func() int {
+		req := httptest.NewRequest("GET", "/", nil)
+		req.Header.Set("Authorization", "Bearer "+key)
+		resp, err := app.Test(req)
+		require.NoError(t, err, "Request should not fail")
+		return resp.StatusCode
+	}
+
+	db.Callback().Update().Before("gorm:update").Register("fail_updates", func(tx *gorm.DB) {
+		tx.AddError(errors.New("database is read-only"))
+	})
+	assert.Equal(t, fiber.StatusOK, request(), "Failing to record the use should not reject valid keys")
+
+	sqlDB, err := db.DB()
+	require.NoError(t, err, "DB should not return an error")
+	require.NoError(t, sqlDB.Close(), "Close should not return an error")
+	assert.Equal(t, fiber.StatusInternalServerError, request(), "Store errors should not be reported as invalid keys")
+}
@@ -0,0 +1,67 @@
+package auth
+
+import (
+	"sync"
+	"time"
+
+	"github.com/arqut/common/system"
+	"github.com/arqut/common/utils"
+)
+
+// remoteBreaker guards the auth service called by RemoteAccount.
+var remoteBreaker = &circuitBreaker{}
+
+// circuitBreaker opens after AUTH_BREAKER_THRESHOLD consecutive failures and
+// rejects calls for AUTH_BREAKER_COOLDOWN. A single probe call is then let
+// through, its success closes the breaker and its failure opens it again.
+type circuitBreaker struct {
+	mu        sync.Mutex
+	failures  int
+	openUntil time.Time
+	probing   bool
+}
+
+// allow reports whether a call may reach the service.
+func (b *circuitBreaker) allow() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.failures < breakerThreshold() {
+		return true
+	}
+	if time.Now().Before(b.openUntil) || b.probing {
+		return false
+	}
+	b.probing = true
+	return true
+}
+
+// success closes the breaker.
+func (b *circuitBreaker) success() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.failures = 0
+	b.probing = false
+}
+
+// failure records a failed call and opens the breaker once the threshold is reached.
+func (b *circuitBreaker) failure() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.failures++
+	b.probing = false
+	if b.failures >= breakerThreshold() {
+		cooldown, _ := utils.ParseDuration(system.Env("AUTH_BREAKER_COOLDOWN", "30s"))
+		b.openUntil = time.Now().Add(cooldown)
+	}
+}
+
+func breakerThreshold() int {
+	threshold := system.EnvInt("AUTH_BREAKER_THRESHOLD", 5)
+	if threshold <= 0 {
+		return 5
+	}
+	return threshold
+}
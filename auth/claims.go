@@ -0,0 +1,130 @@
+package auth
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	commonJWT "github.com/arqut/common/jwt"
+	"github.com/arqut/common/system"
+	"github.com/arqut/common/utils"
+)
+
+// RegisteredClaims are the RFC 7519 registered claims, embed them in custom
+// claims to have them set by GenerateClaims and validated by ParseClaims.
+type RegisteredClaims struct {
+	Subject   string   `json:"sub,omitempty"`
+	Issuer    string   `json:"iss,omitempty"`
+	Audience  []string `json:"aud,omitempty"`
+	ID        string   `json:"jti,omitempty"`
+	NotBefore int64    `json:"nbf,omitempty"`
+	IssuedAt  int64    `json:"iat,omitempty"`
+	ExpiresAt int64    `json:"exp,omitempty"`
+}
+
+// Claims is implemented by structs embedding RegisteredClaims.
+type Claims interface {
+	GetRegisteredClaims() *RegisteredClaims
+}
+
+func (c *RegisteredClaims) GetRegisteredClaims() *RegisteredClaims {
+	return c
+}
+
+// GenerateClaims issues a token carrying claims serialized as JSON. When
+// claims embed RegisteredClaims, their ID, IssuedAt and ExpiresAt are set and
+// sub, iss, aud and nbf are copied to the token headers, so they are checked
+// by ParseClaims validators such as commonJWT.WithAudience.
+func GenerateClaims[T any](keyManager *commonJWT.KeyManager, claims *T, expiration ...time.Duration) (*string, error) {
+	var duration time.Duration
+	if len(expiration) > 0 {
+		duration = expiration[0]
+	} else {
+		duration, _ = utils.ParseDuration(system.Env("JWT_DURATION", "2h"))
+	}
+
+	jti, err := commonJWT.GenerateTokenID()
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	headers := map[string]interface{}{
+		"jti": jti,
+		"iat": now.Unix(),
+	}
+	if duration > 0 {
+		headers["exp"] = now.Add(duration).Unix()
+	}
+
+	if c, ok := any(claims).(Claims); ok {
+		registered := c.GetRegisteredClaims()
+		registered.ID = jti
+		registered.IssuedAt = now.Unix()
+		if duration > 0 {
+			registered.ExpiresAt = now.Add(duration).Unix()
+		}
+
+		if registered.Subject != "" {
+			headers["sub"] = registered.Subject
+		}
+		if registered.Issuer != "" {
+			headers["iss"] = registered.Issuer
+		}
+		if len(registered.Audience) > 0 {
+			headers["aud"] = registered.Audience
+		}
+		if registered.NotBefore != 0 {
+			headers["nbf"] = registered.NotBefore
+		}
+	}
+
+	mashalledData, err := json.Marshal(claims)
+	if err != nil {
+		return nil, err
+	}
+
+	token, err := keyManager.IssueJWE(mashalledData, &commonJWT.JWEOptions{Headers: headers})
+	if err != nil {
+		return nil, err
+	}
+
+	tokenStr := string(token)
+	return &tokenStr, nil
+}
+
+// tokenUseHeader marks tokens issued with the key manager for another purpose
+// than a session, like magic links, ParseClaims rejects them.
+const tokenUseHeader = "token_use"
+
+// ParseClaims decrypts a token issued by GenerateClaims into a new T. exp and
+// nbf are always checked, further checks are added through opts.
+func ParseClaims[T any](keyManager *commonJWT.KeyManager, token string, opts ...commonJWT.ValidatorOption) (*T, error) {
+	if token == "" {
+		return nil, fmt.Errorf("%w: empty token", ErrMalformedToken)
+	}
+
+	opts = append([]commonJWT.ValidatorOption{rejectTokenUse}, opts...)
+	decrypted, err := keyManager.Decrypt([]byte(token), opts...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decrypt token: %w", err)
+	}
+
+	dec := json.NewDecoder(bytes.NewReader(decrypted))
+
+	claims := new(T)
+	if err := dec.Decode(claims); err != nil {
+		return nil, fmt.Errorf("%w: failed to decode token payload: %w", ErrMalformedToken, err)
+	}
+
+	return claims, nil
+}
+
+// rejectTokenUse rejects the tokens marked with tokenUseHeader.
+var rejectTokenUse = commonJWT.WithValidator(func(headers commonJWT.Headers) error {
+	if headers.Has(tokenUseHeader) {
+		return fmt.Errorf("%w: not a session token", ErrMalformedToken)
+	}
+	return nil
+})
@@ -0,0 +1,82 @@
+package auth
+
+import (
+	"testing"
+	"time"
+
+	commonJWT "github.com/arqut/common/jwt"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type tenantClaims struct {
+	RegisteredClaims
+	TenantID string   `json:"tenantId"`
+	Roles    []string `json:"roles"`
+}
+
+func TestGenerateAndParseClaims(t *testing.T) {
+	km := setupKeyManager(t, 24*time.Hour)
+	defer km.Shutdown()
+
+	claims := &tenantClaims{
+		RegisteredClaims: RegisteredClaims{
+			Subject:  "user-1",
+			Issuer:   "auth",
+			Audience: []string{"api"},
+		},
+		TenantID: "tenant-1",
+		Roles:    []string{"admin"},
+	}
+
+	token, err := GenerateClaims(km, claims, time.Hour)
+	require.NoError(t, err, "GenerateClaims should not return an error")
+	assert.NotEmpty(t, claims.ID, "GenerateClaims should set the jti")
+	assert.WithinDuration(t, time.Now().Add(time.Hour), time.Unix(claims.ExpiresAt, 0), 2*time.Second, "GenerateClaims should set the expiry")
+
+	parsed, err := ParseClaims[tenantClaims](km, *token,
+		commonJWT.WithAudience("api"),
+		commonJWT.WithIssuer("auth"),
+		commonJWT.WithSubject("user-1"),
+	)
+	require.NoError(t, err, "ParseClaims should not return an error")
+	assert.Equal(t, claims, parsed, "Parsed claims should match the original claims")
+
+	_, err = ParseClaims[tenantClaims](km, *token, commonJWT.WithAudience("web"))
+	assert.ErrorIs(t, err, ErrAudienceMismatch, "Registered claims should be validated")
+	_, err = ParseClaims[tenantClaims](km, *token, commonJWT.WithIssuer("other"))
+	assert.ErrorIs(t, err, commonJWT.ErrIssuerMismatch, "Registered claims should be validated")
+}
+
+func TestParseClaims_NotBefore(t *testing.T) {
+	km := setupKeyManager(t, 24*time.Hour)
+	defer km.Shutdown()
+
+	claims := &tenantClaims{
+		RegisteredClaims: RegisteredClaims{NotBefore: time.Now().Add(time.Hour).Unix()},
+	}
+	token, err := GenerateClaims(km, claims)
+	require.NoError(t, err, "GenerateClaims should not return an error")
+
+	_, err = ParseClaims[tenantClaims](km, *token)
+	assert.ErrorIs(t, err, ErrTokenNotYetValid, "nbf should be validated")
+}
+
+func TestParseClaims_PlainStruct(t *testing.T) {
+	km := setupKeyManager(t, 24*time.Hour)
+	defer km.Shutdown()
+
+	type plainClaims struct {
+		Scope string `json:"scope"`
+	}
+
+	token, err := GenerateClaims(km, &plainClaims{Scope: "read"})
+	require.NoError(t, err, "GenerateClaims should not return an error")
+
+	parsed, err := ParseClaims[plainClaims](km, *token)
+	require.NoError(t, err, "ParseClaims should not return an error")
+	assert.Equal(t, "read", parsed.Scope, "Claims without RegisteredClaims should round trip")
+
+	_, err = ParseClaims[plainClaims](km, "")
+	assert.ErrorIs(t, err, ErrMalformedToken, "ParseClaims should reject empty tokens")
+}
@@ -0,0 +1,106 @@
+package auth
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// Locals written by SetAccount, kept for handlers reading them directly.
+const (
+	localsAuthToken = "authToken"
+	localsAccount   = "account"
+	localsUintID    = "uiID"
+	localsStringID  = "usID"
+	localsAPIKey    = "apiKey"
+)
+
+type contextKey int
+
+const (
+	accountContextKey contextKey = iota
+	tokenContextKey
+	apiKeyContextKey
+)
+
+// SetAccount stores the authenticated principal of the request and the token
+// it was authenticated with, both in the fiber locals and in the user context
+// so non-fiber code receiving c.UserContext() can read them.
+func SetAccount(c *fiber.Ctx, act *AuthTokenData, token string) {
+	if token != "" {
+		c.Locals(localsAuthToken, token)
+	}
+	c.Locals(localsAccount, act)
+	c.Locals(localsUintID, act.ID)
+	c.Locals(localsStringID, fmt.Sprintf("%d", act.ID))
+
+	c.SetUserContext(ContextWithAccount(c.UserContext(), act, token))
+}
+
+// Account returns the authenticated principal of the request, ok is false
+// when no auth middleware authenticated it.
+func Account(c *fiber.Ctx) (act *AuthTokenData, ok bool) {
+	act, ok = c.Locals(localsAccount).(*AuthTokenData)
+	return act, ok && act != nil
+}
+
+// MustAccount returns the authenticated principal of the request, it panics
+// when the route is not behind an auth middleware.
+func MustAccount(c *fiber.Ctx) *AuthTokenData {
+	act, ok := Account(c)
+	if !ok {
+		panic("auth: no authenticated account, is the route behind an auth middleware?")
+	}
+	return act
+}
+
+// Token returns the token the request was authenticated with, or an empty
+// string.
+func Token(c *fiber.Ctx) string {
+	token, _ := c.Locals(localsAuthToken).(string)
+	return token
+}
+
+// SetAPIKey stores the API key the request was authenticated with, in the
+// fiber locals and in the user context like SetAccount.
+func SetAPIKey(c *fiber.Ctx, key *APIKey) {
+	c.Locals(localsAPIKey, key)
+	c.SetUserContext(context.WithValue(c.UserContext(), apiKeyContextKey, key))
+}
+
+// CurrentAPIKey returns the API key the request was authenticated with by
+// APIKeyMiddleware, ok is false for other authentication methods.
+func CurrentAPIKey(c *fiber.Ctx) (key *APIKey, ok bool) {
+	key, ok = c.Locals(localsAPIKey).(*APIKey)
+	return key, ok && key != nil
+}
+
+// ContextWithAccount returns a copy of ctx carrying the principal and its token.
+func ContextWithAccount(ctx context.Context, act *AuthTokenData, token string) context.Context {
+	ctx = context.WithValue(ctx, accountContextKey, act)
+	if token != "" {
+		ctx = context.WithValue(ctx, tokenContextKey, token)
+	}
+	return ctx
+}
+
+// AccountFromContext returns the principal stored by SetAccount or
+// ContextWithAccount.
+func AccountFromContext(ctx context.Context) (act *AuthTokenData, ok bool) {
+	act, ok = ctx.Value(accountContextKey).(*AuthTokenData)
+	return act, ok && act != nil
+}
+
+// TokenFromContext returns the token stored by SetAccount or
+// ContextWithAccount, or an empty string.
+func TokenFromContext(ctx context.Context) string {
+	token, _ := ctx.Value(tokenContextKey).(string)
+	return token
+}
+
+// APIKeyFromContext returns the API key stored by SetAPIKey.
+func APIKeyFromContext(ctx context.Context) (key *APIKey, ok bool) {
+	key, ok = ctx.Value(apiKeyContextKey).(*APIKey)
+	return key, ok && key != nil
+}
@@ -0,0 +1,79 @@
+package auth
+
+import (
+	"context"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSetAccount(t *testing.T) {
+	app := fiber.New()
+	app.Get("/", func(c *fiber.Ctx) error {
+		SetAccount(c, &AuthTokenData{ID: 7, IsAdmin: true}, "token")
+
+		act, ok := Account(c)
+		require.True(t, ok, "Account should be set")
+		assert.Equal(t, uint64(7), act.ID, "Account should be returned")
+		assert.Same(t, act, MustAccount(c), "MustAccount should return the account")
+		assert.Equal(t, "token", Token(c), "Token should be returned")
+		assert.True(t, IsAdmin(c), "IsAdmin should read the account")
+		assert.Equal(t, uint64(7), c.Locals("uiID"), "Numeric ID should be kept in locals")
+		assert.Equal(t, "7", c.Locals("usID"), "String ID should be kept in locals")
+
+		ctxAct, ok := AccountFromContext(c.UserContext())
+		require.True(t, ok, "Account should be propagated to the user context")
+		assert.Same(t, act, ctxAct, "User context should carry the account")
+		assert.Equal(t, "token", TokenFromContext(c.UserContext()), "User context should carry the token")
+		return c.SendStatus(fiber.StatusOK)
+	})
+
+	resp, err := app.Test(httptest.NewRequest("GET", "/", nil))
+	require.NoError(t, err, "Request should not fail")
+	assert.Equal(t, fiber.StatusOK, resp.StatusCode, "Handler assertions should pass")
+}
+
+func TestAccount_Missing(t *testing.T) {
+	app := fiber.New()
+	app.Get("/", func(c *fiber.Ctx) error {
+		_, ok := Account(c)
+		assert.False(t, ok, "Account should not be set")
+		assert.Empty(t, Token(c), "Token should not be set")
+		assert.Panics(t, func() { MustAccount(c) }, "MustAccount should panic without account")
+
+		c.Locals("account", "not an account")
+		_, ok = Account(c)
+		assert.False(t, ok, "Foreign locals should be ignored")
+		assert.False(t, IsAdmin(c), "IsAdmin should not panic on foreign locals")
+		return c.SendStatus(fiber.StatusOK)
+	})
+
+	resp, err := app.Test(httptest.NewRequest("GET", "/", nil))
+	require.NoError(t, err, "Request should not fail")
+	assert.Equal(t, fiber.StatusOK, resp.StatusCode, "Handler assertions should pass")
+
+	_, ok := AccountFromContext(context.Background())
+	assert.False(t, ok, "Empty context should not carry an account")
+}
+
+func TestRemoteMiddleware_UserContext(t *testing.T) {
+	mockAuthAPI(t)
+
+	app := fiber.New()
+	app.Get("/", RemoteMiddleware(), func(c *fiber.Ctx) error {
+		act, ok := AccountFromContext(c.UserContext())
+		if !ok {
+			return c.SendStatus(fiber.StatusInternalServerError)
+		}
+		return c.JSON(fiber.Map{"id": act.ID, "token": TokenFromContext(c.UserContext())})
+	})
+
+	req := httptest.NewRequest("GET", "/", nil)
+	req.Header.Set("Authorization", "Bearer abcdefgh.secret")
+	resp, err := app.Test(req)
+	require.NoError(t, err, "Request should not fail")
+	assert.Equal(t, fiber.StatusOK, resp.StatusCode, "Middleware should propagate the account to the user context")
+}
@@ -0,0 +1,44 @@
+package auth
+
+import (
+	"errors"
+
+	commonJWT "github.com/arqut/common/jwt"
+)
+
+// Token errors returned by ParseToken and ParseTokenForAudience, use errors.Is
+// to tell them apart.
+var (
+	ErrMalformedToken   = commonJWT.ErrMalformedToken
+	ErrUnknownKey       = commonJWT.ErrUnknownKey
+	ErrDecryptionFailed = commonJWT.ErrDecryptionFailed
+	ErrTokenExpired     = commonJWT.ErrTokenExpired
+	ErrTokenNotYetValid = commonJWT.ErrTokenNotYetValid
+	ErrAudienceMismatch = commonJWT.ErrAudienceMismatch
+	ErrTokenRevoked     = commonJWT.ErrTokenRevoked
+)
+
+// Refresh token errors returned by RefreshTokenManager.
+var (
+	ErrInvalidRefreshToken = errors.New("invalid refresh token")
+	ErrRefreshTokenExpired = errors.New("refresh token has expired")
+	ErrRefreshTokenReused  = errors.New("refresh token reused, token family revoked")
+)
+
+// API key errors returned by APIKeyManager.
+var (
+	ErrInvalidAPIKey = errors.New("invalid API key")
+	ErrAPIKeyExpired = errors.New("API key has expired")
+	ErrAPIKeyRevoked = errors.New("API key has been revoked")
+)
+
+// ErrAuthServiceUnavailable is returned by RemoteAccount while the auth
+// service is failing, the middlewares answer it with 503.
+var ErrAuthServiceUnavailable = errors.New("auth service unavailable")
+
+// Magic link errors returned by MagicLinkManager.
+var (
+	ErrInvalidMagicLink = errors.New("invalid or expired magic link")
+	ErrInvalidLoginCode = errors.New("invalid or expired login code")
+	ErrMagicLinkUsed    = errors.New("magic link already used")
+)
@@ -1,96 +1,32 @@
 package auth
 
 import (
-	"bytes"
-	"encoding/json"
 	"fmt"
 	"strings"
 	"time"
 
 	commonJWT "github.com/arqut/common/jwt"
-	"github.com/arqut/common/system"
-	"github.com/arqut/common/utils"
 )
 
 func GenerateToken(keyManager *commonJWT.KeyManager, data *AuthTokenData, expiration ...time.Duration) (*string, error) {
-	var duration time.Duration
-	if len(expiration) > 0 {
-		duration = expiration[0]
-	} else {
-		duration, _ = utils.ParseDuration(system.Env("JWT_DURATION", "2h"))
-	}
-
-	mashalledData, err := json.Marshal(data)
-	if err != nil {
-		return nil, err
-	}
-
-	jweOptions := &commonJWT.JWEOptions{
-		ExpiresIn: duration,
-		// Headers: map[string]interface{}{
-		// 	"custom-header": "custom-value",
-		// },
-	}
-
-	token, err := keyManager.IssueJWE(mashalledData, jweOptions)
-	if err != nil {
-		return nil, err
-	}
-
-	tokenStr := string(token)
-	return &tokenStr, nil
+	return GenerateClaims(keyManager, data, expiration...)
 }
 
 func ParseToken(keyManager *commonJWT.KeyManager, token string) (*AuthTokenData, error) {
-	if token == "" {
-		return nil, fmt.Errorf("empty token")
-	}
-
-	decrypted, err := keyManager.DecryptJWE([]byte(token))
-	if err != nil {
-		keyManager.RefreshKeys()
-		decrypted, err = keyManager.DecryptJWE([]byte(token))
-		if err != nil {
-			return nil, fmt.Errorf("failed to decrypt token: %w", err)
-		}
-	}
-
-	dec := json.NewDecoder(bytes.NewReader(decrypted))
-
-	data := &AuthTokenData{}
-
-	// Decode the JSON into the types.Map
-	if err := dec.Decode(data); err != nil {
-		return nil, fmt.Errorf("failed to decode token payload: %w", err)
-	}
-
-	return data, nil
+	return ParseClaims[AuthTokenData](keyManager, token)
 }
 
 func ParseTokenForAudience(keyManager *commonJWT.KeyManager, token string, audience string) (*AuthTokenData, error) {
-	if token == "" {
-		return nil, fmt.Errorf("empty token")
-	}
-
-	decrypted, err := keyManager.DecryptJWEForAudience([]byte(token), audience)
-	if err != nil {
-		keyManager.RefreshKeys()
-		decrypted, err = keyManager.DecryptJWE([]byte(token))
-		if err != nil {
-			return nil, fmt.Errorf("failed to decrypt token: %w", err)
-		}
-	}
-
-	dec := json.NewDecoder(bytes.NewReader(decrypted))
-
-	data := &AuthTokenData{}
+	return ParseClaims[AuthTokenData](keyManager, token, commonJWT.WithAudience(audience))
+}
 
-	// Decode the JSON into the types.Map
-	if err := dec.Decode(data); err != nil {
-		return nil, fmt.Errorf("failed to decode token payload: %w", err)
+// RevokeToken revokes a token issued by GenerateToken until its expiry, the
+// KeyManager must be configured with a revocation store.
+func RevokeToken(keyManager *commonJWT.KeyManager, token string) error {
+	if token == "" {
+		return fmt.Errorf("%w: empty token", ErrMalformedToken)
 	}
-
-	return data, nil
+	return keyManager.Revoke([]byte(token))
 }
 
 func IsApiKey(token string) bool {
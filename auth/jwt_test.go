@@ -54,7 +54,7 @@ func TestGenerateAndParseToken_Success(t *testing.T) {
 	assert.NotNil(t, parsedData, "Parsed data should not be nil")
 
 	// Verify that the parsed data matches the original data
-	assert.Equal(t, data, *parsedData, "Parsed data should match the original data")
+	assert.Equal(t, data, parsedData, "Parsed data should match the original data")
 }
 
 // TestParseToken_Expired tests parsing of an expired token
@@ -79,6 +79,7 @@ func TestParseToken_Expired(t *testing.T) {
 	// Attempt to parse the expired token
 	parsedData, err := ParseToken(km, *token)
 	require.Error(t, err, "ParseToken should return an error for expired token")
+	assert.ErrorIs(t, err, ErrTokenExpired, "ParseToken should report the token as expired")
 	assert.Nil(t, parsedData, "Parsed data should be nil for expired token")
 }
 
@@ -91,6 +92,7 @@ func TestParseToken_InvalidToken(t *testing.T) {
 
 	parsedData, err := ParseToken(km, invalidToken)
 	require.Error(t, err, "ParseToken should return an error for invalid token format")
+	assert.ErrorIs(t, err, ErrMalformedToken, "ParseToken should report the token as malformed")
 	assert.Nil(t, parsedData, "Parsed data should be nil for invalid token")
 }
 
@@ -123,6 +125,41 @@ func TestParseToken_TamperedToken(t *testing.T) {
 	assert.Nil(t, parsedData, "Parsed data should be nil for tampered token")
 }
 
+// TestParseToken_UnknownKey tests parsing of a token issued with keys the KeyManager doesn't hold
+func TestParseToken_UnknownKey(t *testing.T) {
+	rotationPeriod := 24 * time.Hour
+	km := setupKeyManager(t, rotationPeriod)
+
+	store := commonJWT.NewInMemoryKeyStore()
+	store.SaveKey(commonJWT.KeyEntry{
+		Key:    []byte("another_issuer_master_key_32byte"),
+		Info:   []byte("another-issuer-key"),
+		Expiry: time.Now().Add(rotationPeriod),
+	})
+	issuer, err := commonJWT.NewIssuerKeyManager(rotationPeriod, store)
+	require.NoError(t, err, "Failed to initialize KeyManager")
+
+	token, err := GenerateToken(issuer, &AuthTokenData{ID: 1})
+	require.NoError(t, err, "GenerateToken should not return an error")
+
+	parsedData, err := ParseToken(km, *token)
+	require.Error(t, err, "ParseToken should return an error for a token from another issuer")
+	assert.ErrorIs(t, err, ErrUnknownKey, "ParseToken should report the key as unknown")
+	assert.Nil(t, parsedData, "Parsed data should be nil for unknown key")
+}
+
+// TestParseTokenForAudience tests audience validation while parsing
+func TestParseTokenForAudience(t *testing.T) {
+	rotationPeriod := 24 * time.Hour
+	km := setupKeyManager(t, rotationPeriod)
+
+	token, err := GenerateToken(km, &AuthTokenData{ID: 1})
+	require.NoError(t, err, "GenerateToken should not return an error")
+
+	_, err = ParseTokenForAudience(km, *token, "api")
+	assert.ErrorIs(t, err, ErrAudienceMismatch, "ParseTokenForAudience should reject a token without audience")
+}
+
 // TestGenerateToken_CustomExpiration tests generating a token with a custom expiration duration
 func TestGenerateToken_CustomExpiration(t *testing.T) {
 	rotationPeriod := 24 * time.Hour
@@ -147,7 +184,7 @@ func TestGenerateToken_CustomExpiration(t *testing.T) {
 	assert.NotNil(t, parsedData, "Parsed data should not be nil")
 
 	// Verify that the parsed data matches the original data
-	assert.Equal(t, data, *parsedData, "Parsed data should match the original data")
+	assert.Equal(t, data, parsedData, "Parsed data should match the original data")
 }
 
 // TestGenerateToken_DefaultExpiration tests generating a token with default expiration duration
@@ -171,7 +208,7 @@ func TestGenerateToken_DefaultExpiration(t *testing.T) {
 	assert.NotNil(t, parsedData, "Parsed data should not be nil")
 
 	// Verify that the parsed data matches the original data
-	assert.Equal(t, data, *parsedData, "Parsed data should match the original data")
+	assert.Equal(t, data, parsedData, "Parsed data should match the original data")
 }
 
 // TestIsApiKey tests if tokens are valid
@@ -201,3 +238,20 @@ func TestIsApiKey(t *testing.T) {
 		})
 	}
 }
+
+func TestRevokeToken(t *testing.T) {
+	km, err := commonJWT.NewIssuerKeyManager(24*time.Hour, commonJWT.NewInMemoryKeyStore(), commonJWT.KeyManagerOptions{
+		Revocations: commonJWT.NewInMemoryRevocationStore(),
+	})
+	require.NoError(t, err, "Failed to initialize KeyManager")
+	defer km.Shutdown()
+
+	token, err := GenerateToken(km, &AuthTokenData{}, time.Hour)
+	require.NoError(t, err, "GenerateToken should not return an error")
+
+	require.NoError(t, RevokeToken(km, *token), "RevokeToken should not return an error")
+
+	_, err = ParseToken(km, *token)
+	assert.ErrorIs(t, err, ErrTokenRevoked, "ParseToken should reject revoked tokens")
+	assert.ErrorIs(t, RevokeToken(km, ""), ErrMalformedToken, "RevokeToken should reject empty tokens")
+}
@@ -0,0 +1,345 @@
+package auth
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"html/template"
+	"math/big"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/arqut/common/auth/password"
+	"github.com/arqut/common/cache"
+	commonJWT "github.com/arqut/common/jwt"
+	"github.com/arqut/common/mailer"
+	"github.com/arqut/common/system"
+	"github.com/arqut/common/utils"
+)
+
+// magicLinkUse is the tokenUseHeader of magic link tokens.
+const magicLinkUse = "magic_link"
+
+// DefaultMagicLinkTemplate is the email sent by MagicLinkManager.Send.
+var DefaultMagicLinkTemplate = template.Must(template.New("magic-link").Parse(
+	`<p>Hello,</p>
+{{if .Link}}<p><a href="{{.Link}}">Click here to sign in</a>, or enter this code: <b>{{.Code}}</b></p>
+{{else}}<p>Enter this code to sign in: <b>{{.Code}}</b></p>
+{{end}}<p>It expires in {{.ExpiresIn}} minutes and can only be used once. If you did not request it, you can ignore this email.</p>`,
+))
+
+// MagicLinkOptions configures the passwordless login flow of MagicLinkManager.
+type MagicLinkOptions struct {
+	// Expiration of the link and code, defaults to MAGIC_LINK_DURATION or 15
+	// minutes.
+	Expiration time.Duration
+	// URL of the page exchanging the link, the token is added as the token
+	// query parameter. Defaults to MAGIC_LINK_URL, only the code is sent
+	// without URL.
+	URL string
+	// CodeDigits of the one-time code, defaults to 6.
+	CodeDigits int
+	// MaxAttempts of a code before it is dropped, defaults to 5. Every issued
+	// code gets its own attempts.
+	MaxAttempts int
+	// Lockout bounds the wrong codes of an email across reissued codes, as
+	// anyone can request a new code. Its Prefix defaults to Prefix followed by
+	// "lockout:".
+	Lockout password.Lockout
+	// Subject of the email, defaults to "Your sign-in link".
+	Subject string
+	// Template of the email, executed with Email, Link, Code and ExpiresIn in
+	// minutes. Defaults to DefaultMagicLinkTemplate.
+	Template *template.Template
+	// Mail sends the email, defaults to mailer.Send.
+	Mail func(to string, subject string, message string) error
+	// Prefix namespaces the codes and used links in the cache, defaults to
+	// "auth:magic:".
+	Prefix string
+}
+
+// MagicLinkManager issues single use links and codes bound to an email and
+// exchanges them for session tokens.
+type MagicLinkManager struct {
+	keyManager *commonJWT.KeyManager
+	account    func(email string) (*AuthTokenData, error)
+	options    MagicLinkOptions
+}
+
+// magicLinkPayload is the encrypted payload of magic link tokens.
+type magicLinkPayload struct {
+	Email string `json:"email"`
+	JTI   string `json:"jti"`
+}
+
+// magicLinkCode is the cached code of an email.
+type magicLinkCode struct {
+	JTI      string `json:"jti"`
+	CodeHash string `json:"codeHash"`
+}
+
+// NewMagicLinkManager creates a MagicLinkManager issuing links with
+// keyManager, an issuer. account resolves the account of a verified email
+// when exchanging a link or code for a session.
+func NewMagicLinkManager(keyManager *commonJWT.KeyManager, account func(email string) (*AuthTokenData, error), options ...MagicLinkOptions) *MagicLinkManager {
+	var opts MagicLinkOptions
+	if len(options) > 0 {
+		opts = options[0]
+	}
+	if opts.Expiration <= 0 {
+		opts.Expiration, _ = utils.ParseDuration(system.Env("MAGIC_LINK_DURATION", "15m"))
+	}
+	if opts.URL == "" {
+		opts.URL = system.Env("MAGIC_LINK_URL")
+	}
+	if opts.CodeDigits <= 0 {
+		opts.CodeDigits = 6
+	}
+	if opts.MaxAttempts <= 0 {
+		opts.MaxAttempts = 5
+	}
+	if opts.Subject == "" {
+		opts.Subject = "Your sign-in link"
+	}
+	if opts.Template == nil {
+		opts.Template = DefaultMagicLinkTemplate
+	}
+	if opts.Mail == nil {
+		opts.Mail = func(to string, subject string, message string) error {
+			return mailer.Send(to, subject, message)
+		}
+	}
+	if opts.Prefix == "" {
+		opts.Prefix = "auth:magic:"
+	}
+	if opts.Lockout.Prefix == "" {
+		opts.Lockout.Prefix = opts.Prefix + "lockout:"
+	}
+
+	return &MagicLinkManager{
+		keyManager: keyManager,
+		account:    account,
+		options:    opts,
+	}
+}
+
+// Send issues a link and code for email and mails them.
+func (m *MagicLinkManager) Send(email string) error {
+	token, code, err := m.Issue(email)
+	if err != nil {
+		return err
+	}
+
+	var link string
+	if m.options.URL != "" {
+		separator := "?"
+		if strings.Contains(m.options.URL, "?") {
+			separator = "&"
+		}
+		link = m.options.URL + separator + "token=" + url.QueryEscape(token)
+	}
+
+	var message bytes.Buffer
+	err = m.options.Template.Execute(&message, map[string]interface{}{
+		"Email":     email,
+		"Link":      link,
+		"Code":      code,
+		"ExpiresIn": int(m.options.Expiration.Minutes()),
+	})
+	if err != nil {
+		return fmt.Errorf("failed to render magic link email: %w", err)
+	}
+
+	if err := m.options.Mail(email, m.options.Subject, message.String()); err != nil {
+		return fmt.Errorf("failed to send magic link email: %w", err)
+	}
+
+	return nil
+}
+
+// Issue returns a magic link token and a one-time code for email, for custom
+// delivery. Using either consumes both, a new code replaces the previous one.
+func (m *MagicLinkManager) Issue(email string) (token string, code string, err error) {
+	email = normalizeEmail(email)
+
+	jti, err := commonJWT.GenerateTokenID()
+	if err != nil {
+		return "", "", err
+	}
+
+	payload, err := json.Marshal(magicLinkPayload{Email: email, JTI: jti})
+	if err != nil {
+		return "", "", err
+	}
+
+	encrypted, err := m.keyManager.IssueJWE(payload, &commonJWT.JWEOptions{
+		ExpiresIn: m.options.Expiration,
+		Headers: map[string]interface{}{
+			"jti":          jti,
+			tokenUseHeader: magicLinkUse,
+		},
+	})
+	if err != nil {
+		return "", "", err
+	}
+
+	max := new(big.Int).Exp(big.NewInt(10), big.NewInt(int64(m.options.CodeDigits)), nil)
+	n, err := rand.Int(rand.Reader, max)
+	if err != nil {
+		return "", "", fmt.Errorf("failed to generate login code: %w", err)
+	}
+	code = fmt.Sprintf("%0*d", m.options.CodeDigits, n)
+
+	record := magicLinkCode{JTI: jti, CodeHash: hashLoginCode(jti, code)}
+	if err := cache.SetObj(m.key("code:", email), record, m.options.Expiration); err != nil {
+		return "", "", fmt.Errorf("failed to store login code: %w", err)
+	}
+
+	return string(encrypted), code, nil
+}
+
+// VerifyLink checks and consumes a magic link token, returning its email.
+func (m *MagicLinkManager) VerifyLink(token string) (string, error) {
+	decrypted, err := m.keyManager.Decrypt([]byte(token),
+		commonJWT.WithRequiredExpiration(),
+		commonJWT.WithHeader(tokenUseHeader, func(value interface{}) bool { return value == magicLinkUse }),
+	)
+	if err != nil {
+		return "", fmt.Errorf("%w: %w", ErrInvalidMagicLink, err)
+	}
+
+	var payload magicLinkPayload
+	if err := json.Unmarshal(decrypted, &payload); err != nil || payload.Email == "" || payload.JTI == "" {
+		return "", ErrInvalidMagicLink
+	}
+
+	if err := m.consume(payload.Email, payload.JTI); err != nil {
+		return "", err
+	}
+
+	return payload.Email, nil
+}
+
+// VerifyCode checks and consumes the one-time code sent to email. The code is
+// dropped after MaxAttempts wrong guesses, a newly issued code can be tried
+// again until email reaches Lockout.MaxAttempts wrong guesses, codes are then
+// rejected with password.ErrTooManyAttempts until the lock expires.
+func (m *MagicLinkManager) VerifyCode(email string, code string) error {
+	email = normalizeEmail(email)
+
+	locked, err := m.options.Lockout.Locked(email)
+	if err != nil {
+		return fmt.Errorf("failed to read login code failures: %w", err)
+	}
+	if locked {
+		return password.ErrTooManyAttempts
+	}
+
+	var record magicLinkCode
+	if err := cache.GetObj(m.key("code:", email), &record); err != nil {
+		if cache.IsNotFound(err) {
+			return ErrInvalidLoginCode
+		}
+		return fmt.Errorf("failed to read login code: %w", err)
+	}
+
+	attempts, err := cache.Incr(m.attemptsKey(record.JTI), m.options.Expiration)
+	if err != nil {
+		return fmt.Errorf("failed to count login code attempts: %w", err)
+	}
+	if attempts > int64(m.options.MaxAttempts) {
+		cache.Del(m.key("code:", email))
+		return m.fail(email)
+	}
+
+	expected := hashLoginCode(record.JTI, strings.TrimSpace(code))
+	if subtle.ConstantTimeCompare([]byte(expected), []byte(record.CodeHash)) != 1 {
+		return m.fail(email)
+	}
+
+	if err := m.consume(email, record.JTI); err != nil {
+		return err
+	}
+
+	if err := m.options.Lockout.Reset(email); err != nil {
+		system.Logger.Errorf("Error resetting login code failures: %v", err)
+	}
+	return nil
+}
+
+// fail records a wrong code for email and returns ErrInvalidLoginCode.
+func (m *MagicLinkManager) fail(email string) error {
+	if _, err := m.options.Lockout.Fail(email); err != nil {
+		return fmt.Errorf("failed to record login code failure: %w", err)
+	}
+	return ErrInvalidLoginCode
+}
+
+// ExchangeLink verifies a magic link token and returns a session token for
+// the account of its email, issued with GenerateToken.
+func (m *MagicLinkManager) ExchangeLink(token string) (*string, error) {
+	email, err := m.VerifyLink(token)
+	if err != nil {
+		return nil, err
+	}
+	return m.session(email)
+}
+
+// ExchangeCode verifies the code sent to email and returns a session token
+// for its account, issued with GenerateToken.
+func (m *MagicLinkManager) ExchangeCode(email string, code string) (*string, error) {
+	if err := m.VerifyCode(email, code); err != nil {
+		return nil, err
+	}
+	return m.session(normalizeEmail(email))
+}
+
+func (m *MagicLinkManager) session(email string) (*string, error) {
+	act, err := m.account(email)
+	if err != nil {
+		return nil, err
+	}
+	return GenerateToken(m.keyManager, act)
+}
+
+// consume marks the link jti as used, so neither the link nor its code can be
+// used again, and drops the code of email.
+func (m *MagicLinkManager) consume(email string, jti string) error {
+	fresh, err := cache.SetNX(m.options.Prefix+"used:"+jti, "1", m.options.Expiration)
+	if err != nil {
+		return fmt.Errorf("failed to consume magic link: %w", err)
+	}
+	if !fresh {
+		return ErrMagicLinkUsed
+	}
+
+	cache.Del(m.key("code:", email))
+	cache.Del(m.attemptsKey(jti))
+	return nil
+}
+
+// attemptsKey counts the guesses of the code issued with jti, so issuing a
+// new code resets them.
+func (m *MagicLinkManager) attemptsKey(jti string) string {
+	return m.options.Prefix + "attempts:" + jti
+}
+
+// key hashes email so it never appears in the cache keys.
+func (m *MagicLinkManager) key(kind string, email string) string {
+	sum := sha256.Sum256([]byte(email))
+	return m.options.Prefix + kind + hex.EncodeToString(sum[:])
+}
+
+func hashLoginCode(jti string, code string) string {
+	sum := sha256.Sum256([]byte(jti + ":" + code))
+	return hex.EncodeToString(sum[:])
+}
+
+func normalizeEmail(email string) string {
+	return strings.ToLower(strings.TrimSpace(email))
+}
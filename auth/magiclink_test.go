@@ -0,0 +1,188 @@
+package auth
+
+import (
+	"errors"
+	"html"
+	"net/url"
+	"regexp"
+	"testing"
+	"time"
+
+	"github.com/arqut/common/auth/password"
+	"github.com/arqut/common/internal/redistest"
+	commonJWT "github.com/arqut/common/jwt"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// sentMail is an email captured by MagicLinkOptions.Mail.
+type sentMail struct {
+	to      string
+	subject string
+	message string
+}
+
+func setupMagicLinks(t *testing.T, options MagicLinkOptions) (*MagicLinkManager, *commonJWT.KeyManager, *[]sentMail) {
+	redistest.Start(t)
+
+	km := setupKeyManager(t, 24*time.Hour)
+	t.Cleanup(km.Shutdown)
+
+	var mails []sentMail
+	options.Mail = func(to string, subject string, message string) error {
+		mails = append(mails, sentMail{to, subject, message})
+		return nil
+	}
+
+	accounts := map[string]*AuthTokenData{
+		"alice@example.com": {ID: 1, Email: "alice@example.com"},
+	}
+	account := func(email string) (*AuthTokenData, error) {
+		if act, ok := accounts[email]; ok {
+			return act, nil
+		}
+		return nil, errors.New("account not found")
+	}
+
+	return NewMagicLinkManager(km, account, options), km, &mails
+}
+
+var (
+	mailLinkPattern = regexp.MustCompile(`href="([^"]+)"`)
+	mailCodePattern = regexp.MustCompile(`<b>(\d{6})</b>`)
+)
+
+func TestMagicLinkManager_Send(t *testing.T) {
+	m, km, mails := setupMagicLinks(t, MagicLinkOptions{URL: "https://app.example.com/login?next=/home"})
+
+	require.NoError(t, m.Send("Alice@Example.com"), "Send should not return an error")
+	require.Len(t, *mails, 1, "One email should be sent")
+	mail := (*mails)[0]
+	assert.Equal(t, "Alice@Example.com", mail.to, "Email should be sent to the address")
+	assert.Equal(t, "Your sign-in link", mail.subject, "Default subject should be used")
+	assert.Contains(t, mail.message, "15 minutes", "Email should mention the expiration")
+
+	linkMatch := mailLinkPattern.FindStringSubmatch(mail.message)
+	require.Len(t, linkMatch, 2, "Email should contain the link")
+	link, err := url.Parse(html.UnescapeString(linkMatch[1]))
+	require.NoError(t, err, "Link should be valid")
+	assert.Equal(t, "/home", link.Query().Get("next"), "Link should keep the URL query")
+	token := link.Query().Get("token")
+	require.NotEmpty(t, token, "Link should contain the token")
+	require.Len(t, mailCodePattern.FindStringSubmatch(mail.message), 2, "Email should contain the code")
+
+	_, err = ParseToken(km, token)
+	assert.ErrorIs(t, err, ErrMalformedToken, "Magic link tokens should not be accepted as sessions")
+
+	session, err := m.ExchangeLink(token)
+	require.NoError(t, err, "ExchangeLink should not return an error")
+	act, err := ParseToken(km, *session)
+	require.NoError(t, err, "Session token should be valid")
+	assert.Equal(t, uint64(1), act.ID, "Session should belong to the account of the email")
+
+	_, err = m.ExchangeLink(token)
+	assert.ErrorIs(t, err, ErrMagicLinkUsed, "Links should be single use")
+}
+
+func TestMagicLinkManager_Code(t *testing.T) {
+	m, km, _ := setupMagicLinks(t, MagicLinkOptions{})
+
+	token, code, err := m.Issue("alice@example.com")
+	require.NoError(t, err, "Issue should not return an error")
+	assert.Regexp(t, `^\d{6}$`, code, "Code should have 6 digits")
+
+	wrong := "000000"
+	if code == wrong {
+		wrong = "111111"
+	}
+	_, err = m.ExchangeCode("alice@example.com", wrong)
+	assert.ErrorIs(t, err, ErrInvalidLoginCode, "Wrong codes should be rejected")
+
+	session, err := m.ExchangeCode(" Alice@Example.com ", code)
+	require.NoError(t, err, "ExchangeCode should not return an error")
+	act, err := ParseToken(km, *session)
+	require.NoError(t, err, "Session token should be valid")
+	assert.Equal(t, uint64(1), act.ID, "Session should belong to the account of the email")
+
+	_, err = m.ExchangeCode("alice@example.com", code)
+	assert.ErrorIs(t, err, ErrInvalidLoginCode, "Codes should be single use")
+	_, err = m.ExchangeLink(token)
+	assert.ErrorIs(t, err, ErrMagicLinkUsed, "Using the code should consume the link")
+}
+
+func TestMagicLinkManager_CodeAttempts(t *testing.T) {
+	m, _, _ := setupMagicLinks(t, MagicLinkOptions{MaxAttempts: 3})
+
+	_, code, err := m.Issue("alice@example.com")
+	require.NoError(t, err, "Issue should not return an error")
+
+	wrong := "000000"
+	if code == wrong {
+		wrong = "111111"
+	}
+	for range 3 {
+		assert.ErrorIs(t, m.VerifyCode("alice@example.com", wrong), ErrInvalidLoginCode, "Wrong codes should be rejected")
+	}
+	assert.ErrorIs(t, m.VerifyCode("alice@example.com", code), ErrInvalidLoginCode, "Code should be dropped after too many attempts")
+
+	_, code, err = m.Issue("alice@example.com")
+	require.NoError(t, err, "Issue should not return an error")
+	assert.NoError(t, m.VerifyCode("alice@example.com", code), "New codes should get their own attempts")
+}
+
+func TestMagicLinkManager_CodeAttemptsPerCode(t *testing.T) {
+	m, _, _ := setupMagicLinks(t, MagicLinkOptions{MaxAttempts: 3})
+
+	_, _, err := m.Issue("alice@example.com")
+	require.NoError(t, err, "Issue should not return an error")
+	for range 2 {
+		assert.ErrorIs(t, m.VerifyCode("alice@example.com", "not a code"), ErrInvalidLoginCode, "Wrong codes should be rejected")
+	}
+
+	_, code, err := m.Issue("alice@example.com")
+	require.NoError(t, err, "Issue should not return an error")
+	wrong := "000000"
+	if code == wrong {
+		wrong = "111111"
+	}
+	for range 2 {
+		assert.ErrorIs(t, m.VerifyCode("alice@example.com", wrong), ErrInvalidLoginCode, "Wrong codes should be rejected")
+	}
+	assert.NoError(t, m.VerifyCode("alice@example.com", code), "Guesses against a previous code should not count against the new one")
+}
+
+func TestMagicLinkManager_CodeLockout(t *testing.T) {
+	m, _, _ := setupMagicLinks(t, MagicLinkOptions{MaxAttempts: 3, Lockout: password.Lockout{MaxAttempts: 4, Duration: time.Minute}})
+
+	for range 2 {
+		_, _, err := m.Issue("alice@example.com")
+		require.NoError(t, err, "Issue should not return an error")
+		for range 2 {
+			assert.ErrorIs(t, m.VerifyCode("alice@example.com", "not a code"), ErrInvalidLoginCode, "Wrong codes should be rejected")
+		}
+	}
+
+	_, code, err := m.Issue("alice@example.com")
+	require.NoError(t, err, "Issue should not return an error")
+	assert.ErrorIs(t, m.VerifyCode("Alice@example.com", code), password.ErrTooManyAttempts, "Reissued codes should not reset the guesses of an email")
+
+	require.NoError(t, m.options.Lockout.Reset("alice@example.com"), "Reset should not return an error")
+	assert.NoError(t, m.VerifyCode("alice@example.com", code), "Codes should be accepted once the lock is lifted")
+}
+
+func TestMagicLinkManager_VerifyLink(t *testing.T) {
+	m, km, _ := setupMagicLinks(t, MagicLinkOptions{})
+
+	token, _, err := m.Issue("bob@example.com")
+	require.NoError(t, err, "Issue should not return an error")
+	_, err = m.ExchangeLink(token)
+	assert.EqualError(t, err, "account not found", "Unknown accounts should be reported by the lookup")
+
+	session, err := GenerateToken(km, &AuthTokenData{ID: 1})
+	require.NoError(t, err, "GenerateToken should not return an error")
+	_, err = m.VerifyLink(*session)
+	assert.ErrorIs(t, err, ErrInvalidMagicLink, "Session tokens should not be accepted as magic links")
+
+	_, err = m.VerifyLink("not a token")
+	assert.ErrorIs(t, err, ErrInvalidMagicLink, "Malformed links should be rejected")
+}
@@ -0,0 +1,31 @@
+package auth
+
+import (
+	"time"
+
+	"github.com/arqut/common/api"
+	"github.com/gofiber/fiber/v2"
+)
+
+// RequireMFA only lets through accounts that passed a second factor, see
+// AuthTokenData.MFAAt, within maxAge. A zero maxAge accepts any past MFA.
+// Otherwise it responds 403 with the "mfa_required" error detail so clients
+// can prompt for a code and retry with a new token.
+func RequireMFA(maxAge time.Duration) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		act, ok := Account(c)
+		if !ok {
+			return api.ErrorUnauthorizedResp(c, "Unauthorized")
+		}
+
+		if act.MFAAt == 0 || (maxAge > 0 && time.Since(time.Unix(act.MFAAt, 0)) > maxAge) {
+			return api.ErrorResp(c, api.ApiError{
+				Code:    fiber.StatusForbidden,
+				Message: "Forbidden: recent two-factor authentication required",
+				Detail:  "mfa_required",
+			})
+		}
+
+		return c.Next()
+	}
+}
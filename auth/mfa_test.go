@@ -0,0 +1,61 @@
+package auth
+
+import (
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRequireMFA(t *testing.T) {
+	status := func(act *AuthTokenData, maxAge time.Duration) int {
+		app := fiber.New()
+		app.Get("/", func(c *fiber.Ctx) error {
+			if act != nil {
+				SetAccount(c, act, "")
+			}
+			return c.Next()
+		}, RequireMFA(maxAge), func(c *fiber.Ctx) error {
+			return c.SendStatus(fiber.StatusOK)
+		})
+
+		resp, err := app.Test(httptest.NewRequest("GET", "/", nil))
+		require.NoError(t, err, "Request should not fail")
+		return resp.StatusCode
+	}
+
+	recent := time.Now().Add(-time.Minute).Unix()
+	old := time.Now().Add(-time.Hour).Unix()
+
+	assert.Equal(t, fiber.StatusUnauthorized, status(nil, 0), "Unauthenticated requests should be rejected")
+	assert.Equal(t, fiber.StatusForbidden, status(&AuthTokenData{ID: 1}, 0), "Accounts without MFA should be rejected")
+	assert.Equal(t, fiber.StatusOK, status(&AuthTokenData{ID: 1, MFAAt: recent}, 15*time.Minute), "Recent MFA should be accepted")
+	assert.Equal(t, fiber.StatusForbidden, status(&AuthTokenData{ID: 1, MFAAt: old}, 15*time.Minute), "Old MFA should be rejected")
+	assert.Equal(t, fiber.StatusOK, status(&AuthTokenData{ID: 1, MFAAt: old}, 0), "Any MFA should be accepted without max age")
+}
+
+func TestMFAAt_Propagation(t *testing.T) {
+	km := setupKeyManager(t, 24*time.Hour)
+	defer km.Shutdown()
+
+	mfaAt := time.Now().Unix()
+	token, err := GenerateToken(km, &AuthTokenData{ID: 1, MFAAt: mfaAt})
+	require.NoError(t, err, "GenerateToken should not return an error")
+	act, err := ParseToken(km, *token)
+	require.NoError(t, err, "ParseToken should not return an error")
+	assert.Equal(t, mfaAt, act.MFAAt, "MFA time should be kept in the token")
+
+	app := fiber.New()
+	app.Get("/", ProxyAuthMiddleware(ProxyAuthOptions{Secret: proxyTestSecret}), RequireMFA(time.Hour), func(c *fiber.Ctx) error {
+		return c.SendStatus(fiber.StatusOK)
+	})
+	headers := signedProxyHeaders(map[string]string{
+		"X-User-Id":     "1",
+		"X-User-Mfa-At": strconv.FormatInt(mfaAt, 10),
+	})
+	assert.Equal(t, fiber.StatusOK, proxyStatus(t, app, headers), "MFA time should be read from the proxy headers")
+}
@@ -1,15 +1,44 @@
 package auth
 
 import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
 	"errors"
 	"fmt"
+	"strings"
+	"sync"
+	"time"
 
 	"github.com/arqut/common/api"
 	"github.com/arqut/common/cache"
 	"github.com/arqut/common/http"
+	commonJWT "github.com/arqut/common/jwt"
 	"github.com/arqut/common/system"
 	"github.com/arqut/common/utils"
 	"github.com/gofiber/fiber/v2"
+	"golang.org/x/sync/singleflight"
+)
+
+// Cache namespaces used by RemoteAccount.
+const (
+	// remoteAccountCachePrefix namespaces the accounts validated by the auth service.
+	remoteAccountCachePrefix = "auth:account:"
+	// remoteRejectionCachePrefix namespaces the tokens rejected by the auth service.
+	remoteRejectionCachePrefix = "auth:rejected:"
+	// remoteStaleCachePrefix namespaces the accounts served when the auth
+	// service is down and AUTH_FAILURE_POLICY is "open".
+	remoteStaleCachePrefix = "auth:stale:"
+)
+
+var (
+	remoteAccountCacheSecret     []byte
+	remoteAccountCacheSecretOnce sync.Once
+
+	// remoteAccountGroup coalesces concurrent validations of the same token.
+	remoteAccountGroup singleflight.Group
 )
 
 func RemoteAuthMiddleware() fiber.Handler {
@@ -28,45 +57,229 @@ func RemoteMiddleware() fiber.Handler {
 	return remoteMiddleware(extractTokens)
 }
 
+// LocalAuthMiddleware accept both auth_token or apikey like RemoteMiddleware,
+// but validates tokens locally with keyManager, usually a validation only
+// KeyManager. Only API keys and unknown formats are validated remotely.
+func LocalAuthMiddleware(keyManager *commonJWT.KeyManager) fiber.Handler {
+	extractTokens := "header:Authorization,query:auth_token,query:apikey"
+	return accountMiddleware(func(token string) (*AuthTokenData, error) {
+		return LocalAccount(keyManager, token)
+	}, extractTokens)
+}
+
+// RemoteMiddlewareWithLookup is RemoteMiddleware reading the token with
+// tokenLookups, see CompileTokenLookup. Malformed lookups panic.
+func RemoteMiddlewareWithLookup(tokenLookups ...string) fiber.Handler {
+	return remoteMiddleware(tokenLookups...)
+}
+
 func remoteMiddleware(extractTokens ...string) fiber.Handler {
+	return accountMiddleware(RemoteAccount, extractTokens...)
+}
+
+func accountMiddleware(account func(token string) (*AuthTokenData, error), extractTokens ...string) fiber.Handler {
+	extractToken := MustCompileTokenLookup(extractTokens...)
+
 	return func(ctx *fiber.Ctx) error {
-		token := ExtractToken(ctx, extractTokens...)
+		token := extractToken(ctx)
 		if token == "" {
 			return api.ErrorUnauthorizedResp(ctx, "Missing auth token or apikey")
 		}
 
-		act, err := RemoteAccount(token)
+		act, err := account(token)
+		if errors.Is(err, ErrAuthServiceUnavailable) {
+			return api.ErrorCodeResp(ctx, fiber.StatusServiceUnavailable, err.Error())
+		}
 		if err != nil {
 			return api.ErrorUnauthorizedResp(ctx, err.Error())
 		}
 
-		ctx.Locals("authToken", token)
-		ctx.Locals("account", act)
-		ctx.Locals("uiID", act.ID)
-		ctx.Locals("usID", fmt.Sprintf("%d", act.ID))
+		SetAccount(ctx, act, token)
 
 		return ctx.Next()
 	}
 }
 
-func RemoteAccount(token string) (act *AuthTokenData, err error) {
-	act = &AuthTokenData{}
-	err = cache.GetObj(token, act)
+// LocalAccount parses tokens issued by GenerateToken with keyManager, without
+// falling back to the auth service when they are invalid. API keys and
+// tokens of unknown formats are passed to RemoteAccount.
+func LocalAccount(keyManager *commonJWT.KeyManager, token string) (*AuthTokenData, error) {
+	if IsApiKey(token) || !isJWE(token) {
+		return RemoteAccount(token)
+	}
+	return ParseToken(keyManager, token)
+}
 
-	if err != nil || act.ID == 0 {
-		err = nil
-		resp := &AuthValidateResponse{}
-		err := http.Get(system.Env("AUTH_API")+"/auth/validate", resp, "Authorization", "Bearer "+token)
+// isJWE reports whether token has the five parts of a compact JWE.
+func isJWE(token string) bool {
+	return strings.Count(token, ".") == 4
+}
+
+// RemoteAccount validates token with the auth service at AUTH_API and caches
+// the account for AUTH_CACHE_DURATION. Concurrent calls with the same token
+// share a single request and rejections are cached for
+// AUTH_NEGATIVE_CACHE_DURATION. While the auth service is failing, calls are
+// rejected with ErrAuthServiceUnavailable, or served the last validated
+// account when AUTH_FAILURE_POLICY is "open".
+func RemoteAccount(token string) (*AuthTokenData, error) {
+	tokenHash := remoteTokenHash(token)
+
+	act := &AuthTokenData{}
+	if err := cache.GetObj(remoteAccountCachePrefix+tokenHash, act); err == nil && act.ID != 0 {
+		return act, nil
+	}
+	if message, err := cache.Get(remoteRejectionCachePrefix + tokenHash); err == nil {
+		return nil, errors.New(message)
+	}
+
+	result, err, _ := remoteAccountGroup.Do(tokenHash, func() (interface{}, error) {
+		return validateRemoteAccount(token, tokenHash)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	// Callers sharing the request get their own copy
+	shared := *result.(*AuthTokenData)
+	return &shared, nil
+}
+
+// validateRemoteAccount calls the auth service through the circuit breaker
+// and caches its answer.
+func validateRemoteAccount(token string, tokenHash string) (*AuthTokenData, error) {
+	if !remoteBreaker.allow() {
+		return staleRemoteAccount(tokenHash, ErrAuthServiceUnavailable)
+	}
+
+	resp := &AuthValidateResponse{}
+	err := http.Get(system.Env("AUTH_API")+"/auth/validate", resp, "Authorization", "Bearer "+token)
+	if err == nil && resp.Success && resp.Data == nil {
+		err = errors.New("missing account in response")
+	}
+	if err == nil && !resp.Success && resp.Error != nil && resp.Error.Code >= fiber.StatusInternalServerError {
+		err = fmt.Errorf("status %d: %s", resp.Error.Code, resp.Error.Message)
+	}
+	if err != nil {
+		remoteBreaker.failure()
+		return staleRemoteAccount(tokenHash, fmt.Errorf("%w: %w", ErrAuthServiceUnavailable, err))
+	}
+	remoteBreaker.success()
+
+	if !resp.Success {
+		message := "Invalid auth token"
+		if resp.Error != nil && resp.Error.Message != "" {
+			message = resp.Error.Message
+		}
+		duration, _ := utils.ParseDuration(system.Env("AUTH_NEGATIVE_CACHE_DURATION", "30s"))
+		if duration > 0 {
+			cache.Set(remoteRejectionCachePrefix+tokenHash, message, duration)
+		}
+		return nil, errors.New(message)
+	}
+
+	act := resp.Data
+	duration, _ := utils.ParseDuration(system.Env("AUTH_CACHE_DURATION", "1h"))
+	if ttl := remoteAccountTTL(token, duration); ttl > 0 {
+		cache.SetObj(remoteAccountCachePrefix+tokenHash, act, ttl)
+	}
+	if remoteFailOpen() {
+		duration, _ := utils.ParseDuration(system.Env("AUTH_STALE_CACHE_DURATION", "24h"))
+		if ttl := remoteAccountTTL(token, duration); ttl > 0 {
+			cache.SetObj(remoteStaleCachePrefix+tokenHash, act, ttl)
+		}
+	}
+
+	return act, nil
+}
+
+// staleRemoteAccount returns the last account validated for the token when
+// AUTH_FAILURE_POLICY is "open", err otherwise.
+func staleRemoteAccount(tokenHash string, err error) (*AuthTokenData, error) {
+	if !remoteFailOpen() {
+		return nil, err
+	}
+
+	act := &AuthTokenData{}
+	if cacheErr := cache.GetObj(remoteStaleCachePrefix+tokenHash, act); cacheErr != nil || act.ID == 0 {
+		return nil, err
+	}
+	system.Logger.Warnf("Auth service unavailable, serving cached account %d: %v", act.ID, err)
+	return act, nil
+}
+
+// remoteFailOpen reports whether RemoteAccount serves stale accounts while the
+// auth service is down, AUTH_FAILURE_POLICY defaults to "closed".
+func remoteFailOpen() bool {
+	return strings.EqualFold(system.Env("AUTH_FAILURE_POLICY", "closed"), "open")
+}
+
+// InvalidateRemoteAccount drops the account cached by RemoteAccount for token,
+// call it when the token is revoked.
+func InvalidateRemoteAccount(token string) error {
+	tokenHash := remoteTokenHash(token)
+	if err := cache.Del(remoteStaleCachePrefix + tokenHash); err != nil {
+		return err
+	}
+	return cache.Del(remoteAccountCachePrefix + tokenHash)
+}
+
+// remoteTokenHash returns the HMAC-SHA256 of token keyed with
+// AUTH_CACHE_SECRET, so tokens never appear in Redis. Without secret the key
+// falls back to a fixed one namespaced by APP_NAME, so instances still share
+// their cached accounts and invalidations, but anyone reading Redis can test
+// a known token against the keys.
+func remoteTokenHash(token string) string {
+	remoteAccountCacheSecretOnce.Do(func() {
+		if secret := system.Env("AUTH_CACHE_SECRET"); secret != "" {
+			remoteAccountCacheSecret = []byte(secret)
+			return
+		}
+		system.Logger.Warnf("AUTH_CACHE_SECRET is not set, cached remote accounts are keyed by a fixed secret")
+		remoteAccountCacheSecret = []byte("arqut/common/auth:" + system.Env("APP_NAME"))
+	})
+
+	mac := hmac.New(sha256.New, remoteAccountCacheSecret)
+	mac.Write([]byte(token))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// remoteAccountTTL caps duration at the remaining lifetime of JOSE tokens, it
+// is only used to expire the cache earlier.
+func remoteAccountTTL(token string, duration time.Duration) time.Duration {
+	if expiresAt, ok := unverifiedExpiry(token); ok {
+		if remaining := time.Until(expiresAt); remaining < duration {
+			return remaining
+		}
+	}
+	return duration
+}
+
+// unverifiedExpiry reads the exp of a JOSE token from its header, or the
+// payload of a JWS, without verifying the token.
+func unverifiedExpiry(token string) (time.Time, bool) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 && len(parts) != 5 {
+		return time.Time{}, false
+	}
+
+	segments := parts[:1]
+	if len(parts) == 3 {
+		segments = parts[:2]
+	}
+
+	for _, segment := range segments {
+		raw, err := base64.RawURLEncoding.DecodeString(segment)
 		if err != nil {
-			return nil, err
+			continue
+		}
+		var claims struct {
+			Exp *float64 `json:"exp"`
 		}
-		if !resp.Success {
-			return nil, errors.New(resp.Error.Message)
+		if err := json.Unmarshal(raw, &claims); err != nil || claims.Exp == nil {
+			continue
 		}
-		act = resp.Data
-		duration, _ := utils.ParseDuration(system.Env("AUTH_CACHE_DURATION", "1h"))
-		cache.SetObj(token, act, duration)
+		return time.Unix(int64(*claims.Exp), 0), true
 	}
 
-	return act, err
+	return time.Time{}, false
 }
@@ -0,0 +1,287 @@
+package auth
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/arqut/common/api"
+	"github.com/arqut/common/cache"
+	"github.com/arqut/common/internal/redistest"
+	commonJWT "github.com/arqut/common/jwt"
+	"github.com/gofiber/fiber/v2"
+	"github.com/redis/go-redis/v9"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// mockAuthAPI serves AUTH_API/auth/validate, accepting any token as account 99.
+// The cache points to an unreachable Redis so every call reaches the server.
+func mockAuthAPI(t *testing.T) *atomic.Int32 {
+	var calls atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls.Add(1)
+		json.NewEncoder(w).Encode(AuthValidateResponse{Success: true, Data: &AuthTokenData{ID: 99}})
+	}))
+	t.Cleanup(server.Close)
+	t.Setenv("AUTH_API", server.URL)
+
+	cache.InitRedisCache(redis.NewClient(&redis.Options{Addr: "127.0.0.1:1", MaxRetries: -1}))
+	return &calls
+}
+
+func TestLocalAuthMiddleware(t *testing.T) {
+	calls := mockAuthAPI(t)
+
+	store := commonJWT.NewInMemoryKeyStore()
+	issuer, err := commonJWT.NewIssuerKeyManager(24*time.Hour, store)
+	require.NoError(t, err, "Failed to initialize KeyManager")
+	defer issuer.Shutdown()
+	validator, err := commonJWT.NewValidationKeyManager(store)
+	require.NoError(t, err, "Failed to initialize Validation KeyManager")
+	defer validator.Shutdown()
+
+	app := fiber.New()
+	app.Get("/", LocalAuthMiddleware(validator), func(c *fiber.Ctx) error {
+		return c.JSON(c.Locals("account"))
+	})
+	request := func(token string) (int, *AuthTokenData) {
+		req := httptest.NewRequest("GET", "/", nil)
+		req.Header.Set("Authorization", "Bearer "+token)
+		resp, err := app.Test(req)
+		require.NoError(t, err, "Request should not fail")
+		act := &AuthTokenData{}
+		json.NewDecoder(resp.Body).Decode(act)
+		return resp.StatusCode, act
+	}
+
+	token, err := GenerateToken(issuer, &AuthTokenData{ID: 1})
+	require.NoError(t, err, "GenerateToken should not return an error")
+
+	status, act := request(*token)
+	assert.Equal(t, fiber.StatusOK, status, "Local token should be accepted")
+	assert.Equal(t, uint64(1), act.ID, "Account should be read from the token")
+	assert.Equal(t, int32(0), calls.Load(), "Local tokens should not reach the auth service")
+
+	status, _ = request((*token)[:len(*token)-4] + "AAAA")
+	assert.Equal(t, fiber.StatusUnauthorized, status, "Invalid local token should be rejected")
+	assert.Equal(t, int32(0), calls.Load(), "Invalid local tokens should not reach the auth service")
+
+	status, act = request("abcdefgh.secret")
+	assert.Equal(t, fiber.StatusOK, status, "API key should be validated remotely")
+	assert.Equal(t, uint64(99), act.ID, "Account should come from the auth service")
+	assert.Equal(t, int32(1), calls.Load(), "API keys should reach the auth service")
+
+	status, _ = request("opaque-token")
+	assert.Equal(t, fiber.StatusOK, status, "Unknown formats should be validated remotely")
+	assert.Equal(t, int32(2), calls.Load(), "Unknown formats should reach the auth service")
+}
+
+func TestRemoteAccount_Cache(t *testing.T) {
+	calls := mockAuthAPI(t)
+	redisServer := redistest.Start(t)
+
+	km := setupKeyManager(t, 24*time.Hour)
+	defer km.Shutdown()
+	token, err := GenerateToken(km, &AuthTokenData{ID: 1}, 30*time.Minute)
+	require.NoError(t, err, "GenerateToken should not return an error")
+
+	act, err := RemoteAccount(*token)
+	require.NoError(t, err, "RemoteAccount should not return an error")
+	assert.Equal(t, uint64(99), act.ID, "Account should come from the auth service")
+	_, err = RemoteAccount(*token)
+	require.NoError(t, err, "RemoteAccount should not return an error")
+	assert.Equal(t, int32(1), calls.Load(), "Account should be cached")
+
+	keys := redisServer.Keys()
+	require.Len(t, keys, 1, "One account should be cached")
+	assert.True(t, strings.HasPrefix(keys[0], remoteAccountCachePrefix), "Cache key should be namespaced")
+	assert.NotContains(t, keys[0], *token, "Cache key should not contain the token")
+	assert.InDelta(t, 30*time.Minute, redisServer.TTL(keys[0]), float64(time.Minute), "Cache TTL should be capped at the token expiry")
+
+	require.NoError(t, InvalidateRemoteAccount(*token), "InvalidateRemoteAccount should not return an error")
+	_, err = RemoteAccount(*token)
+	require.NoError(t, err, "RemoteAccount should not return an error")
+	assert.Equal(t, int32(2), calls.Load(), "Invalidated account should be fetched again")
+}
+
+func TestRemoteAccountTTL(t *testing.T) {
+	km := setupKeyManager(t, 24*time.Hour)
+	defer km.Shutdown()
+
+	token, err := GenerateToken(km, &AuthTokenData{ID: 1}, 10*time.Minute)
+	require.NoError(t, err, "GenerateToken should not return an error")
+	assert.InDelta(t, 10*time.Minute, remoteAccountTTL(*token, time.Hour), float64(time.Second), "TTL should be capped at the token expiry")
+	assert.Equal(t, 5*time.Minute, remoteAccountTTL(*token, 5*time.Minute), "TTL should not exceed the cache duration")
+
+	header := base64.RawURLEncoding.EncodeToString([]byte(fmt.Sprintf(`{"exp":%d}`, time.Now().Add(-time.Minute).Unix())))
+	expired := header + ".e30.signature"
+	assert.LessOrEqual(t, remoteAccountTTL(expired, time.Hour), time.Duration(0), "Expired tokens should not be cached")
+
+	assert.Equal(t, time.Hour, remoteAccountTTL("abcdefgh.secret", time.Hour), "API keys should use the cache duration")
+}
+
+// flakyAuthAPI serves AUTH_API/auth/validate, accepting any token as account
+// 42 while healthy and failing with a non JSON 500 otherwise.
+func flakyAuthAPI(t *testing.T) (*atomic.Int32, *atomic.Bool) {
+	var calls atomic.Int32
+	var healthy atomic.Bool
+	healthy.Store(true)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls.Add(1)
+		if !healthy.Load() {
+			w.WriteHeader(http.StatusInternalServerError)
+			w.Write([]byte("Internal Server Error"))
+			return
+		}
+		json.NewEncoder(w).Encode(AuthValidateResponse{Success: true, Data: &AuthTokenData{ID: 42}})
+	}))
+	t.Cleanup(server.Close)
+	t.Setenv("AUTH_API", server.URL)
+
+	// Close the circuit breaker left by other tests
+	remoteBreaker = &circuitBreaker{}
+	t.Cleanup(func() { remoteBreaker = &circuitBreaker{} })
+
+	return &calls, &healthy
+}
+
+func TestRemoteAccount_Singleflight(t *testing.T) {
+	redistest.Start(t)
+
+	var calls atomic.Int32
+	release := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls.Add(1)
+		<-release
+		json.NewEncoder(w).Encode(AuthValidateResponse{Success: true, Data: &AuthTokenData{ID: 42}})
+	}))
+	t.Cleanup(server.Close)
+	t.Setenv("AUTH_API", server.URL)
+
+	var wg sync.WaitGroup
+	accounts := make([]*AuthTokenData, 10)
+	errs := make([]error, 10)
+	for i := range accounts {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			accounts[i], errs[i] = RemoteAccount("abcdefgh.shared")
+		}()
+	}
+
+	// Let every call join the pending request
+	time.Sleep(100 * time.Millisecond)
+	close(release)
+	wg.Wait()
+
+	assert.Equal(t, int32(1), calls.Load(), "Concurrent calls should share a single request")
+	for i := range accounts {
+		require.NoError(t, errs[i], "RemoteAccount should not return an error")
+		assert.Equal(t, uint64(42), accounts[i].ID, "Every caller should get the account")
+	}
+	assert.NotSame(t, accounts[0], accounts[1], "Callers should get their own copy of the account")
+}
+
+func TestRemoteAccount_NegativeCache(t *testing.T) {
+	redisServer := redistest.Start(t)
+
+	var calls atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls.Add(1)
+		json.NewEncoder(w).Encode(AuthValidateResponse{
+			Error: &api.ApiError{Code: http.StatusUnauthorized, Message: "Token expired"},
+		})
+	}))
+	t.Cleanup(server.Close)
+	t.Setenv("AUTH_API", server.URL)
+
+	for range 3 {
+		_, err := RemoteAccount("abcdefgh.rejected")
+		assert.EqualError(t, err, "Token expired", "Rejection message should be returned")
+	}
+	assert.Equal(t, int32(1), calls.Load(), "Rejections should be cached")
+
+	keys := redisServer.Keys()
+	require.Len(t, keys, 1, "One rejection should be cached")
+	assert.True(t, strings.HasPrefix(keys[0], remoteRejectionCachePrefix), "Rejection key should be namespaced")
+	assert.InDelta(t, 30*time.Second, redisServer.TTL(keys[0]), float64(time.Second), "Rejections should be cached briefly")
+}
+
+func TestRemoteAccount_CircuitBreaker(t *testing.T) {
+	redistest.Start(t)
+	calls, healthy := flakyAuthAPI(t)
+	t.Setenv("AUTH_BREAKER_THRESHOLD", "2")
+	t.Setenv("AUTH_BREAKER_COOLDOWN", "1s")
+	healthy.Store(false)
+
+	for range 2 {
+		_, err := RemoteAccount("abcdefgh.secret")
+		assert.ErrorIs(t, err, ErrAuthServiceUnavailable, "Failures should be reported as unavailable")
+	}
+	_, err := RemoteAccount("abcdefgh.secret")
+	assert.ErrorIs(t, err, ErrAuthServiceUnavailable, "Open breaker should reject calls")
+	assert.Equal(t, int32(2), calls.Load(), "Open breaker should not reach the auth service")
+
+	app := fiber.New()
+	app.Get("/", RemoteMiddleware(), func(c *fiber.Ctx) error {
+		return c.SendStatus(fiber.StatusOK)
+	})
+	req := httptest.NewRequest("GET", "/", nil)
+	req.Header.Set("Authorization", "Bearer abcdefgh.secret")
+	resp, err := app.Test(req)
+	require.NoError(t, err, "Request should not fail")
+	assert.Equal(t, fiber.StatusServiceUnavailable, resp.StatusCode, "Middleware should answer 503 while the auth service is down")
+
+	healthy.Store(true)
+	time.Sleep(1100 * time.Millisecond)
+	act, err := RemoteAccount("abcdefgh.secret")
+	require.NoError(t, err, "Probe should reach the recovered auth service")
+	assert.Equal(t, uint64(42), act.ID, "Account should come from the auth service")
+	assert.Equal(t, int32(3), calls.Load(), "Probe should reach the auth service")
+}
+
+func TestRemoteAccount_FailOpen(t *testing.T) {
+	redistest.Start(t)
+	_, healthy := flakyAuthAPI(t)
+	t.Setenv("AUTH_FAILURE_POLICY", "open")
+
+	_, err := RemoteAccount("abcdefgh.secret")
+	require.NoError(t, err, "RemoteAccount should not return an error")
+	require.NoError(t, cache.Del(remoteAccountCachePrefix+remoteTokenHash("abcdefgh.secret")), "Failed to expire cached account")
+
+	healthy.Store(false)
+	act, err := RemoteAccount("abcdefgh.secret")
+	require.NoError(t, err, "Validated token should be accepted while the auth service is down")
+	assert.Equal(t, uint64(42), act.ID, "Last validated account should be served")
+
+	_, err = RemoteAccount("abcdefgh.unknown")
+	assert.ErrorIs(t, err, ErrAuthServiceUnavailable, "Tokens never validated should be rejected")
+
+	require.NoError(t, InvalidateRemoteAccount("abcdefgh.secret"), "InvalidateRemoteAccount should not return an error")
+	_, err = RemoteAccount("abcdefgh.secret")
+	assert.ErrorIs(t, err, ErrAuthServiceUnavailable, "Invalidated token should not be served stale")
+}
+
+func TestRemoteTokenHash(t *testing.T) {
+	hash := func(secret string) string {
+		t.Setenv("AUTH_CACHE_SECRET", secret)
+		t.Setenv("APP_NAME", "app")
+		remoteAccountCacheSecretOnce = sync.Once{}
+		t.Cleanup(func() { remoteAccountCacheSecretOnce = sync.Once{} })
+		return remoteTokenHash("abcdefgh.secret")
+	}
+
+	unkeyed := hash("")
+	assert.Equal(t, unkeyed, hash(""), "Instances without secret should share their cache keys")
+	assert.NotContains(t, unkeyed, "secret", "Cache keys should not contain the token")
+	assert.NotEqual(t, unkeyed, hash("cache secret"), "Cache keys should be keyed by AUTH_CACHE_SECRET")
+	assert.Equal(t, hash("cache secret"), hash("cache secret"), "Instances sharing the secret should share their cache keys")
+}
@@ -0,0 +1,12 @@
+package password
+
+import "errors"
+
+// Errors returned by the password package, use errors.Is to tell them apart.
+var (
+	ErrMismatchedPassword = errors.New("password does not match")
+	ErrUnsupportedHash    = errors.New("unsupported password hash")
+	ErrInvalidHash        = errors.New("invalid password hash parameters")
+	ErrWeakPassword       = errors.New("password does not meet the policy")
+	ErrTooManyAttempts    = errors.New("too many failed attempts, try again later")
+)
@@ -0,0 +1,221 @@
+// Package password hashes and verifies passwords, checks them against a
+// policy and locks out identifiers after too many failed logins.
+package password
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strings"
+
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// Supported hashing algorithms.
+const (
+	Argon2id = "argon2id"
+	Bcrypt   = "bcrypt"
+)
+
+// Params configures how new passwords are hashed. Hashes made with other
+// algorithms or parameters are still verified and reported for rehash.
+type Params struct {
+	// Algorithm is Argon2id or Bcrypt, defaults to Argon2id.
+	Algorithm string
+	// Memory in KiB used by argon2id, defaults to 64 MiB.
+	Memory uint32
+	// Iterations of argon2id, defaults to 3.
+	Iterations uint32
+	// Parallelism of argon2id, defaults to 4.
+	Parallelism uint8
+	// SaltLength of argon2id in bytes, defaults to 16.
+	SaltLength uint32
+	// KeyLength of argon2id in bytes, defaults to 32.
+	KeyLength uint32
+	// Cost of bcrypt, defaults to 12.
+	Cost int
+}
+
+// maxArgon2Memory bounds the memory, in KiB, of the argon2id hashes Verify
+// accepts so a stored hash cannot exhaust the memory of the server.
+const maxArgon2Memory = 4 * 1024 * 1024
+
+// DefaultParams are the RFC 9106 recommended argon2id parameters for memory
+// constrained environments.
+var DefaultParams = Params{
+	Algorithm:   Argon2id,
+	Memory:      64 * 1024,
+	Iterations:  3,
+	Parallelism: 4,
+	SaltLength:  16,
+	KeyLength:   32,
+	Cost:        12,
+}
+
+func newParams(params ...Params) Params {
+	var p Params
+	if len(params) > 0 {
+		p = params[0]
+	}
+	if p.Algorithm == "" {
+		p.Algorithm = DefaultParams.Algorithm
+	}
+	if p.Memory == 0 {
+		p.Memory = DefaultParams.Memory
+	}
+	if p.Iterations == 0 {
+		p.Iterations = DefaultParams.Iterations
+	}
+	if p.Parallelism == 0 {
+		p.Parallelism = DefaultParams.Parallelism
+	}
+	if p.SaltLength == 0 {
+		p.SaltLength = DefaultParams.SaltLength
+	}
+	if p.KeyLength == 0 {
+		p.KeyLength = DefaultParams.KeyLength
+	}
+	if p.Cost == 0 {
+		p.Cost = DefaultParams.Cost
+	}
+	return p
+}
+
+// Hash returns the PHC string of password, e.g.
+// $argon2id$v=19$m=65536,t=3,p=4$<salt>$<hash> or the $2a$ string of bcrypt.
+func Hash(password string, params ...Params) (string, error) {
+	p := newParams(params...)
+
+	switch p.Algorithm {
+	case Argon2id:
+		salt := make([]byte, p.SaltLength)
+		if _, err := rand.Read(salt); err != nil {
+			return "", fmt.Errorf("failed to generate salt: %w", err)
+		}
+		key := argon2.IDKey([]byte(password), salt, p.Iterations, p.Memory, p.Parallelism, p.KeyLength)
+		return fmt.Sprintf("$%s$v=%d$m=%d,t=%d,p=%d$%s$%s",
+			Argon2id, argon2.Version, p.Memory, p.Iterations, p.Parallelism,
+			base64.RawStdEncoding.EncodeToString(salt),
+			base64.RawStdEncoding.EncodeToString(key),
+		), nil
+	case Bcrypt:
+		hash, err := bcrypt.GenerateFromPassword([]byte(password), p.Cost)
+		if err != nil {
+			return "", fmt.Errorf("failed to hash password: %w", err)
+		}
+		return string(hash), nil
+	}
+
+	return "", fmt.Errorf("%w: %q", ErrUnsupportedHash, p.Algorithm)
+}
+
+// Verify checks password against the hash encoded by Hash, also accepting
+// the bcrypt hashes of utils.HashPassword. rehash reports that the hash was
+// made with other algorithm or parameters than params, the password should
+// then be hashed again and stored.
+func Verify(password string, encoded string, params ...Params) (rehash bool, err error) {
+	p := newParams(params...)
+
+	hashed, err := decode(encoded)
+	if err != nil {
+		return false, err
+	}
+
+	switch hashed.Algorithm {
+	case Argon2id:
+		key := argon2.IDKey([]byte(password), hashed.salt, hashed.Iterations, hashed.Memory, hashed.Parallelism, uint32(len(hashed.key)))
+		if subtle.ConstantTimeCompare(key, hashed.key) != 1 {
+			return false, ErrMismatchedPassword
+		}
+	case Bcrypt:
+		if err := bcrypt.CompareHashAndPassword([]byte(encoded), []byte(password)); err != nil {
+			if errors.Is(err, bcrypt.ErrMismatchedHashAndPassword) {
+				return false, ErrMismatchedPassword
+			}
+			return false, fmt.Errorf("%w: %w", ErrUnsupportedHash, err)
+		}
+	}
+
+	return hashed.outdated(p), nil
+}
+
+// NeedsRehash reports whether encoded was made with other algorithm or
+// parameters than params.
+func NeedsRehash(encoded string, params ...Params) bool {
+	hashed, err := decode(encoded)
+	if err != nil {
+		return true
+	}
+	return hashed.outdated(newParams(params...))
+}
+
+// hashedPassword is a decoded hash with the parameters it was made with.
+type hashedPassword struct {
+	Params
+	salt []byte
+	key  []byte
+}
+
+func (h hashedPassword) outdated(p Params) bool {
+	if h.Algorithm != p.Algorithm {
+		return true
+	}
+	if h.Algorithm == Bcrypt {
+		return h.Cost != p.Cost
+	}
+	return h.Memory != p.Memory || h.Iterations != p.Iterations || h.Parallelism != p.Parallelism ||
+		h.SaltLength != p.SaltLength || h.KeyLength != p.KeyLength
+}
+
+func decode(encoded string) (hashedPassword, error) {
+	parts := strings.Split(encoded, "$")
+	if len(parts) < 2 || parts[0] != "" {
+		return hashedPassword{}, fmt.Errorf("%w: not a PHC string", ErrUnsupportedHash)
+	}
+
+	switch parts[1] {
+	case Argon2id:
+		if len(parts) != 6 {
+			return hashedPassword{}, fmt.Errorf("%w: malformed argon2id hash", ErrUnsupportedHash)
+		}
+
+		var version int
+		if _, err := fmt.Sscanf(parts[2], "v=%d", &version); err != nil || version != argon2.Version {
+			return hashedPassword{}, fmt.Errorf("%w: unsupported argon2id version %q", ErrUnsupportedHash, parts[2])
+		}
+
+		h := hashedPassword{Params: Params{Algorithm: Argon2id}}
+		if _, err := fmt.Sscanf(parts[3], "m=%d,t=%d,p=%d", &h.Memory, &h.Iterations, &h.Parallelism); err != nil {
+			return hashedPassword{}, fmt.Errorf("%w: malformed argon2id parameters: %w", ErrUnsupportedHash, err)
+		}
+		// argon2.IDKey panics without iterations or threads.
+		if h.Iterations < 1 || h.Parallelism < 1 {
+			return hashedPassword{}, fmt.Errorf("%w: argon2id t and p must be at least 1", ErrInvalidHash)
+		}
+		if h.Memory < 8*uint32(h.Parallelism) || h.Memory > maxArgon2Memory {
+			return hashedPassword{}, fmt.Errorf("%w: argon2id m must be between %d and %d", ErrInvalidHash, 8*uint32(h.Parallelism), maxArgon2Memory)
+		}
+
+		var err error
+		if h.salt, err = base64.RawStdEncoding.DecodeString(parts[4]); err != nil {
+			return hashedPassword{}, fmt.Errorf("%w: malformed argon2id salt: %w", ErrUnsupportedHash, err)
+		}
+		if h.key, err = base64.RawStdEncoding.DecodeString(parts[5]); err != nil || len(h.key) == 0 {
+			return hashedPassword{}, fmt.Errorf("%w: malformed argon2id hash", ErrUnsupportedHash)
+		}
+		h.SaltLength = uint32(len(h.salt))
+		h.KeyLength = uint32(len(h.key))
+		return h, nil
+	case "2a", "2b", "2y":
+		cost, err := bcrypt.Cost([]byte(encoded))
+		if err != nil {
+			return hashedPassword{}, fmt.Errorf("%w: %w", ErrUnsupportedHash, err)
+		}
+		return hashedPassword{Params: Params{Algorithm: Bcrypt, Cost: cost}}, nil
+	}
+
+	return hashedPassword{}, fmt.Errorf("%w: %q", ErrUnsupportedHash, parts[1])
+}
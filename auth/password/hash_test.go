@@ -0,0 +1,102 @@
+package password
+
+import (
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/arqut/common/system"
+	"github.com/arqut/common/utils"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// testParams keeps argon2id cheap in tests.
+var testParams = Params{Memory: 1024, Iterations: 1, Parallelism: 1}
+
+func TestMain(m *testing.M) {
+	os.Setenv("SYS_LOG_LEVEL", "INFO")
+	system.InitLogger("test_logger")
+
+	os.Exit(m.Run())
+}
+
+func TestHash_Argon2id(t *testing.T) {
+	encoded, err := Hash("correct horse", testParams)
+	require.NoError(t, err, "Hash should not return an error")
+	assert.True(t, strings.HasPrefix(encoded, "$argon2id$v=19$m=1024,t=1,p=1$"), "Hash should be a PHC string")
+
+	other, err := Hash("correct horse", testParams)
+	require.NoError(t, err, "Hash should not return an error")
+	assert.NotEqual(t, encoded, other, "Hashes should be salted")
+
+	rehash, err := Verify("correct horse", encoded, testParams)
+	assert.NoError(t, err, "Verify should accept the password")
+	assert.False(t, rehash, "Hash with current parameters should not be rehashed")
+
+	_, err = Verify("wrong horse", encoded, testParams)
+	assert.ErrorIs(t, err, ErrMismatchedPassword, "Verify should reject other passwords")
+}
+
+func TestHash_Bcrypt(t *testing.T) {
+	params := Params{Algorithm: Bcrypt, Cost: 4}
+	encoded, err := Hash("correct horse", params)
+	require.NoError(t, err, "Hash should not return an error")
+	assert.True(t, strings.HasPrefix(encoded, "$2a$04$"), "Hash should be a bcrypt string")
+
+	rehash, err := Verify("correct horse", encoded, params)
+	assert.NoError(t, err, "Verify should accept the password")
+	assert.False(t, rehash, "Hash with current parameters should not be rehashed")
+
+	_, err = Verify("wrong horse", encoded, params)
+	assert.ErrorIs(t, err, ErrMismatchedPassword, "Verify should reject other passwords")
+}
+
+func TestVerify_Rehash(t *testing.T) {
+	legacy := utils.HashPassword("correct horse")
+	rehash, err := Verify("correct horse", legacy, testParams)
+	assert.NoError(t, err, "Verify should accept utils.HashPassword hashes")
+	assert.True(t, rehash, "bcrypt hashes should be upgraded to argon2id")
+	assert.True(t, NeedsRehash(legacy, Params{Algorithm: Bcrypt, Cost: 12}), "Lower bcrypt cost should be upgraded")
+
+	encoded, err := Hash("correct horse", testParams)
+	require.NoError(t, err, "Hash should not return an error")
+	stronger := testParams
+	stronger.Iterations = 2
+	rehash, err = Verify("correct horse", encoded, stronger)
+	assert.NoError(t, err, "Verify should accept hashes with older parameters")
+	assert.True(t, rehash, "Changed parameters should trigger a rehash")
+	assert.False(t, NeedsRehash(encoded, testParams), "Current parameters should not trigger a rehash")
+}
+
+func TestVerify_Malformed(t *testing.T) {
+	for _, encoded := range []string{
+		"",
+		"plain text",
+		"$md5$abc",
+		"$argon2id$v=19$m=1024,t=1,p=1$c2FsdA",
+		"$argon2id$v=18$m=1024,t=1,p=1$c2FsdA$aGFzaA",
+		"$argon2id$v=19$m=x,t=1,p=1$c2FsdA$aGFzaA",
+		"$argon2id$v=19$m=1024,t=1,p=1$!!$aGFzaA",
+		"$2a$04$short",
+	} {
+		_, err := Verify("password", encoded, testParams)
+		assert.ErrorIs(t, err, ErrUnsupportedHash, "Verify should reject %q", encoded)
+	}
+}
+
+func TestVerify_InvalidParameters(t *testing.T) {
+	for _, encoded := range []string{
+		"$argon2id$v=19$m=1024,t=0,p=1$c2FsdA$aGFzaA",
+		"$argon2id$v=19$m=1024,t=1,p=0$c2FsdA$aGFzaA",
+		"$argon2id$v=19$m=7,t=1,p=1$c2FsdA$aGFzaA",
+		"$argon2id$v=19$m=16,t=1,p=4$c2FsdA$aGFzaA",
+		"$argon2id$v=19$m=4294967295,t=1,p=1$c2FsdA$aGFzaA",
+	} {
+		assert.NotPanics(t, func() {
+			_, err := Verify("password", encoded, testParams)
+			assert.ErrorIs(t, err, ErrInvalidHash, "Verify should reject %q", encoded)
+		})
+		assert.True(t, NeedsRehash(encoded, testParams), "Invalid hashes should need a rehash")
+	}
+}
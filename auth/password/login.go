@@ -0,0 +1,148 @@
+package password
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/arqut/common/cache"
+	"github.com/arqut/common/system"
+)
+
+// Lockout counts the failed logins of identifiers, like emails or client IPs,
+// in the cache package and locks them out once MaxAttempts is reached. Its
+// users fail closed: attempts are rejected when the counters cannot be read
+// or recorded, so an unreachable cache never lifts a lockout.
+type Lockout struct {
+	// MaxAttempts is the number of failures locking an identifier out,
+	// defaults to 5.
+	MaxAttempts int
+	// Duration is how long failures are counted and the lock lasts, counted
+	// from the first failure, defaults to 15 minutes.
+	Duration time.Duration
+	// Prefix namespaces the counters, defaults to "auth:login:".
+	Prefix string
+}
+
+func (l Lockout) withDefaults() Lockout {
+	if l.MaxAttempts <= 0 {
+		l.MaxAttempts = 5
+	}
+	if l.Duration <= 0 {
+		l.Duration = 15 * time.Minute
+	}
+	if l.Prefix == "" {
+		l.Prefix = "auth:login:"
+	}
+	return l
+}
+
+// key hashes identifier so emails never appear in the cache keys.
+func (l Lockout) key(identifier string) string {
+	sum := sha256.Sum256([]byte(strings.ToLower(identifier)))
+	return l.Prefix + hex.EncodeToString(sum[:])
+}
+
+// Locked reports whether identifier has reached MaxAttempts.
+func (l Lockout) Locked(identifier string) (bool, error) {
+	l = l.withDefaults()
+
+	value, err := cache.Get(l.key(identifier))
+	if cache.IsNotFound(err) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	attempts, _ := strconv.Atoi(value)
+	return attempts >= l.MaxAttempts, nil
+}
+
+// Fail records a failed login of identifier and returns the number of
+// failures in the current window.
+func (l Lockout) Fail(identifier string) (int64, error) {
+	l = l.withDefaults()
+	return cache.Incr(l.key(identifier), l.Duration)
+}
+
+// Reset clears the failures of identifier, usually after a successful login.
+func (l Lockout) Reset(identifier string) error {
+	l = l.withDefaults()
+	return cache.Del(l.key(identifier))
+}
+
+// Authenticator bundles the hashing parameters, policy and lockout of a
+// password login flow.
+type Authenticator struct {
+	Params  Params
+	Policy  Policy
+	Lockout Lockout
+
+	dummyOnce sync.Once
+	dummyHash string
+}
+
+// HashNew validates a new password against the policy and hashes it.
+// userInputs are values of the account the password may not contain.
+func (a *Authenticator) HashNew(password string, userInputs ...string) (string, error) {
+	if err := a.Policy.forAlgorithm(newParams(a.Params).Algorithm).Validate(password, userInputs...); err != nil {
+		return "", err
+	}
+	return Hash(password, a.Params)
+}
+
+// Login verifies password against encoded, the stored hash of identifier,
+// pass an empty hash for unknown identifiers so they take as long to reject.
+// It returns ErrTooManyAttempts while identifier is locked out and
+// ErrMismatchedPassword on wrong passwords. On success rehashed is the new
+// hash to store when the stored one uses outdated parameters, empty otherwise.
+// Logins are rejected when the lockout counters fail, see Lockout, failing to
+// reset them after a successful login is only logged.
+func (a *Authenticator) Login(identifier string, password string, encoded string) (rehashed string, err error) {
+	locked, err := a.Lockout.Locked(identifier)
+	if err != nil {
+		return "", fmt.Errorf("failed to read login failures: %w", err)
+	}
+	if locked {
+		return "", ErrTooManyAttempts
+	}
+
+	var rehash bool
+	if encoded == "" {
+		a.dummyOnce.Do(func() {
+			a.dummyHash, _ = Hash("dummy password", a.Params)
+		})
+		Verify(password, a.dummyHash, a.Params)
+		err = ErrMismatchedPassword
+	} else {
+		rehash, err = Verify(password, encoded, a.Params)
+	}
+
+	if errors.Is(err, ErrMismatchedPassword) {
+		if _, cacheErr := a.Lockout.Fail(identifier); cacheErr != nil {
+			return "", fmt.Errorf("failed to record login failure: %w", cacheErr)
+		}
+		return "", err
+	}
+	if err != nil {
+		return "", err
+	}
+
+	if cacheErr := a.Lockout.Reset(identifier); cacheErr != nil {
+		system.Logger.Errorf("Error resetting login failures: %v", cacheErr)
+	}
+
+	if rehash {
+		if rehashed, err = Hash(password, a.Params); err != nil {
+			system.Logger.Errorf("Error rehashing password: %v", err)
+			return "", nil
+		}
+	}
+
+	return rehashed, nil
+}
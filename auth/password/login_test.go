@@ -0,0 +1,91 @@
+package password
+
+import (
+	"testing"
+	"time"
+
+	"github.com/arqut/common/cache"
+	"github.com/arqut/common/internal/redistest"
+	"github.com/redis/go-redis/v9"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAuthenticator_Login(t *testing.T) {
+	redistest.Start(t)
+	a := &Authenticator{Params: testParams}
+
+	encoded, err := a.HashNew("correct horse", "alice@example.com")
+	require.NoError(t, err, "HashNew should not return an error")
+	_, err = a.HashNew("short")
+	assert.ErrorIs(t, err, ErrWeakPassword, "HashNew should enforce the policy")
+
+	rehashed, err := a.Login("alice@example.com", "correct horse", encoded)
+	assert.NoError(t, err, "Login should accept the password")
+	assert.Empty(t, rehashed, "Current hash should not be replaced")
+
+	_, err = a.Login("alice@example.com", "wrong horse", encoded)
+	assert.ErrorIs(t, err, ErrMismatchedPassword, "Login should reject other passwords")
+	_, err = a.Login("bob@example.com", "correct horse", "")
+	assert.ErrorIs(t, err, ErrMismatchedPassword, "Login should reject unknown identifiers")
+}
+
+func TestAuthenticator_Rehash(t *testing.T) {
+	redistest.Start(t)
+	a := &Authenticator{Params: testParams}
+
+	legacy, err := Hash("correct horse", Params{Algorithm: Bcrypt, Cost: 4})
+	require.NoError(t, err, "Hash should not return an error")
+
+	rehashed, err := a.Login("alice@example.com", "correct horse", legacy)
+	require.NoError(t, err, "Login should accept the legacy hash")
+	require.NotEmpty(t, rehashed, "Legacy hash should be replaced")
+	assert.False(t, NeedsRehash(rehashed, testParams), "New hash should use the current parameters")
+
+	rehashed, err = a.Login("alice@example.com", "correct horse", rehashed)
+	assert.NoError(t, err, "Login should accept the new hash")
+	assert.Empty(t, rehashed, "New hash should not be replaced")
+}
+
+func TestAuthenticator_Lockout(t *testing.T) {
+	redisServer := redistest.Start(t)
+	a := &Authenticator{Params: testParams, Lockout: Lockout{MaxAttempts: 3, Duration: time.Minute}}
+
+	encoded, err := Hash("correct horse", testParams)
+	require.NoError(t, err, "Hash should not return an error")
+
+	for range 3 {
+		_, err = a.Login("alice@example.com", "wrong horse", encoded)
+		assert.ErrorIs(t, err, ErrMismatchedPassword, "Login should reject other passwords")
+	}
+	_, err = a.Login("Alice@example.com", "correct horse", encoded)
+	assert.ErrorIs(t, err, ErrTooManyAttempts, "Locked identifiers should be rejected even with the right password")
+
+	keys := redisServer.Keys()
+	require.Len(t, keys, 1, "One counter should be stored")
+	assert.NotContains(t, keys[0], "alice", "Counter key should not contain the identifier")
+	assert.InDelta(t, time.Minute, redisServer.TTL(keys[0]), float64(time.Second), "Counter should expire with the lock")
+
+	_, err = a.Login("bob@example.com", "correct horse", encoded)
+	assert.NoError(t, err, "Other identifiers should not be locked")
+
+	require.NoError(t, a.Lockout.Reset("alice@example.com"), "Reset should not return an error")
+	_, err = a.Login("alice@example.com", "correct horse", encoded)
+	assert.NoError(t, err, "Reset identifiers should be accepted")
+}
+
+func TestAuthenticator_LockoutCacheFailure(t *testing.T) {
+	redistest.Start(t)
+	a := &Authenticator{Params: testParams}
+
+	encoded, err := a.HashNew("correct horse")
+	require.NoError(t, err, "HashNew should not return an error")
+
+	client := redis.NewClient(&redis.Options{Addr: "127.0.0.1:1", MaxRetries: -1, DialTimeout: 100 * time.Millisecond})
+	t.Cleanup(func() { client.Close() })
+	cache.InitRedisCache(client)
+
+	_, err = a.Login("alice@example.com", "correct horse", encoded)
+	assert.Error(t, err, "Login should fail closed when the lockout counters cannot be read")
+	assert.NotErrorIs(t, err, ErrMismatchedPassword, "Cache failures should not be reported as wrong passwords")
+}
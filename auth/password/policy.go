@@ -0,0 +1,104 @@
+package password
+
+import (
+	"fmt"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// Policy lists the requirements of new passwords.
+type Policy struct {
+	// MinLength in characters, defaults to 8.
+	MinLength int
+	// MaxLength in bytes, defaults to 128. Authenticator caps it at
+	// BcryptMaxLength when hashing with bcrypt, which rejects longer passwords.
+	MaxLength int
+	// RequireUpper, RequireLower, RequireDigit and RequireSymbol each require
+	// at least one character of their class.
+	RequireUpper  bool
+	RequireLower  bool
+	RequireDigit  bool
+	RequireSymbol bool
+	// Forbidden lists common passwords rejected regardless of case.
+	Forbidden []string
+}
+
+// BcryptMaxLength is the longest password in bytes bcrypt can hash.
+const BcryptMaxLength = 72
+
+// DefaultPolicy only bounds the length, as recommended by NIST SP 800-63B.
+var DefaultPolicy = Policy{MinLength: 8, MaxLength: 128}
+
+// forAlgorithm caps MaxLength to what algorithm can hash.
+func (p Policy) forAlgorithm(algorithm string) Policy {
+	if algorithm == Bcrypt && (p.MaxLength <= 0 || p.MaxLength > BcryptMaxLength) {
+		p.MaxLength = BcryptMaxLength
+	}
+	return p
+}
+
+// Validate checks password against the policy. userInputs are values of the
+// account, like its email or name, the password may not contain.
+func (p Policy) Validate(password string, userInputs ...string) error {
+	if p.MinLength <= 0 {
+		p.MinLength = DefaultPolicy.MinLength
+	}
+	if p.MaxLength <= 0 {
+		p.MaxLength = DefaultPolicy.MaxLength
+	}
+
+	var violations []string
+
+	if utf8.RuneCountInString(password) < p.MinLength {
+		violations = append(violations, fmt.Sprintf("at least %d characters", p.MinLength))
+	}
+	if len(password) > p.MaxLength {
+		violations = append(violations, fmt.Sprintf("at most %d bytes", p.MaxLength))
+	}
+
+	var upper, lower, digit, symbol bool
+	for _, r := range password {
+		switch {
+		case unicode.IsUpper(r):
+			upper = true
+		case unicode.IsLower(r):
+			lower = true
+		case unicode.IsDigit(r):
+			digit = true
+		case unicode.IsPunct(r) || unicode.IsSymbol(r) || unicode.IsSpace(r):
+			symbol = true
+		}
+	}
+	if p.RequireUpper && !upper {
+		violations = append(violations, "an upper case letter")
+	}
+	if p.RequireLower && !lower {
+		violations = append(violations, "a lower case letter")
+	}
+	if p.RequireDigit && !digit {
+		violations = append(violations, "a digit")
+	}
+	if p.RequireSymbol && !symbol {
+		violations = append(violations, "a symbol")
+	}
+
+	lowered := strings.ToLower(password)
+	for _, forbidden := range p.Forbidden {
+		if lowered == strings.ToLower(forbidden) {
+			violations = append(violations, "not a common password")
+			break
+		}
+	}
+	for _, input := range userInputs {
+		if len(input) >= 3 && strings.Contains(lowered, strings.ToLower(input)) {
+			violations = append(violations, "not containing account details")
+			break
+		}
+	}
+
+	if len(violations) > 0 {
+		return fmt.Errorf("%w: requires %s", ErrWeakPassword, strings.Join(violations, ", "))
+	}
+	return nil
+}
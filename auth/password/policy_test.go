@@ -0,0 +1,44 @@
+package password
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPolicy_Validate(t *testing.T) {
+	assert.NoError(t, DefaultPolicy.Validate("correct horse battery"), "Long passwords should be accepted")
+	assert.ErrorIs(t, DefaultPolicy.Validate("short"), ErrWeakPassword, "Short passwords should be rejected")
+	assert.ErrorIs(t, DefaultPolicy.Validate(strings.Repeat("a", 129)), ErrWeakPassword, "Long passwords should be rejected")
+	assert.NoError(t, DefaultPolicy.Validate("pässwörd"), "Minimum length should be counted in characters")
+	assert.ErrorIs(t, DefaultPolicy.Validate(strings.Repeat("é", 65)), ErrWeakPassword, "Maximum length should be counted in bytes")
+
+	strict := Policy{RequireUpper: true, RequireLower: true, RequireDigit: true, RequireSymbol: true}
+	assert.NoError(t, strict.Validate("Correct-Horse-1"), "Password with every class should be accepted")
+	err := strict.Validate("correcthorse")
+	assert.ErrorIs(t, err, ErrWeakPassword, "Missing classes should be rejected")
+	assert.ErrorContains(t, err, "an upper case letter, a digit, a symbol", "Every violation should be reported")
+
+	common := Policy{Forbidden: []string{"password1"}}
+	assert.ErrorIs(t, common.Validate("PASSWORD1"), ErrWeakPassword, "Forbidden passwords should be rejected regardless of case")
+
+	assert.ErrorIs(t, DefaultPolicy.Validate("alice-secret", "Alice@example.com", "alice"), ErrWeakPassword, "Account details should be rejected")
+	assert.NoError(t, DefaultPolicy.Validate("correct horse", "", "al"), "Short account details should be ignored")
+}
+
+func TestAuthenticator_HashNew_BcryptLength(t *testing.T) {
+	bcryptAuth := &Authenticator{Params: Params{Algorithm: Bcrypt, Cost: 4}}
+	_, err := bcryptAuth.HashNew(strings.Repeat("a", BcryptMaxLength))
+	assert.NoError(t, err, "Passwords up to the bcrypt limit should be hashed")
+	_, err = bcryptAuth.HashNew(strings.Repeat("é", BcryptMaxLength/2+1))
+	assert.ErrorIs(t, err, ErrWeakPassword, "Passwords past the bcrypt limit should be rejected by the policy")
+
+	bcryptAuth.Policy = Policy{MaxLength: 256}
+	_, err = bcryptAuth.HashNew(strings.Repeat("a", BcryptMaxLength+1))
+	assert.ErrorIs(t, err, ErrWeakPassword, "Larger maximum lengths should be capped for bcrypt")
+
+	argonAuth := &Authenticator{Params: testParams}
+	_, err = argonAuth.HashNew(strings.Repeat("a", 100))
+	assert.NoError(t, err, "argon2id should not be capped")
+}
@@ -1,17 +1,81 @@
 package auth
 
 import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
 	"encoding/json"
+	"fmt"
+	"net"
+	"sort"
 	"strconv"
 	"strings"
+	"time"
 
 	"github.com/arqut/common/api"
 	"github.com/arqut/common/strcase"
+	"github.com/arqut/common/system"
+	"github.com/arqut/common/utils"
 	"github.com/gofiber/fiber/v2"
 )
 
-func ProxyAuthMiddleware() fiber.Handler {
+// Headers set by the gateway next to the X-User-* identity headers.
+const (
+	ProxyTimestampHeader = "X-Proxy-Timestamp"
+	ProxySignatureHeader = "X-Proxy-Signature"
+)
+
+// proxyAuthorizationKeys are the X-User-* headers granting privileges, they
+// are only read from signed requests.
+var proxyAuthorizationKeys = map[string]bool{"isAdmin": true, "roles": true, "permissions": true, "scopes": true, "mfaAt": true}
+
+// ProxyAuthOptions configures how ProxyAuthMiddleware trusts the identity
+// headers set by the gateway.
+type ProxyAuthOptions struct {
+	// Secret shared with the gateway to sign the X-User-* headers, defaults to
+	// PROXY_AUTH_SECRET. Headers are not verified without secret, the admin,
+	// roles, permissions, scopes and MFA time headers are then ignored.
+	Secret []byte
+	// MaxSkew is the maximum age of a signature, defaults to
+	// PROXY_AUTH_MAX_SKEW or 30 seconds.
+	MaxSkew time.Duration
+	// TrustedProxies restricts the accepted peers to these CIDRs, defaults to
+	// the comma separated PROXY_TRUSTED_CIDRS. Any peer is accepted when empty.
+	TrustedProxies []string
+	// AllowUnsigned accepts unsigned headers from any peer, when neither
+	// Secret nor TrustedProxies is set. Defaults to PROXY_AUTH_ALLOW_UNSIGNED,
+	// only meant for services no client can reach directly.
+	AllowUnsigned bool
+}
+
+// ProxyAuthMiddleware authenticates requests from the identity headers set by
+// the gateway. It panics without Secret nor TrustedProxies unless
+// AllowUnsigned is set, as any client could then impersonate any user.
+func ProxyAuthMiddleware(options ...ProxyAuthOptions) fiber.Handler {
+	opts := newProxyAuthOptions(options...)
+	if len(opts.Secret) == 0 && len(opts.TrustedProxies) == 0 && !opts.AllowUnsigned {
+		panic(fmt.Errorf("ProxyAuthMiddleware requires a Secret or TrustedProxies, set AllowUnsigned to trust any peer"))
+	}
+
+	trustedNets := make([]*net.IPNet, 0, len(opts.TrustedProxies))
+	for _, cidr := range opts.TrustedProxies {
+		_, ipNet, err := net.ParseCIDR(strings.TrimSpace(cidr))
+		if err != nil {
+			panic(fmt.Errorf("invalid trusted proxy CIDR %q: %w", cidr, err))
+		}
+		trustedNets = append(trustedNets, ipNet)
+	}
+
+	signed := len(opts.Secret) > 0
+	if !signed {
+		system.Logger.Warnf("ProxyAuthMiddleware has no secret, X-User-* headers are trusted without verification and X-User-Is-Admin, X-User-Roles, X-User-Permissions, X-User-Scopes and X-User-Mfa-At are ignored.")
+	}
+
 	return func(c *fiber.Ctx) error {
+		if len(trustedNets) > 0 && !ipInNets(c.Context().RemoteIP(), trustedNets) {
+			return api.ErrorUnauthorizedResp(c, "Unauthorized: Untrusted proxy")
+		}
+
 		// Check if the required header "x-user-id" exists.
 		userID := c.Get("x-user-id")
 		if userID == "" {
@@ -19,34 +83,134 @@ func ProxyAuthMiddleware() fiber.Handler {
 		}
 
 		// Collect all headers that have the prefix "x-user-".
+		headers := make(map[string]string)
 		userData := make(map[string]interface{})
 		c.Request().Header.VisitAll(func(key, value []byte) {
 			headerKey := string(key)
 			if strings.HasPrefix(headerKey, "X-User-") {
+				headers[headerKey] = string(value)
 				key := strcase.LowerCamelCase(strings.TrimPrefix(headerKey, "X-User-"))
+				if !signed && proxyAuthorizationKeys[key] {
+					return
+				}
 				if key == "id" {
 					id, _ := strconv.Atoi(string(value))
 					userData[key] = uint64(id)
+				} else if key == "mfaAt" {
+					mfaAt, _ := strconv.ParseInt(string(value), 10, 64)
+					userData[key] = mfaAt
 				} else if key == "isAdmin" {
 					userData[key] = string(value) == "true"
+				} else if key == "roles" || key == "permissions" || key == "scopes" {
+					userData[key] = strings.Split(string(value), ",")
 				} else {
 					userData[key] = string(value)
 				}
 			}
 		})
 
+		if signed {
+			if err := verifyProxyHeaders(opts, headers, c.Get(ProxyTimestampHeader), c.Get(ProxySignatureHeader)); err != nil {
+				return api.ErrorUnauthorizedResp(c, "Unauthorized: "+err.Error())
+			}
+		}
+
 		// convert to AuthTokenData
 		authData := &AuthTokenData{}
 		jsonStr, _ := json.Marshal(userData)
 		_ = json.Unmarshal(jsonStr, authData)
 
-		c.Locals("account", authData)
+		SetAccount(c, authData, "")
 
 		// Proceed to the next middleware or final handler.
 		return c.Next()
 	}
 }
 
+// SignProxyHeaders returns the ProxyTimestampHeader and ProxySignatureHeader
+// the gateway adds to a request forwarding the X-User-* headers, every
+// X-User-* header of the request must be in headers.
+func SignProxyHeaders(secret []byte, headers map[string]string) map[string]string {
+	timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+	return map[string]string{
+		ProxyTimestampHeader: timestamp,
+		ProxySignatureHeader: proxySignature(secret, timestamp, headers),
+	}
+}
+
+// verifyProxyHeaders checks the signature of the X-User-* headers and its age.
+func verifyProxyHeaders(opts ProxyAuthOptions, headers map[string]string, timestamp string, signature string) error {
+	if timestamp == "" || signature == "" {
+		return fmt.Errorf("missing proxy signature")
+	}
+
+	signedAt, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil {
+		return fmt.Errorf("invalid proxy timestamp")
+	}
+	if age := time.Since(time.Unix(signedAt, 0)); age > opts.MaxSkew || age < -opts.MaxSkew {
+		return fmt.Errorf("expired proxy signature")
+	}
+
+	expected := proxySignature(opts.Secret, timestamp, headers)
+	if !hmac.Equal([]byte(expected), []byte(strings.ToLower(signature))) {
+		return fmt.Errorf("invalid proxy signature")
+	}
+
+	return nil
+}
+
+// proxySignature is the hex HMAC-SHA256 of the timestamp followed by the
+// "name:value" lines of the X-User-* headers, with lower case names sorted.
+func proxySignature(secret []byte, timestamp string, headers map[string]string) string {
+	lines := make([]string, 0, len(headers))
+	for name, value := range headers {
+		name = strings.ToLower(name)
+		if strings.HasPrefix(name, "x-user-") {
+			lines = append(lines, name+":"+strings.TrimSpace(value))
+		}
+	}
+	sort.Strings(lines)
+
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte(timestamp))
+	for _, line := range lines {
+		mac.Write([]byte("\n" + line))
+	}
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+func newProxyAuthOptions(options ...ProxyAuthOptions) ProxyAuthOptions {
+	var opts ProxyAuthOptions
+	if len(options) > 0 {
+		opts = options[0]
+	}
+	if len(opts.Secret) == 0 {
+		opts.Secret = []byte(system.Env("PROXY_AUTH_SECRET"))
+	}
+	if opts.MaxSkew <= 0 {
+		opts.MaxSkew, _ = utils.ParseDuration(system.Env("PROXY_AUTH_MAX_SKEW", "30s"))
+	}
+	if len(opts.TrustedProxies) == 0 {
+		if cidrs := system.Env("PROXY_TRUSTED_CIDRS"); cidrs != "" {
+			opts.TrustedProxies = strings.Split(cidrs, ",")
+		}
+	}
+	if !opts.AllowUnsigned {
+		opts.AllowUnsigned = system.Env("PROXY_AUTH_ALLOW_UNSIGNED") == "true"
+	}
+	return opts
+}
+
+func ipInNets(ip net.IP, nets []*net.IPNet) bool {
+	for _, ipNet := range nets {
+		if ipNet.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
 func IsAdminMiddleware() fiber.Handler {
 	return func(c *fiber.Ctx) error {
 		if IsAdmin(c) {
@@ -57,8 +221,6 @@ func IsAdminMiddleware() fiber.Handler {
 }
 
 func IsAdmin(c *fiber.Ctx) bool {
-	if authData := c.Locals("account"); authData != nil {
-		return authData.(*AuthTokenData).IsAdmin
-	}
-	return false
+	act, ok := Account(c)
+	return ok && act.IsAdmin
 }
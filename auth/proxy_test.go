@@ -0,0 +1,149 @@
+package auth
+
+import (
+	"encoding/json"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var proxyTestSecret = []byte("proxy-secret")
+
+func proxyApp(options ...ProxyAuthOptions) *fiber.App {
+	app := fiber.New()
+	app.Get("/", ProxyAuthMiddleware(options...), func(c *fiber.Ctx) error {
+		return c.JSON(c.Locals("account"))
+	})
+	return app
+}
+
+func proxyStatus(t *testing.T, app *fiber.App, headers map[string]string) int {
+	req := httptest.NewRequest("GET", "/", nil)
+	for name, value := range headers {
+		req.Header.Set(name, value)
+	}
+	resp, err := app.Test(req)
+	require.NoError(t, err, "Request should not fail")
+	return resp.StatusCode
+}
+
+func signedProxyHeaders(headers map[string]string) map[string]string {
+	signed := make(map[string]string, len(headers)+2)
+	for name, value := range headers {
+		signed[name] = value
+	}
+	for name, value := range SignProxyHeaders(proxyTestSecret, headers) {
+		signed[name] = value
+	}
+	return signed
+}
+
+func TestProxyAuthMiddleware_Signature(t *testing.T) {
+	app := proxyApp(ProxyAuthOptions{Secret: proxyTestSecret})
+	identity := map[string]string{"X-User-Id": "1", "X-User-Roles": "support"}
+
+	assert.Equal(t, fiber.StatusOK, proxyStatus(t, app, signedProxyHeaders(identity)), "Signed headers should be accepted")
+	assert.Equal(t, fiber.StatusUnauthorized, proxyStatus(t, app, identity), "Unsigned headers should be rejected")
+
+	forged := signedProxyHeaders(identity)
+	forged["X-User-Is-Admin"] = "true"
+	assert.Equal(t, fiber.StatusUnauthorized, proxyStatus(t, app, forged), "Added headers should invalidate the signature")
+
+	forged = signedProxyHeaders(identity)
+	forged["X-User-Id"] = "2"
+	assert.Equal(t, fiber.StatusUnauthorized, proxyStatus(t, app, forged), "Modified headers should invalidate the signature")
+
+	req := httptest.NewRequest("GET", "/", nil)
+	for name, value := range signedProxyHeaders(map[string]string{"X-User-Id": "1", "X-User-Is-Admin": "true"}) {
+		req.Header.Set(name, value)
+	}
+	resp, err := app.Test(req)
+	require.NoError(t, err, "Request should not fail")
+	var act AuthTokenData
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&act), "Response should be valid JSON")
+	assert.True(t, act.IsAdmin, "Signed admin flag should be read")
+
+	otherSecret := signedProxyHeaders(identity)
+	otherSecret[ProxySignatureHeader] = proxySignature([]byte("other-secret"), otherSecret[ProxyTimestampHeader], identity)
+	assert.Equal(t, fiber.StatusUnauthorized, proxyStatus(t, app, otherSecret), "Signature with another secret should be rejected")
+}
+
+func TestProxyAuthMiddleware_Freshness(t *testing.T) {
+	app := proxyApp(ProxyAuthOptions{Secret: proxyTestSecret, MaxSkew: time.Minute})
+	identity := map[string]string{"X-User-Id": "1"}
+
+	for _, offset := range []time.Duration{-2 * time.Minute, 2 * time.Minute} {
+		timestamp := strconv.FormatInt(time.Now().Add(offset).Unix(), 10)
+		headers := map[string]string{
+			"X-User-Id":          "1",
+			ProxyTimestampHeader: timestamp,
+			ProxySignatureHeader: proxySignature(proxyTestSecret, timestamp, identity),
+		}
+		assert.Equal(t, fiber.StatusUnauthorized, proxyStatus(t, app, headers), "Signature outside of the allowed skew should be rejected")
+	}
+}
+
+func TestProxyAuthMiddleware_TrustedProxies(t *testing.T) {
+	identity := map[string]string{"X-User-Id": "1"}
+
+	app := proxyApp(ProxyAuthOptions{TrustedProxies: []string{"10.0.0.0/8"}})
+	assert.Equal(t, fiber.StatusUnauthorized, proxyStatus(t, app, identity), "Untrusted peers should be rejected")
+
+	app = proxyApp(ProxyAuthOptions{TrustedProxies: []string{"10.0.0.0/8", "0.0.0.0/32"}})
+	assert.Equal(t, fiber.StatusOK, proxyStatus(t, app, identity), "Trusted peers should be accepted")
+
+	assert.Panics(t, func() {
+		ProxyAuthMiddleware(ProxyAuthOptions{TrustedProxies: []string{"10.0.0.0"}})
+	}, "Malformed CIDRs should be rejected at startup")
+}
+
+func TestProxyAuthMiddleware_RequiresVerification(t *testing.T) {
+	assert.Panics(t, func() { ProxyAuthMiddleware() }, "Unverified headers from any peer should be rejected at startup")
+	assert.NotPanics(t, func() { ProxyAuthMiddleware(ProxyAuthOptions{AllowUnsigned: true}) }, "Unverified headers should be accepted when opted in")
+
+	t.Setenv("PROXY_AUTH_ALLOW_UNSIGNED", "true")
+	assert.NotPanics(t, func() { ProxyAuthMiddleware() }, "Unverified headers should be accepted when opted in from the environment")
+}
+
+func TestProxyAuthMiddleware_EnvSecret(t *testing.T) {
+	t.Setenv("PROXY_AUTH_SECRET", string(proxyTestSecret))
+	app := proxyApp()
+	identity := map[string]string{"X-User-Id": "1"}
+
+	assert.Equal(t, fiber.StatusOK, proxyStatus(t, app, signedProxyHeaders(identity)), "Signed headers should be accepted")
+	assert.Equal(t, fiber.StatusUnauthorized, proxyStatus(t, app, identity), "Unsigned headers should be rejected")
+}
+
+func TestProxyAuthMiddleware_UnsignedAuthorizationHeaders(t *testing.T) {
+	app := fiber.New()
+	app.Get("/", ProxyAuthMiddleware(ProxyAuthOptions{AllowUnsigned: true}), func(c *fiber.Ctx) error {
+		return c.JSON(MustAccount(c))
+	})
+
+	req := httptest.NewRequest("GET", "/", nil)
+	req.Header.Set("X-User-Id", "1")
+	req.Header.Set("X-User-Is-Admin", "true")
+	req.Header.Set("X-User-Email", "user@example.com")
+	req.Header.Set("X-User-Roles", "admin")
+	req.Header.Set("X-User-Permissions", "orders:write")
+	req.Header.Set("X-User-Scopes", "admin")
+	req.Header.Set("X-User-Mfa-At", strconv.FormatInt(time.Now().Unix(), 10))
+	resp, err := app.Test(req)
+	require.NoError(t, err, "Request should not fail")
+	require.Equal(t, fiber.StatusOK, resp.StatusCode, "Unsigned identity headers should be accepted")
+
+	var act AuthTokenData
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&act), "Response should be valid JSON")
+	assert.Equal(t, uint64(1), act.ID, "Identity should be read from unsigned headers")
+	assert.Equal(t, "user@example.com", act.Email, "Identity should be read from unsigned headers")
+	assert.False(t, act.IsAdmin, "Unsigned admin flag should be ignored")
+	assert.Empty(t, act.Roles, "Unsigned roles should be ignored")
+	assert.Empty(t, act.Permissions, "Unsigned permissions should be ignored")
+	assert.Empty(t, act.Scopes, "Unsigned scopes should be ignored")
+	assert.Zero(t, act.MFAAt, "Unsigned MFA time should be ignored")
+}
@@ -0,0 +1,168 @@
+package auth
+
+import (
+	"strings"
+	"sync"
+
+	"github.com/arqut/common/api"
+	"github.com/gofiber/fiber/v2"
+	"gorm.io/gorm"
+)
+
+// PolicyProvider resolves the permissions granted by roles, on top of the
+// permissions carried by the token.
+type PolicyProvider interface {
+	PermissionsForRoles(roles []string) ([]string, error)
+}
+
+// StaticPolicy maps roles to their permissions.
+type StaticPolicy map[string][]string
+
+func (p StaticPolicy) PermissionsForRoles(roles []string) ([]string, error) {
+	var permissions []string
+	for _, role := range roles {
+		permissions = append(permissions, p[role]...)
+	}
+	return permissions, nil
+}
+
+// RolePermission grants Permission to Role, see GormPolicyProvider.
+type RolePermission struct {
+	ID         uint   `gorm:"primaryKey"`
+	Role       string `gorm:"type:varchar(64);index"`
+	Permission string `gorm:"type:varchar(128)"`
+}
+
+// GormPolicyProvider reads the role definitions from the database.
+type GormPolicyProvider struct {
+	db *gorm.DB
+}
+
+// NewGormPolicyProvider initializes a new GormPolicyProvider and migrates the
+// RolePermission schema.
+func NewGormPolicyProvider(db *gorm.DB) *GormPolicyProvider {
+	db.AutoMigrate(&RolePermission{})
+	return &GormPolicyProvider{db: db}
+}
+
+func (p *GormPolicyProvider) PermissionsForRoles(roles []string) ([]string, error) {
+	if len(roles) == 0 {
+		return nil, nil
+	}
+
+	var permissions []string
+	err := p.db.Model(&RolePermission{}).Where("role IN ?", roles).Pluck("permission", &permissions).Error
+	return permissions, err
+}
+
+var (
+	policyProvider   PolicyProvider
+	policyProviderMu sync.RWMutex
+)
+
+// SetPolicyProvider sets the PolicyProvider used by RequirePermissions, without
+// one only the permissions carried by the token are granted.
+func SetPolicyProvider(provider PolicyProvider) {
+	policyProviderMu.Lock()
+	defer policyProviderMu.Unlock()
+	policyProvider = provider
+}
+
+// MatchPermission reports whether the granted permission covers required.
+// Permissions are ":" separated, a "*" segment matches any segment and a
+// trailing "*" also matches all the nested ones, so "orders:*" covers
+// "orders:read" and "orders:items:write".
+func MatchPermission(granted, required string) bool {
+	grantedParts := strings.Split(granted, ":")
+	requiredParts := strings.Split(required, ":")
+
+	for i, part := range grantedParts {
+		if part == "*" && i == len(grantedParts)-1 {
+			return len(requiredParts) > i
+		}
+		if i >= len(requiredParts) || (part != "*" && part != requiredParts[i]) {
+			return false
+		}
+	}
+
+	return len(grantedParts) == len(requiredParts)
+}
+
+// HasRoles reports whether the account has any of roles.
+func HasRoles(act *AuthTokenData, roles ...string) bool {
+	for _, role := range roles {
+		for _, granted := range act.Roles {
+			if granted == role {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// HasPermissions reports whether the account is granted all permissions,
+// either by the token or by its roles through the PolicyProvider.
+func HasPermissions(act *AuthTokenData, permissions ...string) (bool, error) {
+	granted := act.Permissions
+
+	policyProviderMu.RLock()
+	provider := policyProvider
+	policyProviderMu.RUnlock()
+
+	if provider != nil && len(act.Roles) > 0 {
+		rolePermissions, err := provider.PermissionsForRoles(act.Roles)
+		if err != nil {
+			return false, err
+		}
+		granted = append(append([]string(nil), granted...), rolePermissions...)
+	}
+
+	for _, required := range permissions {
+		if !matchAny(granted, required) {
+			return false, nil
+		}
+	}
+	return true, nil
+}
+
+// RequireRoles only lets through accounts having any of roles.
+func RequireRoles(roles ...string) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		act, ok := Account(c)
+		if !ok {
+			return api.ErrorUnauthorizedResp(c, "Unauthorized")
+		}
+		if !HasRoles(act, roles...) {
+			return api.ErrorForbiddenResp(c, "Forbidden: missing role")
+		}
+		return c.Next()
+	}
+}
+
+// RequirePermissions only lets through accounts granted all permissions.
+func RequirePermissions(permissions ...string) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		act, ok := Account(c)
+		if !ok {
+			return api.ErrorUnauthorizedResp(c, "Unauthorized")
+		}
+
+		allowed, err := HasPermissions(act, permissions...)
+		if err != nil {
+			return api.ErrorInternalServerErrorResp(c, "Failed to resolve permissions")
+		}
+		if !allowed {
+			return api.ErrorForbiddenResp(c, "Forbidden: missing permission")
+		}
+		return c.Next()
+	}
+}
+
+func matchAny(granted []string, required string) bool {
+	for _, permission := range granted {
+		if MatchPermission(permission, required) {
+			return true
+		}
+	}
+	return false
+}
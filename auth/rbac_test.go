@@ -0,0 +1,119 @@
+package auth
+
+import (
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+)
+
+func TestMatchPermission(t *testing.T) {
+	tests := []struct {
+		granted  string
+		required string
+		want     bool
+	}{
+		{"orders:read", "orders:read", true},
+		{"orders:read", "orders:write", false},
+		{"orders:*", "orders:read", true},
+		{"orders:*", "orders:items:write", true},
+		{"orders:*", "orders", false},
+		{"orders:*", "users:read", false},
+		{"*", "orders:read", true},
+		{"*:read", "orders:read", true},
+		{"*:read", "orders:write", false},
+		{"*:read", "orders:items:read", false},
+		{"orders", "orders:read", false},
+		{"orders:items:read", "orders:items", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.granted+"/"+tt.required, func(t *testing.T) {
+			assert.Equal(t, tt.want, MatchPermission(tt.granted, tt.required))
+		})
+	}
+}
+
+// rbacApp serves the handler behind a middleware setting act as the account.
+func rbacApp(act *AuthTokenData, handler fiber.Handler) *fiber.App {
+	app := fiber.New()
+	app.Get("/", func(c *fiber.Ctx) error {
+		if act != nil {
+			c.Locals("account", act)
+		}
+		return c.Next()
+	}, handler, func(c *fiber.Ctx) error {
+		return c.SendStatus(fiber.StatusOK)
+	})
+	return app
+}
+
+func rbacStatus(t *testing.T, app *fiber.App) int {
+	resp, err := app.Test(httptest.NewRequest("GET", "/", nil))
+	require.NoError(t, err, "Request should not fail")
+	return resp.StatusCode
+}
+
+func TestRequireRoles(t *testing.T) {
+	act := &AuthTokenData{ID: 1, Roles: []string{"editor"}}
+
+	assert.Equal(t, fiber.StatusOK, rbacStatus(t, rbacApp(act, RequireRoles("admin", "editor"))), "Any of the roles should be enough")
+	assert.Equal(t, fiber.StatusForbidden, rbacStatus(t, rbacApp(act, RequireRoles("admin"))), "Missing role should be forbidden")
+	assert.Equal(t, fiber.StatusUnauthorized, rbacStatus(t, rbacApp(nil, RequireRoles("admin"))), "Missing account should be unauthorized")
+}
+
+func TestRequirePermissions(t *testing.T) {
+	defer SetPolicyProvider(nil)
+
+	act := &AuthTokenData{ID: 1, Roles: []string{"support"}, Permissions: []string{"orders:*"}}
+
+	assert.Equal(t, fiber.StatusOK, rbacStatus(t, rbacApp(act, RequirePermissions("orders:read", "orders:refunds:create"))), "Token permissions should be granted")
+	assert.Equal(t, fiber.StatusForbidden, rbacStatus(t, rbacApp(act, RequirePermissions("orders:read", "users:read"))), "All permissions should be required")
+
+	SetPolicyProvider(StaticPolicy{"support": {"users:read"}})
+	assert.Equal(t, fiber.StatusOK, rbacStatus(t, rbacApp(act, RequirePermissions("orders:read", "users:read"))), "Role permissions should be granted")
+	assert.Equal(t, fiber.StatusForbidden, rbacStatus(t, rbacApp(act, RequirePermissions("users:write"))), "Missing permission should be forbidden")
+	assert.Equal(t, fiber.StatusUnauthorized, rbacStatus(t, rbacApp(nil, RequirePermissions("users:read"))), "Missing account should be unauthorized")
+}
+
+func TestGormPolicyProvider(t *testing.T) {
+	db, err := gorm.Open(sqlite.Open(":memory:"), &gorm.Config{})
+	require.NoError(t, err, "Should connect to in-memory SQLite without error")
+
+	provider := NewGormPolicyProvider(db)
+	db.Create(&[]RolePermission{
+		{Role: "support", Permission: "users:read"},
+		{Role: "billing", Permission: "invoices:*"},
+		{Role: "admin", Permission: "*"},
+	})
+
+	permissions, err := provider.PermissionsForRoles([]string{"support", "billing"})
+	require.NoError(t, err, "PermissionsForRoles should not return an error")
+	assert.ElementsMatch(t, []string{"users:read", "invoices:*"}, permissions, "Permissions of the roles should be returned")
+
+	defer SetPolicyProvider(nil)
+	SetPolicyProvider(provider)
+	allowed, err := HasPermissions(&AuthTokenData{Roles: []string{"billing"}}, "invoices:pay")
+	require.NoError(t, err, "HasPermissions should not return an error")
+	assert.True(t, allowed, "Permissions from the database should be granted")
+}
+
+func TestProxyAuthMiddleware_RolesAndPermissions(t *testing.T) {
+	app := fiber.New()
+	app.Get("/", ProxyAuthMiddleware(ProxyAuthOptions{Secret: proxyTestSecret}), RequirePermissions("orders:read"), func(c *fiber.Ctx) error {
+		act := c.Locals("account").(*AuthTokenData)
+		assert.Equal(t, []string{"support", "billing"}, act.Roles, "Roles should be read from the header")
+		return c.SendStatus(fiber.StatusOK)
+	})
+
+	headers := signedProxyHeaders(map[string]string{
+		"X-User-Id":          "1",
+		"X-User-Roles":       "support,billing",
+		"X-User-Permissions": "orders:read,orders:write",
+	})
+	assert.Equal(t, fiber.StatusOK, proxyStatus(t, app, headers), "Permissions should be read from the header")
+}
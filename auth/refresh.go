@@ -0,0 +1,426 @@
+package auth
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"gorm.io/gorm"
+
+	commonJWT "github.com/arqut/common/jwt"
+	"github.com/arqut/common/system"
+	"github.com/arqut/common/utils"
+)
+
+const (
+	refreshTokenLength = 48
+	familyIDLength     = 16
+)
+
+// TokenPair is a short lived access token with the opaque refresh token used
+// to get the next pair.
+type TokenPair struct {
+	AccessToken  string `json:"accessToken"`
+	RefreshToken string `json:"refreshToken"`
+	ExpiresIn    int64  `json:"expiresIn"` // Access token lifetime in seconds
+}
+
+// RefreshTokenEntry is the server side state of a refresh token. Tokens are
+// only stored hashed, the tokens rotated from the same login share FamilyID.
+type RefreshTokenEntry struct {
+	ID        string `gorm:"primaryKey;type:varchar(64)"` // SHA-256 of the token
+	FamilyID  string `gorm:"type:varchar(32);index"`
+	Data      []byte // JSON encoded AuthTokenData
+	ExpiresAt time.Time
+	UsedAt    *time.Time
+	RevokedAt *time.Time
+	CreatedAt time.Time
+
+	// AccessTokenID is the jti of the access token issued with the refresh
+	// token, revoked with its family until AccessTokenExpiresAt.
+	AccessTokenID        string `gorm:"type:varchar(64)"`
+	AccessTokenExpiresAt time.Time
+}
+
+// RefreshTokenStore persists refresh tokens.
+type RefreshTokenStore interface {
+	SaveRefreshToken(entry RefreshTokenEntry) error
+	// GetRefreshToken returns nil when id is unknown.
+	GetRefreshToken(id string) (*RefreshTokenEntry, error)
+	// MarkRefreshTokenUsed marks id as used, it returns false if it already was.
+	MarkRefreshTokenUsed(id string, usedAt time.Time) (bool, error)
+	RevokeRefreshTokenFamily(familyID string, revokedAt time.Time) error
+	// GetRefreshTokenFamily returns the tokens of familyID.
+	GetRefreshTokenFamily(familyID string) ([]RefreshTokenEntry, error)
+}
+
+// RefreshTokenOptions configures the lifetimes of the tokens of a pair.
+type RefreshTokenOptions struct {
+	// AccessTokenDuration defaults to JWT_DURATION, or 2h.
+	AccessTokenDuration time.Duration
+	// RefreshTokenDuration defaults to JWT_REFRESH_DURATION, or 30D.
+	RefreshTokenDuration time.Duration
+	// Reload returns the current data of the account a refresh token was
+	// issued for, so role or status changes apply from the next rotation.
+	// Returning nil data, e.g. for disabled accounts, revokes the token
+	// family. Without Reload the data of the login is kept.
+	Reload func(data *AuthTokenData) (*AuthTokenData, error)
+}
+
+// RefreshTokenManager issues access/refresh token pairs. Refresh tokens are
+// single use, presenting one twice revokes every token of its family. The
+// access tokens of a revoked family are also revoked when the KeyManager has
+// a revocation store.
+type RefreshTokenManager struct {
+	keyManager *commonJWT.KeyManager
+	store      RefreshTokenStore
+	options    RefreshTokenOptions
+}
+
+func NewRefreshTokenManager(keyManager *commonJWT.KeyManager, store RefreshTokenStore, options ...RefreshTokenOptions) *RefreshTokenManager {
+	var opts RefreshTokenOptions
+	if len(options) > 0 {
+		opts = options[0]
+	}
+	if opts.AccessTokenDuration <= 0 {
+		opts.AccessTokenDuration, _ = utils.ParseDuration(system.Env("JWT_DURATION", "2h"))
+	}
+	if opts.RefreshTokenDuration <= 0 {
+		opts.RefreshTokenDuration, _ = utils.ParseDuration(system.Env("JWT_REFRESH_DURATION", "30D"))
+	}
+
+	return &RefreshTokenManager{
+		keyManager: keyManager,
+		store:      store,
+		options:    opts,
+	}
+}
+
+// IssueTokenPair starts a new token family, usually on login.
+func (m *RefreshTokenManager) IssueTokenPair(data *AuthTokenData) (*TokenPair, error) {
+	familyID, err := utils.GenerateRandomString(familyIDLength)
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate token family: %w", err)
+	}
+
+	return m.issue(familyID, data)
+}
+
+// Rotate exchanges a refresh token for a new pair of the same family. A
+// refresh token used twice is assumed stolen and its whole family revoked.
+func (m *RefreshTokenManager) Rotate(refreshToken string) (*TokenPair, error) {
+	entry, err := m.lookup(refreshToken)
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	if entry.UsedAt != nil {
+		return nil, m.reused(entry, now)
+	}
+	if now.After(entry.ExpiresAt) {
+		return nil, ErrRefreshTokenExpired
+	}
+
+	data := &AuthTokenData{}
+	if err := json.Unmarshal(entry.Data, data); err != nil {
+		return nil, fmt.Errorf("failed to decode refresh token data: %w", err)
+	}
+
+	if m.options.Reload != nil {
+		if data, err = m.options.Reload(data); err != nil {
+			return nil, fmt.Errorf("failed to reload account: %w", err)
+		}
+		if data == nil {
+			if err := m.revokeFamily(entry.FamilyID, now); err != nil {
+				return nil, err
+			}
+			return nil, ErrInvalidRefreshToken
+		}
+	}
+
+	// The next pair is saved before the token is marked used, so a failure
+	// leaves the token valid for a retry. A pair saved by a use losing the
+	// race below is revoked with the family.
+	pair, err := m.issue(entry.FamilyID, data)
+	if err != nil {
+		return nil, err
+	}
+
+	marked, err := m.store.MarkRefreshTokenUsed(entry.ID, now)
+	if err != nil {
+		return nil, fmt.Errorf("failed to mark refresh token used: %w", err)
+	}
+	if !marked {
+		// Lost a race against another use of the same token
+		return nil, m.reused(entry, now)
+	}
+
+	return pair, nil
+}
+
+// RevokeRefreshToken revokes the family of refreshToken and its access
+// tokens, usually on logout.
+func (m *RefreshTokenManager) RevokeRefreshToken(refreshToken string) error {
+	entry, err := m.lookup(refreshToken)
+	if err != nil {
+		return err
+	}
+
+	return m.revokeFamily(entry.FamilyID, time.Now())
+}
+
+func (m *RefreshTokenManager) issue(familyID string, data *AuthTokenData) (*TokenPair, error) {
+	encoded, err := json.Marshal(data)
+	if err != nil {
+		return nil, err
+	}
+
+	accessToken, err := GenerateToken(m.keyManager, data, m.options.AccessTokenDuration)
+	if err != nil {
+		return nil, err
+	}
+	accessTokenID, accessTokenExpiresAt := tokenIDHeaders(*accessToken)
+
+	refreshToken, err := utils.GenerateRandomString(refreshTokenLength)
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate refresh token: %w", err)
+	}
+
+	now := time.Now()
+	err = m.store.SaveRefreshToken(RefreshTokenEntry{
+		ID:        hashRefreshToken(refreshToken),
+		FamilyID:  familyID,
+		Data:      encoded,
+		ExpiresAt: now.Add(m.options.RefreshTokenDuration),
+		CreatedAt: now,
+
+		AccessTokenID:        accessTokenID,
+		AccessTokenExpiresAt: accessTokenExpiresAt,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to save refresh token: %w", err)
+	}
+
+	return &TokenPair{
+		AccessToken:  *accessToken,
+		RefreshToken: refreshToken,
+		ExpiresIn:    int64(m.options.AccessTokenDuration.Seconds()),
+	}, nil
+}
+
+func (m *RefreshTokenManager) lookup(refreshToken string) (*RefreshTokenEntry, error) {
+	if refreshToken == "" {
+		return nil, ErrInvalidRefreshToken
+	}
+
+	entry, err := m.store.GetRefreshToken(hashRefreshToken(refreshToken))
+	if err != nil {
+		return nil, fmt.Errorf("failed to get refresh token: %w", err)
+	}
+	if entry == nil || entry.RevokedAt != nil {
+		return nil, ErrInvalidRefreshToken
+	}
+
+	return entry, nil
+}
+
+func (m *RefreshTokenManager) reused(entry *RefreshTokenEntry, now time.Time) error {
+	system.Logger.Warnf("Refresh token reused, revoking token family %s", entry.FamilyID)
+	if err := m.revokeFamily(entry.FamilyID, now); err != nil {
+		return fmt.Errorf("%w, %w", ErrRefreshTokenReused, err)
+	}
+	return ErrRefreshTokenReused
+}
+
+// revokeFamily revokes the refresh tokens of familyID and the access tokens
+// issued with them that did not expire yet.
+func (m *RefreshTokenManager) revokeFamily(familyID string, now time.Time) error {
+	if err := m.store.RevokeRefreshTokenFamily(familyID, now); err != nil {
+		return fmt.Errorf("failed to revoke token family: %w", err)
+	}
+
+	entries, err := m.store.GetRefreshTokenFamily(familyID)
+	if err != nil {
+		return fmt.Errorf("failed to get token family: %w", err)
+	}
+
+	for _, entry := range entries {
+		if entry.AccessTokenID == "" || (!entry.AccessTokenExpiresAt.IsZero() && now.After(entry.AccessTokenExpiresAt)) {
+			continue
+		}
+		err := m.keyManager.RevokeID(entry.AccessTokenID, entry.AccessTokenExpiresAt)
+		if errors.Is(err, commonJWT.ErrRevocationDisabled) {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("failed to revoke access token: %w", err)
+		}
+	}
+
+	return nil
+}
+
+// tokenIDHeaders reads the jti and exp protected headers of a token issued by
+// GenerateToken.
+func tokenIDHeaders(token string) (string, time.Time) {
+	header, err := base64.RawURLEncoding.DecodeString(strings.SplitN(token, ".", 2)[0])
+	if err != nil {
+		return "", time.Time{}
+	}
+
+	var headers struct {
+		JTI string `json:"jti"`
+		Exp int64  `json:"exp"`
+	}
+	if err := json.Unmarshal(header, &headers); err != nil {
+		return "", time.Time{}
+	}
+
+	var expiresAt time.Time
+	if headers.Exp > 0 {
+		expiresAt = time.Unix(headers.Exp, 0)
+	}
+	return headers.JTI, expiresAt
+}
+
+func hashRefreshToken(refreshToken string) string {
+	sum := sha256.Sum256([]byte(refreshToken))
+	return hex.EncodeToString(sum[:])
+}
+
+// InMemoryRefreshTokenStore is an in-memory implementation of RefreshTokenStore.
+type InMemoryRefreshTokenStore struct {
+	mu      sync.Mutex
+	entries map[string]RefreshTokenEntry
+}
+
+// NewInMemoryRefreshTokenStore initializes a new in-memory refresh token store.
+func NewInMemoryRefreshTokenStore() *InMemoryRefreshTokenStore {
+	return &InMemoryRefreshTokenStore{entries: make(map[string]RefreshTokenEntry)}
+}
+
+func (s *InMemoryRefreshTokenStore) SaveRefreshToken(entry RefreshTokenEntry) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	// Drop the expired tokens on the way
+	now := time.Now()
+	for id, e := range s.entries {
+		if now.After(e.ExpiresAt) {
+			delete(s.entries, id)
+		}
+	}
+	s.entries[entry.ID] = entry
+	return nil
+}
+
+func (s *InMemoryRefreshTokenStore) GetRefreshToken(id string) (*RefreshTokenEntry, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	entry, ok := s.entries[id]
+	if !ok {
+		return nil, nil
+	}
+	return &entry, nil
+}
+
+func (s *InMemoryRefreshTokenStore) MarkRefreshTokenUsed(id string, usedAt time.Time) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	entry, ok := s.entries[id]
+	if !ok || entry.UsedAt != nil {
+		return false, nil
+	}
+	entry.UsedAt = &usedAt
+	s.entries[id] = entry
+	return true, nil
+}
+
+func (s *InMemoryRefreshTokenStore) RevokeRefreshTokenFamily(familyID string, revokedAt time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for id, entry := range s.entries {
+		if entry.FamilyID == familyID && entry.RevokedAt == nil {
+			entry.RevokedAt = &revokedAt
+			s.entries[id] = entry
+		}
+	}
+	return nil
+}
+
+func (s *InMemoryRefreshTokenStore) GetRefreshTokenFamily(familyID string) ([]RefreshTokenEntry, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var entries []RefreshTokenEntry
+	for _, entry := range s.entries {
+		if entry.FamilyID == familyID {
+			entries = append(entries, entry)
+		}
+	}
+	return entries, nil
+}
+
+// GormRefreshTokenStore uses GORM to persist refresh tokens.
+type GormRefreshTokenStore struct {
+	db *gorm.DB
+}
+
+// NewGormRefreshTokenStore initializes a new GormRefreshTokenStore and migrates
+// the RefreshTokenEntry schema.
+func NewGormRefreshTokenStore(db *gorm.DB) *GormRefreshTokenStore {
+	db.AutoMigrate(&RefreshTokenEntry{})
+	return &GormRefreshTokenStore{db: db}
+}
+
+func (s *GormRefreshTokenStore) SaveRefreshToken(entry RefreshTokenEntry) error {
+	return s.db.Create(&entry).Error
+}
+
+func (s *GormRefreshTokenStore) GetRefreshToken(id string) (*RefreshTokenEntry, error) {
+	entry := &RefreshTokenEntry{}
+	err := s.db.Where("id = ?", id).First(entry).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return entry, nil
+}
+
+// MarkRefreshTokenUsed is a single conditional update so concurrent uses of
+// the same token cannot both succeed.
+func (s *GormRefreshTokenStore) MarkRefreshTokenUsed(id string, usedAt time.Time) (bool, error) {
+	result := s.db.Model(&RefreshTokenEntry{}).
+		Where("id = ? AND used_at IS NULL", id).
+		Update("used_at", usedAt)
+	if result.Error != nil {
+		return false, result.Error
+	}
+	return result.RowsAffected == 1, nil
+}
+
+func (s *GormRefreshTokenStore) RevokeRefreshTokenFamily(familyID string, revokedAt time.Time) error {
+	return s.db.Model(&RefreshTokenEntry{}).
+		Where("family_id = ? AND revoked_at IS NULL", familyID).
+		Update("revoked_at", revokedAt).Error
+}
+
+func (s *GormRefreshTokenStore) GetRefreshTokenFamily(familyID string) ([]RefreshTokenEntry, error) {
+	var entries []RefreshTokenEntry
+	if err := s.db.Where("family_id = ?", familyID).Find(&entries).Error; err != nil {
+		return nil, err
+	}
+	return entries, nil
+}
@@ -0,0 +1,222 @@
+package auth
+
+import (
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	commonJWT "github.com/arqut/common/jwt"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+)
+
+func refreshTokenStores(t *testing.T) map[string]RefreshTokenStore {
+	db, err := gorm.Open(sqlite.Open(":memory:"), &gorm.Config{})
+	require.NoError(t, err, "Should connect to in-memory SQLite without error")
+	sqlDB, err := db.DB()
+	require.NoError(t, err, "Should get the SQL database")
+	sqlDB.SetMaxOpenConns(1)
+
+	return map[string]RefreshTokenStore{
+		"InMemory": NewInMemoryRefreshTokenStore(),
+		"Gorm":     NewGormRefreshTokenStore(db),
+	}
+}
+
+func TestRefreshTokenManager_Rotate(t *testing.T) {
+	for name, store := range refreshTokenStores(t) {
+		t.Run(name, func(t *testing.T) {
+			km := setupKeyManager(t, 24*time.Hour)
+			defer km.Shutdown()
+			manager := NewRefreshTokenManager(km, store, RefreshTokenOptions{
+				AccessTokenDuration:  15 * time.Minute,
+				RefreshTokenDuration: time.Hour,
+			})
+
+			data := &AuthTokenData{ID: 42, Email: "user@example.com"}
+			pair, err := manager.IssueTokenPair(data)
+			require.NoError(t, err, "IssueTokenPair should not return an error")
+			assert.Equal(t, int64(900), pair.ExpiresIn, "ExpiresIn should be the access token lifetime")
+
+			parsed, err := ParseToken(km, pair.AccessToken)
+			require.NoError(t, err, "Access token should be valid")
+			assert.Equal(t, data, parsed, "Access token should carry the token data")
+
+			rotated, err := manager.Rotate(pair.RefreshToken)
+			require.NoError(t, err, "Rotate should not return an error")
+			assert.NotEqual(t, pair.RefreshToken, rotated.RefreshToken, "Rotate should return a new refresh token")
+
+			parsed, err = ParseToken(km, rotated.AccessToken)
+			require.NoError(t, err, "Rotated access token should be valid")
+			assert.Equal(t, data, parsed, "Rotated access token should carry the token data")
+
+			// Reusing the first refresh token revokes the whole family
+			_, err = manager.Rotate(pair.RefreshToken)
+			assert.ErrorIs(t, err, ErrRefreshTokenReused, "Reused refresh token should be detected")
+			_, err = manager.Rotate(rotated.RefreshToken)
+			assert.ErrorIs(t, err, ErrInvalidRefreshToken, "Family should be revoked after reuse")
+
+			_, err = manager.Rotate("unknown")
+			assert.ErrorIs(t, err, ErrInvalidRefreshToken, "Unknown refresh token should be rejected")
+		})
+	}
+}
+
+func TestRefreshTokenManager_Expiry(t *testing.T) {
+	km := setupKeyManager(t, 24*time.Hour)
+	defer km.Shutdown()
+	manager := NewRefreshTokenManager(km, NewInMemoryRefreshTokenStore(), RefreshTokenOptions{
+		RefreshTokenDuration: 50 * time.Millisecond,
+	})
+
+	pair, err := manager.IssueTokenPair(&AuthTokenData{ID: 1})
+	require.NoError(t, err, "IssueTokenPair should not return an error")
+
+	time.Sleep(100 * time.Millisecond)
+	_, err = manager.Rotate(pair.RefreshToken)
+	assert.ErrorIs(t, err, ErrRefreshTokenExpired, "Expired refresh token should be rejected")
+}
+
+func TestRefreshTokenManager_RevokeRefreshToken(t *testing.T) {
+	km := setupKeyManager(t, 24*time.Hour)
+	defer km.Shutdown()
+	manager := NewRefreshTokenManager(km, NewInMemoryRefreshTokenStore())
+
+	pair, err := manager.IssueTokenPair(&AuthTokenData{ID: 1})
+	require.NoError(t, err, "IssueTokenPair should not return an error")
+	other, err := manager.IssueTokenPair(&AuthTokenData{ID: 1})
+	require.NoError(t, err, "IssueTokenPair should not return an error")
+
+	require.NoError(t, manager.RevokeRefreshToken(pair.RefreshToken), "RevokeRefreshToken should not return an error")
+	_, err = manager.Rotate(pair.RefreshToken)
+	assert.ErrorIs(t, err, ErrInvalidRefreshToken, "Revoked refresh token should be rejected")
+
+	_, err = manager.Rotate(other.RefreshToken)
+	assert.NoError(t, err, "Other families should stay valid")
+}
+
+func TestRefreshTokenManager_ConcurrentRotate(t *testing.T) {
+	for name, store := range refreshTokenStores(t) {
+		t.Run(name, func(t *testing.T) {
+			km := setupKeyManager(t, 24*time.Hour)
+			defer km.Shutdown()
+			manager := NewRefreshTokenManager(km, store)
+
+			pair, err := manager.IssueTokenPair(&AuthTokenData{ID: 1})
+			require.NoError(t, err, "IssueTokenPair should not return an error")
+
+			var wg sync.WaitGroup
+			var mu sync.Mutex
+			succeeded := 0
+			for i := 0; i < 5; i++ {
+				wg.Add(1)
+				go func() {
+					defer wg.Done()
+					if _, err := manager.Rotate(pair.RefreshToken); err == nil {
+						mu.Lock()
+						succeeded++
+						mu.Unlock()
+					}
+				}()
+			}
+			wg.Wait()
+
+			assert.LessOrEqual(t, succeeded, 1, "A refresh token should only be used once")
+		})
+	}
+}
+
+func TestRefreshTokenManager_Reload(t *testing.T) {
+	km := setupKeyManager(t, 24*time.Hour)
+	defer km.Shutdown()
+
+	accounts := map[uint64]*AuthTokenData{1: {ID: 1, Roles: []string{"user"}}}
+	manager := NewRefreshTokenManager(km, NewInMemoryRefreshTokenStore(), RefreshTokenOptions{
+		Reload: func(data *AuthTokenData) (*AuthTokenData, error) {
+			return accounts[data.ID], nil
+		},
+	})
+
+	pair, err := manager.IssueTokenPair(accounts[1])
+	require.NoError(t, err, "IssueTokenPair should not return an error")
+
+	accounts[1] = &AuthTokenData{ID: 1, Roles: []string{"admin"}}
+	rotated, err := manager.Rotate(pair.RefreshToken)
+	require.NoError(t, err, "Rotate should not return an error")
+	parsed, err := ParseToken(km, rotated.AccessToken)
+	require.NoError(t, err, "Rotated access token should be valid")
+	assert.Equal(t, []string{"admin"}, parsed.Roles, "Rotated access token should carry the reloaded data")
+
+	delete(accounts, 1)
+	_, err = manager.Rotate(rotated.RefreshToken)
+	assert.ErrorIs(t, err, ErrInvalidRefreshToken, "Refresh tokens of disabled accounts should be rejected")
+
+	accounts[1] = &AuthTokenData{ID: 1}
+	_, err = manager.Rotate(rotated.RefreshToken)
+	assert.ErrorIs(t, err, ErrInvalidRefreshToken, "Family of disabled accounts should be revoked")
+}
+
+func TestRefreshTokenManager_RevokesAccessTokens(t *testing.T) {
+	km, err := commonJWT.NewIssuerKeyManager(24*time.Hour, commonJWT.NewInMemoryKeyStore(), commonJWT.KeyManagerOptions{
+		Revocations: commonJWT.NewInMemoryRevocationStore(),
+	})
+	require.NoError(t, err, "Failed to initialize KeyManager")
+	defer km.Shutdown()
+	manager := NewRefreshTokenManager(km, NewInMemoryRefreshTokenStore())
+
+	pair, err := manager.IssueTokenPair(&AuthTokenData{ID: 1})
+	require.NoError(t, err, "IssueTokenPair should not return an error")
+	rotated, err := manager.Rotate(pair.RefreshToken)
+	require.NoError(t, err, "Rotate should not return an error")
+	other, err := manager.IssueTokenPair(&AuthTokenData{ID: 1})
+	require.NoError(t, err, "IssueTokenPair should not return an error")
+
+	_, err = manager.Rotate(pair.RefreshToken)
+	require.ErrorIs(t, err, ErrRefreshTokenReused, "Reused refresh token should be detected")
+
+	_, err = ParseToken(km, pair.AccessToken)
+	assert.ErrorIs(t, err, ErrTokenRevoked, "Access tokens of the family should be revoked")
+	_, err = ParseToken(km, rotated.AccessToken)
+	assert.ErrorIs(t, err, ErrTokenRevoked, "Access tokens of the family should be revoked")
+	_, err = ParseToken(km, other.AccessToken)
+	assert.NoError(t, err, "Access tokens of other families should stay valid")
+
+	require.NoError(t, manager.RevokeRefreshToken(other.RefreshToken), "RevokeRefreshToken should not return an error")
+	_, err = ParseToken(km, other.AccessToken)
+	assert.ErrorIs(t, err, ErrTokenRevoked, "Logout should revoke the access tokens of the family")
+}
+
+// failingRefreshTokenStore fails the next SaveRefreshToken calls.
+type failingRefreshTokenStore struct {
+	*InMemoryRefreshTokenStore
+	failures int
+}
+
+func (s *failingRefreshTokenStore) SaveRefreshToken(entry RefreshTokenEntry) error {
+	if s.failures > 0 {
+		s.failures--
+		return errors.New("database unavailable")
+	}
+	return s.InMemoryRefreshTokenStore.SaveRefreshToken(entry)
+}
+
+func TestRefreshTokenManager_RetryAfterSaveFailure(t *testing.T) {
+	km := setupKeyManager(t, 24*time.Hour)
+	defer km.Shutdown()
+	store := &failingRefreshTokenStore{InMemoryRefreshTokenStore: NewInMemoryRefreshTokenStore()}
+	manager := NewRefreshTokenManager(km, store)
+
+	pair, err := manager.IssueTokenPair(&AuthTokenData{ID: 1})
+	require.NoError(t, err, "IssueTokenPair should not return an error")
+
+	store.failures = 1
+	_, err = manager.Rotate(pair.RefreshToken)
+	require.Error(t, err, "Rotate should report the store failure")
+	assert.NotErrorIs(t, err, ErrRefreshTokenReused, "Store failures should not be reported as reuse")
+
+	_, err = manager.Rotate(pair.RefreshToken)
+	assert.NoError(t, err, "Refresh token should stay valid for a retry")
+}
@@ -0,0 +1,53 @@
+package auth
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/arqut/common/api"
+	"github.com/gofiber/fiber/v2"
+)
+
+// MissingScopes returns the scopes the account has not been granted.
+func MissingScopes(act *AuthTokenData, scopes ...string) []string {
+	var missing []string
+	for _, scope := range scopes {
+		granted := false
+		for _, s := range act.Scopes {
+			if s == scope {
+				granted = true
+				break
+			}
+		}
+		if !granted {
+			missing = append(missing, scope)
+		}
+	}
+	return missing
+}
+
+// RequireScopes only lets through API keys and tokens granted all scopes.
+// Otherwise it responds 403 with the required and missing scopes in the error
+// detail, and a RFC 6750 insufficient_scope challenge.
+func RequireScopes(scopes ...string) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		act, ok := Account(c)
+		if !ok {
+			return api.ErrorUnauthorizedResp(c, "Unauthorized")
+		}
+
+		missing := MissingScopes(act, scopes...)
+		if len(missing) > 0 {
+			c.Set(fiber.HeaderWWWAuthenticate, fmt.Sprintf(`Bearer error="insufficient_scope", scope="%s"`, strings.Join(scopes, " ")))
+			return api.ErrorResp(c, api.ApiError{
+				Code:    fiber.StatusForbidden,
+				Message: "Forbidden: insufficient scope",
+				Detail: fiber.Map{
+					"requiredScopes": scopes,
+					"missingScopes":  missing,
+				},
+			})
+		}
+		return c.Next()
+	}
+}
@@ -0,0 +1,53 @@
+package auth
+
+import (
+	"encoding/json"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRequireScopes(t *testing.T) {
+	act := &AuthTokenData{ID: 1, Scopes: []string{"read:users", "read:orders"}}
+
+	assert.Equal(t, fiber.StatusOK, rbacStatus(t, rbacApp(act, RequireScopes("read:users"))), "Granted scopes should pass")
+	assert.Equal(t, fiber.StatusUnauthorized, rbacStatus(t, rbacApp(nil, RequireScopes("read:users"))), "Missing account should be unauthorized")
+
+	resp, err := rbacApp(act, RequireScopes("read:users", "write:users")).Test(httptest.NewRequest("GET", "/", nil))
+	require.NoError(t, err, "Request should not fail")
+	assert.Equal(t, fiber.StatusForbidden, resp.StatusCode, "Missing scopes should be forbidden")
+	assert.Equal(t, `Bearer error="insufficient_scope", scope="read:users write:users"`, resp.Header.Get(fiber.HeaderWWWAuthenticate), "Response should carry the scope challenge")
+
+	var body struct {
+		Success bool `json:"success"`
+		Error   struct {
+			Code   int `json:"code"`
+			Detail struct {
+				RequiredScopes []string `json:"requiredScopes"`
+				MissingScopes  []string `json:"missingScopes"`
+			} `json:"detail"`
+		} `json:"error"`
+	}
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&body), "Response should be valid JSON")
+	assert.False(t, body.Success, "Response should not be successful")
+	assert.Equal(t, fiber.StatusForbidden, body.Error.Code, "Error code should be 403")
+	assert.Equal(t, []string{"read:users", "write:users"}, body.Error.Detail.RequiredScopes, "Detail should list the required scopes")
+	assert.Equal(t, []string{"write:users"}, body.Error.Detail.MissingScopes, "Detail should list the missing scopes")
+}
+
+func TestRequireScopes_TokenScopes(t *testing.T) {
+	km := setupKeyManager(t, 24*time.Hour)
+	defer km.Shutdown()
+
+	token, err := GenerateToken(km, &AuthTokenData{ID: 1, Scopes: []string{"read:users"}})
+	require.NoError(t, err, "GenerateToken should not return an error")
+
+	act, err := ParseToken(km, *token)
+	require.NoError(t, err, "ParseToken should not return an error")
+	assert.Empty(t, MissingScopes(act, "read:users"), "Scopes should be carried by the token")
+	assert.Equal(t, []string{"write:users"}, MissingScopes(act, "write:users"), "Scopes not in the token should be missing")
+}
@@ -0,0 +1,229 @@
+package auth
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/hex"
+	"time"
+
+	"github.com/arqut/common/api"
+	commonJWT "github.com/arqut/common/jwt"
+	"github.com/arqut/common/system"
+	"github.com/arqut/common/utils"
+	"github.com/gofiber/fiber/v2"
+)
+
+// SessionOptions configures the cookie holding the token of a browser session.
+type SessionOptions struct {
+	// CookieName defaults to AUTH_COOKIE_NAME or "auth_token".
+	CookieName string
+	// Domain defaults to AUTH_COOKIE_DOMAIN, the host of the request when empty.
+	Domain string
+	// Path defaults to "/".
+	Path string
+	// Insecure drops the Secure attribute, only meant for local development
+	// over plain HTTP.
+	Insecure bool
+	// SameSite is one of the fiber.CookieSameSite* modes, defaults to Lax.
+	SameSite string
+	// Expiration is the lifetime of the token and the cookie, defaults to
+	// JWT_DURATION or 2 hours.
+	Expiration time.Duration
+	// RefreshBefore is how long before its expiry SessionRefreshMiddleware
+	// reissues the token, defaults to a quarter of Expiration.
+	RefreshBefore time.Duration
+}
+
+// CSRFOptions configures CSRFMiddleware.
+type CSRFOptions struct {
+	// CookieName of the CSRF token readable by scripts, defaults to "csrf_token".
+	CookieName string
+	// HeaderName the token is submitted in, defaults to "X-CSRF-Token".
+	HeaderName string
+	// FormField the token is submitted in by HTML forms, defaults to "csrf_token".
+	FormField string
+	// Session is the session cookie requests are checked for, its Domain,
+	// Path, Insecure and SameSite are also used for the CSRF cookie.
+	Session SessionOptions
+}
+
+// localsCSRFToken is the local CSRFToken reads.
+const localsCSRFToken = "csrfToken"
+
+func newSessionOptions(options ...SessionOptions) SessionOptions {
+	var opts SessionOptions
+	if len(options) > 0 {
+		opts = options[0]
+	}
+	if opts.CookieName == "" {
+		opts.CookieName = system.Env("AUTH_COOKIE_NAME", "auth_token")
+	}
+	if opts.Domain == "" {
+		opts.Domain = system.Env("AUTH_COOKIE_DOMAIN")
+	}
+	if opts.Path == "" {
+		opts.Path = "/"
+	}
+	if opts.SameSite == "" {
+		opts.SameSite = fiber.CookieSameSiteLaxMode
+	}
+	if opts.Expiration <= 0 {
+		opts.Expiration, _ = utils.ParseDuration(system.Env("JWT_DURATION", "2h"))
+	}
+	if opts.RefreshBefore <= 0 {
+		opts.RefreshBefore = opts.Expiration / 4
+	}
+	return opts
+}
+
+func newCSRFOptions(options ...CSRFOptions) CSRFOptions {
+	var opts CSRFOptions
+	if len(options) > 0 {
+		opts = options[0]
+	}
+	if opts.CookieName == "" {
+		opts.CookieName = "csrf_token"
+	}
+	if opts.HeaderName == "" {
+		opts.HeaderName = "X-CSRF-Token"
+	}
+	if opts.FormField == "" {
+		opts.FormField = "csrf_token"
+	}
+	opts.Session = newSessionOptions(opts.Session)
+	return opts
+}
+
+// cookie returns a Secure cookie with the attributes of the session.
+func (o SessionOptions) cookie(name string, value string) *fiber.Cookie {
+	return &fiber.Cookie{
+		Name:     name,
+		Value:    value,
+		Domain:   o.Domain,
+		Path:     o.Path,
+		Secure:   !o.Insecure,
+		SameSite: o.SameSite,
+	}
+}
+
+// IssueSession generates a token for data with GenerateToken and sets it as
+// an HttpOnly session cookie.
+func IssueSession(c *fiber.Ctx, keyManager *commonJWT.KeyManager, data *AuthTokenData, options ...SessionOptions) error {
+	opts := newSessionOptions(options...)
+
+	token, err := GenerateToken(keyManager, data, opts.Expiration)
+	if err != nil {
+		return err
+	}
+	setSessionCookie(c, *token, opts)
+
+	return nil
+}
+
+// ClearSession expires the session cookie, revoke the token with RevokeToken
+// to also reject copies of it.
+func ClearSession(c *fiber.Ctx, options ...SessionOptions) {
+	opts := newSessionOptions(options...)
+
+	cookie := opts.cookie(opts.CookieName, "")
+	cookie.HTTPOnly = true
+	cookie.MaxAge = -1
+	cookie.Expires = time.Unix(0, 0)
+	c.Cookie(cookie)
+}
+
+func setSessionCookie(c *fiber.Ctx, token string, opts SessionOptions) {
+	cookie := opts.cookie(opts.CookieName, token)
+	cookie.HTTPOnly = true
+	cookie.Expires = time.Now().Add(opts.Expiration)
+	cookie.MaxAge = int(opts.Expiration.Seconds())
+	c.Cookie(cookie)
+}
+
+// RemoteSessionMiddleware is RemoteAuthMiddleware also accepting the token of
+// the session cookie, put CSRFMiddleware in front of it.
+func RemoteSessionMiddleware(options ...SessionOptions) fiber.Handler {
+	opts := newSessionOptions(options...)
+	extractTokens := "header:Authorization,cookie:" + opts.CookieName
+	return remoteMiddleware(extractTokens)
+}
+
+// SessionRefreshMiddleware reissues the session cookie of requests
+// authenticated with it when its token expires within RefreshBefore. Put it
+// after the auth middleware, keyManager must be an issuer.
+func SessionRefreshMiddleware(keyManager *commonJWT.KeyManager, options ...SessionOptions) fiber.Handler {
+	opts := newSessionOptions(options...)
+
+	return func(c *fiber.Ctx) error {
+		token := Token(c)
+		act, ok := Account(c)
+		if !ok || token == "" || token != c.Cookies(opts.CookieName) {
+			return c.Next()
+		}
+
+		expiresAt, ok := unverifiedExpiry(token)
+		if !ok || time.Until(expiresAt) > opts.RefreshBefore {
+			return c.Next()
+		}
+
+		refreshed, err := GenerateToken(keyManager, act, opts.Expiration)
+		if err != nil {
+			system.Logger.Errorf("Error refreshing session: %v", err)
+			return c.Next()
+		}
+		setSessionCookie(c, *refreshed, opts)
+		SetAccount(c, act, *refreshed)
+
+		return c.Next()
+	}
+}
+
+// CSRFMiddleware protects routes authenticated with the session cookie with
+// double-submit tokens. Every response carries the token in a cookie readable
+// by scripts, unsafe requests carrying the session cookie must echo it in the
+// header or form field. Requests carrying a Bearer token in the Authorization
+// header are not checked, RemoteSessionMiddleware authenticates them with it
+// and browsers never send it on their own. Other schemes, like Basic added by
+// a proxy, do not replace the session cookie and are checked.
+func CSRFMiddleware(options ...CSRFOptions) fiber.Handler {
+	opts := newCSRFOptions(options...)
+	bearerToken := MustCompileTokenLookup("header:Authorization")
+
+	return func(c *fiber.Ctx) error {
+		token := c.Cookies(opts.CookieName)
+		if token == "" {
+			raw := make([]byte, 32)
+			if _, err := rand.Read(raw); err != nil {
+				return api.ErrorInternalServerErrorResp(c, "Failed to generate CSRF token")
+			}
+			token = hex.EncodeToString(raw)
+			c.Cookie(opts.Session.cookie(opts.CookieName, token))
+		}
+		c.Locals(localsCSRFToken, token)
+
+		switch c.Method() {
+		case fiber.MethodGet, fiber.MethodHead, fiber.MethodOptions, fiber.MethodTrace:
+			return c.Next()
+		}
+		if bearerToken(c) != "" || c.Cookies(opts.Session.CookieName) == "" {
+			return c.Next()
+		}
+
+		submitted := c.Get(opts.HeaderName)
+		if submitted == "" {
+			submitted = c.FormValue(opts.FormField)
+		}
+		if submitted == "" || subtle.ConstantTimeCompare([]byte(submitted), []byte(token)) != 1 {
+			return api.ErrorForbiddenResp(c, "Forbidden: invalid CSRF token")
+		}
+
+		return c.Next()
+	}
+}
+
+// CSRFToken returns the CSRF token of the request set by CSRFMiddleware, to
+// render in the FormField of HTML forms.
+func CSRFToken(c *fiber.Ctx) string {
+	token, _ := c.Locals(localsCSRFToken).(string)
+	return token
+}
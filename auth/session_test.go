@@ -0,0 +1,184 @@
+package auth
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func responseCookie(resp *http.Response, name string) *http.Cookie {
+	for _, cookie := range resp.Cookies() {
+		if cookie.Name == name {
+			return cookie
+		}
+	}
+	return nil
+}
+
+func TestIssueSession(t *testing.T) {
+	km := setupKeyManager(t, 24*time.Hour)
+	defer km.Shutdown()
+
+	app := fiber.New()
+	app.Post("/login", func(c *fiber.Ctx) error {
+		return IssueSession(c, km, &AuthTokenData{ID: 1}, SessionOptions{Expiration: time.Hour})
+	})
+	app.Post("/logout", func(c *fiber.Ctx) error {
+		ClearSession(c)
+		return nil
+	})
+
+	resp, err := app.Test(httptest.NewRequest("POST", "/login", nil))
+	require.NoError(t, err, "Request should not fail")
+	cookie := responseCookie(resp, "auth_token")
+	require.NotNil(t, cookie, "Session cookie should be set")
+	assert.True(t, cookie.HttpOnly, "Session cookie should be HttpOnly")
+	assert.True(t, cookie.Secure, "Session cookie should be Secure")
+	assert.Equal(t, http.SameSiteLaxMode, cookie.SameSite, "Session cookie should be SameSite=Lax")
+	assert.Equal(t, "/", cookie.Path, "Session cookie should cover the whole site")
+	assert.Equal(t, 3600, cookie.MaxAge, "Session cookie should expire with the token")
+
+	act, err := ParseToken(km, cookie.Value)
+	require.NoError(t, err, "Session cookie should hold a valid token")
+	assert.Equal(t, uint64(1), act.ID, "Token should hold the account")
+
+	resp, err = app.Test(httptest.NewRequest("POST", "/logout", nil))
+	require.NoError(t, err, "Request should not fail")
+	cookie = responseCookie(resp, "auth_token")
+	require.NotNil(t, cookie, "Session cookie should be cleared")
+	assert.Empty(t, cookie.Value, "Cleared cookie should be empty")
+	assert.True(t, cookie.MaxAge < 0 || cookie.Expires.Before(time.Now()), "Cleared cookie should be expired")
+}
+
+func TestSessionRefreshMiddleware(t *testing.T) {
+	km := setupKeyManager(t, 24*time.Hour)
+	defer km.Shutdown()
+
+	opts := SessionOptions{Expiration: time.Hour, RefreshBefore: 15 * time.Minute}
+	app := fiber.New()
+	app.Get("/", func(c *fiber.Ctx) error {
+		token := c.Cookies("auth_token")
+		act, err := ParseToken(km, token)
+		if err != nil {
+			return c.SendStatus(fiber.StatusUnauthorized)
+		}
+		SetAccount(c, act, token)
+		return c.Next()
+	}, SessionRefreshMiddleware(km, opts), func(c *fiber.Ctx) error {
+		return c.SendString(Token(c))
+	})
+	request := func(token string) *http.Response {
+		req := httptest.NewRequest("GET", "/", nil)
+		req.AddCookie(&http.Cookie{Name: "auth_token", Value: token})
+		resp, err := app.Test(req)
+		require.NoError(t, err, "Request should not fail")
+		require.Equal(t, fiber.StatusOK, resp.StatusCode, "Session should be accepted")
+		return resp
+	}
+
+	fresh, err := GenerateToken(km, &AuthTokenData{ID: 1}, time.Hour)
+	require.NoError(t, err, "GenerateToken should not return an error")
+	assert.Nil(t, responseCookie(request(*fresh), "auth_token"), "Fresh session should not be refreshed")
+
+	expiring, err := GenerateToken(km, &AuthTokenData{ID: 1}, 5*time.Minute)
+	require.NoError(t, err, "GenerateToken should not return an error")
+	cookie := responseCookie(request(*expiring), "auth_token")
+	require.NotNil(t, cookie, "Expiring session should be refreshed")
+	assert.NotEqual(t, *expiring, cookie.Value, "Refreshed session should hold a new token")
+
+	act, err := ParseToken(km, cookie.Value)
+	require.NoError(t, err, "Refreshed token should be valid")
+	assert.Equal(t, uint64(1), act.ID, "Refreshed token should hold the account")
+	expiresAt, ok := unverifiedExpiry(cookie.Value)
+	require.True(t, ok, "Refreshed token should expire")
+	assert.WithinDuration(t, time.Now().Add(time.Hour), expiresAt, 5*time.Second, "Refreshed token should have a full lifetime")
+}
+
+func TestCSRFMiddleware(t *testing.T) {
+	app := fiber.New()
+	app.Use(CSRFMiddleware())
+	app.Get("/", func(c *fiber.Ctx) error {
+		return c.SendString(CSRFToken(c))
+	})
+	app.Post("/", func(c *fiber.Ctx) error {
+		return c.SendStatus(fiber.StatusOK)
+	})
+
+	resp, err := app.Test(httptest.NewRequest("GET", "/", nil))
+	require.NoError(t, err, "Request should not fail")
+	csrfCookie := responseCookie(resp, "csrf_token")
+	require.NotNil(t, csrfCookie, "CSRF cookie should be set")
+	assert.False(t, csrfCookie.HttpOnly, "CSRF cookie should be readable by scripts")
+	assert.Len(t, csrfCookie.Value, 64, "CSRF token should be random")
+
+	post := func(header string, form string, cookies ...*http.Cookie) int {
+		var body *strings.Reader
+		if form != "" {
+			body = strings.NewReader(url.Values{"csrf_token": {form}}.Encode())
+		} else {
+			body = strings.NewReader("")
+		}
+		req := httptest.NewRequest("POST", "/", body)
+		if form != "" {
+			req.Header.Set("Content-Type", fiber.MIMEApplicationForm)
+		}
+		if header != "" {
+			req.Header.Set("X-CSRF-Token", header)
+		}
+		for _, cookie := range cookies {
+			req.AddCookie(cookie)
+		}
+		resp, err := app.Test(req)
+		require.NoError(t, err, "Request should not fail")
+		return resp.StatusCode
+	}
+	session := &http.Cookie{Name: "auth_token", Value: "token"}
+
+	assert.Equal(t, fiber.StatusForbidden, post("", "", session, csrfCookie), "Session requests without token should be rejected")
+	assert.Equal(t, fiber.StatusForbidden, post("forged", "", session, csrfCookie), "Session requests with another token should be rejected")
+	assert.Equal(t, fiber.StatusForbidden, post(csrfCookie.Value, "", session), "Session requests without CSRF cookie should be rejected")
+	assert.Equal(t, fiber.StatusOK, post(csrfCookie.Value, "", session, csrfCookie), "Header token should be accepted")
+	assert.Equal(t, fiber.StatusOK, post("", csrfCookie.Value, session, csrfCookie), "Form token should be accepted")
+	assert.Equal(t, fiber.StatusOK, post("", ""), "Requests without session cookie should not be checked")
+
+	req := httptest.NewRequest("POST", "/", nil)
+	req.Header.Set("Authorization", "Bearer token")
+	req.AddCookie(session)
+	resp, err = app.Test(req)
+	require.NoError(t, err, "Request should not fail")
+	assert.Equal(t, fiber.StatusOK, resp.StatusCode, "Bearer requests should not be checked")
+
+	req = httptest.NewRequest("POST", "/", nil)
+	req.Header.Set("Authorization", "Basic dXNlcjpwYXNz")
+	req.AddCookie(session)
+	resp, err = app.Test(req)
+	require.NoError(t, err, "Request should not fail")
+	assert.Equal(t, fiber.StatusForbidden, resp.StatusCode, "Session requests with another Authorization scheme should be checked")
+}
+
+func TestRemoteSessionMiddleware(t *testing.T) {
+	mockAuthAPI(t)
+
+	app := fiber.New()
+	app.Use(CSRFMiddleware(), RemoteSessionMiddleware())
+	app.Get("/", func(c *fiber.Ctx) error {
+		return c.JSON(MustAccount(c))
+	})
+
+	resp, err := app.Test(httptest.NewRequest("GET", "/", nil))
+	require.NoError(t, err, "Request should not fail")
+	assert.Equal(t, fiber.StatusUnauthorized, resp.StatusCode, "Requests without session should be rejected")
+
+	req := httptest.NewRequest("GET", "/", nil)
+	req.AddCookie(&http.Cookie{Name: "auth_token", Value: "abcdefgh.secret"})
+	resp, err = app.Test(req)
+	require.NoError(t, err, "Request should not fail")
+	assert.Equal(t, fiber.StatusOK, resp.StatusCode, "Session cookie should authenticate the request")
+}
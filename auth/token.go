@@ -1,77 +1,150 @@
 package auth
 
 import (
+	"fmt"
 	"strings"
+	"sync"
 
 	"github.com/gofiber/fiber/v2"
 )
 
-func ExtractToken(ctx *fiber.Ctx, tokenLookups ...string) string {
+// TokenExtractor returns the token of a request, or an empty string.
+type TokenExtractor func(c *fiber.Ctx) string
+
+// TokenExtractorFactory builds the extractor of a lookup source from the rest
+// of the lookup, e.g. "Authorization:ApiKey" for "header:Authorization:ApiKey".
+type TokenExtractorFactory func(arg string) (TokenExtractor, error)
+
+var (
+	tokenExtractorsMu sync.RWMutex
+	tokenExtractors   = map[string]TokenExtractorFactory{
+		"header":    headerExtractor,
+		"rawheader": namedExtractor("rawheader", func(c *fiber.Ctx, name string) string { return c.Get(name) }),
+		"query":     namedExtractor("query", func(c *fiber.Ctx, name string) string { return c.Query(name) }),
+		"param":     namedExtractor("param", func(c *fiber.Ctx, name string) string { return c.Params(name) }),
+		"cookie":    namedExtractor("cookie", func(c *fiber.Ctx, name string) string { return c.Cookies(name) }),
+		"form":      namedExtractor("form", func(c *fiber.Ctx, name string) string { return c.FormValue(name) }),
+	}
+)
+
+// RegisterTokenExtractor makes source usable in token lookups, it replaces
+// the extractor of an existing source. Register sources before creating the
+// middlewares using them, lookups are compiled when a middleware is created.
+func RegisterTokenExtractor(source string, factory TokenExtractorFactory) {
+	tokenExtractorsMu.Lock()
+	defer tokenExtractorsMu.Unlock()
+
+	tokenExtractors[source] = factory
+}
+
+// CompileTokenLookup compiles comma separated "source:arg" lookups into an
+// extractor returning the first token found. Built-in sources are:
+//   - header:Name[:Scheme] reads the token following the auth scheme of a
+//     header, Scheme defaults to Bearer
+//   - rawheader:Name reads the whole value of a header without auth scheme,
+//     like X-API-Key
+//   - query:name, param:name, cookie:name and form:name
+//
+// Without lookups only the Authorization header is read.
+func CompileTokenLookup(tokenLookups ...string) (TokenExtractor, error) {
 	if len(tokenLookups) == 0 {
 		// default, only header
 		tokenLookups = []string{"header:Authorization"}
 	}
 
+	tokenExtractorsMu.RLock()
+	defer tokenExtractorsMu.RUnlock()
+
+	extractors := make([]TokenExtractor, 0)
 	for _, tokenLookup := range tokenLookups {
-		// Initialize
-		extractors := make([]func(c *fiber.Ctx) string, 0)
-		rootParts := strings.Split(tokenLookup, ",")
-
-		for _, rootPart := range rootParts {
-			parts := strings.Split(strings.TrimSpace(rootPart), ":")
-
-			switch parts[0] {
-			case "header":
-				extractors = append(extractors, jwtFromHeader(parts[1], "Bearer"))
-			case "query":
-				extractors = append(extractors, jwtFromQuery(parts[1]))
-			case "param":
-				extractors = append(extractors, jwtFromParam(parts[1]))
-			case "cookie":
-				extractors = append(extractors, jwtFromCookie(parts[1]))
+		for _, rootPart := range strings.Split(tokenLookup, ",") {
+			rootPart = strings.TrimSpace(rootPart)
+			source, arg, ok := strings.Cut(rootPart, ":")
+			if !ok {
+				return nil, fmt.Errorf("invalid token lookup %q: expected source:name", rootPart)
 			}
+
+			factory, ok := tokenExtractors[source]
+			if !ok {
+				return nil, fmt.Errorf("invalid token lookup %q: unknown source %q", rootPart, source)
+			}
+
+			extractor, err := factory(arg)
+			if err != nil {
+				return nil, fmt.Errorf("invalid token lookup %q: %w", rootPart, err)
+			}
+			extractors = append(extractors, extractor)
 		}
+	}
 
+	return func(c *fiber.Ctx) string {
 		for _, extractor := range extractors {
-			token := extractor(ctx)
-			if token != "" {
+			if token := extractor(c); token != "" {
 				return token
 			}
 		}
-	}
+		return ""
+	}, nil
+}
 
-	return ""
+// MustCompileTokenLookup is CompileTokenLookup panicking on malformed lookups,
+// used by middlewares so they are rejected at startup.
+func MustCompileTokenLookup(tokenLookups ...string) TokenExtractor {
+	extractor, err := CompileTokenLookup(tokenLookups...)
+	if err != nil {
+		panic(err)
+	}
+	return extractor
 }
 
-// jwtFromHeader returns a function that extracts token from the request header.
-func jwtFromHeader(header string, authScheme string) func(c *fiber.Ctx) string {
-	return func(c *fiber.Ctx) string {
-		auth := c.Get(header)
-		l := len(authScheme)
-		if len(auth) > l+1 && strings.EqualFold(auth[:l], authScheme) {
-			return auth[l+1:]
-		}
+// ExtractToken returns the first token found by tokenLookups, see
+// CompileTokenLookup. The lookups are compiled on every call, middlewares
+// compile them once with MustCompileTokenLookup. Malformed lookups never match.
+func ExtractToken(ctx *fiber.Ctx, tokenLookups ...string) string {
+	extractor, err := CompileTokenLookup(tokenLookups...)
+	if err != nil {
 		return ""
 	}
+
+	return extractor(ctx)
 }
 
-// jwtFromQuery returns a function that extracts token from the query string.
-func jwtFromQuery(param string) func(c *fiber.Ctx) string {
-	return func(c *fiber.Ctx) string {
-		return c.Query(param)
+// headerExtractor reads the token following the auth scheme of a header.
+func headerExtractor(arg string) (TokenExtractor, error) {
+	header, authScheme, hasScheme := strings.Cut(arg, ":")
+	if header == "" {
+		return nil, fmt.Errorf("missing header name")
+	}
+	if hasScheme && authScheme == "" {
+		return nil, fmt.Errorf("missing auth scheme")
 	}
+	if !hasScheme {
+		authScheme = "Bearer"
+	}
+
+	return jwtFromHeader(header, authScheme), nil
 }
 
-// jwtFromParam returns a function that extracts token from the url param string.
-func jwtFromParam(param string) func(c *fiber.Ctx) string {
-	return func(c *fiber.Ctx) string {
-		return c.Params(param)
+// namedExtractor builds the factory of sources only taking a name.
+func namedExtractor(source string, extract func(c *fiber.Ctx, name string) string) TokenExtractorFactory {
+	return func(name string) (TokenExtractor, error) {
+		if name == "" || strings.Contains(name, ":") {
+			return nil, fmt.Errorf("invalid %s name %q", source, name)
+		}
+		return func(c *fiber.Ctx) string {
+			return extract(c, name)
+		}, nil
 	}
 }
 
-// jwtFromCookie returns a function that extracts token from the named cookie.
-func jwtFromCookie(name string) func(c *fiber.Ctx) string {
+// jwtFromHeader returns a function that extracts token from the request header.
+func jwtFromHeader(header string, authScheme string) TokenExtractor {
 	return func(c *fiber.Ctx) string {
-		return c.Cookies(name)
+		auth := c.Get(header)
+		l := len(authScheme)
+		if len(auth) > l+1 && strings.EqualFold(auth[:l], authScheme) && auth[l] == ' ' {
+			return strings.TrimSpace(auth[l+1:])
+		}
+		return ""
 	}
 }
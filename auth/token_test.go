@@ -0,0 +1,131 @@
+package auth
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// extract runs the extractor compiled from tokenLookups on req.
+func extract(t *testing.T, req *http.Request, tokenLookups ...string) string {
+	extractor, err := CompileTokenLookup(tokenLookups...)
+	require.NoError(t, err, "CompileTokenLookup should not return an error")
+
+	var token string
+	app := fiber.New()
+	app.All("/:id", func(c *fiber.Ctx) error {
+		token = extractor(c)
+		return nil
+	})
+	_, err = app.Test(req)
+	require.NoError(t, err, "Request should not fail")
+	return token
+}
+
+func TestCompileTokenLookup(t *testing.T) {
+	req := httptest.NewRequest("GET", "/param-token", nil)
+	req.Header.Set("Authorization", "Bearer bearer-token")
+	assert.Equal(t, "bearer-token", extract(t, req), "Authorization header should be read by default")
+	assert.Equal(t, "", extract(t, req, "header:Authorization:ApiKey"), "Other schemes should not match")
+
+	req = httptest.NewRequest("GET", "/param-token", nil)
+	req.Header.Set("Authorization", "ApiKey abcdefgh.secret")
+	assert.Equal(t, "abcdefgh.secret", extract(t, req, "header:Authorization:Bearer,header:Authorization:ApiKey"), "Custom schemes should be read")
+
+	req = httptest.NewRequest("GET", "/param-token", nil)
+	req.Header.Set("Authorization", "Tokenized value")
+	assert.Equal(t, "", extract(t, req, "header:Authorization:Token"), "Scheme should be followed by a space")
+
+	req = httptest.NewRequest("GET", "/param-token", nil)
+	req.Header.Set("X-API-Key", "raw-key")
+	assert.Equal(t, "raw-key", extract(t, req, "rawheader:X-API-Key"), "Raw headers should be read as is")
+
+	req = httptest.NewRequest("GET", "/param-token", nil)
+	req.Header.Set("X-API-Key", "Bearer custom-key")
+	assert.Equal(t, "custom-key", extract(t, req, "header:X-API-Key"), "Custom headers should default to the Bearer scheme")
+
+	req = httptest.NewRequest("GET", "/param-token?auth_token=query-token", nil)
+	req.AddCookie(&http.Cookie{Name: "session", Value: "cookie-token"})
+	assert.Equal(t, "query-token", extract(t, req, "header:Authorization,query:auth_token,cookie:session"), "First token found should be returned")
+	assert.Equal(t, "cookie-token", extract(t, req, "cookie:session", "query:auth_token"), "Lookups should be tried in order")
+	assert.Equal(t, "param-token", extract(t, req, "param:id"), "Route params should be read")
+
+	form := url.Values{"token": {"form-token"}}
+	req = httptest.NewRequest("POST", "/param-token", strings.NewReader(form.Encode()))
+	req.Header.Set("Content-Type", fiber.MIMEApplicationForm)
+	assert.Equal(t, "form-token", extract(t, req, "form:token"), "Form fields should be read")
+}
+
+func TestCompileTokenLookup_Malformed(t *testing.T) {
+	for _, lookup := range []string{
+		"header",
+		"header:",
+		"header:Authorization:",
+		"query:",
+		"cookie:a:b",
+		"rawheader:",
+		"body:token",
+		"header:Authorization,,query:auth_token",
+	} {
+		_, err := CompileTokenLookup(lookup)
+		assert.Error(t, err, "Lookup %q should be rejected", lookup)
+	}
+
+	assert.Panics(t, func() { RemoteMiddlewareWithLookup("header") }, "Middlewares should reject malformed lookups at startup")
+	assert.Panics(t, func() { APIKeyMiddleware(nil, "query") }, "Middlewares should reject malformed lookups at startup")
+
+	app := fiber.New()
+	app.Get("/", func(c *fiber.Ctx) error {
+		return c.SendString(ExtractToken(c, "header"))
+	})
+	resp, err := app.Test(httptest.NewRequest("GET", "/", nil))
+	require.NoError(t, err, "Request should not fail")
+	assert.Equal(t, fiber.StatusOK, resp.StatusCode, "ExtractToken should not panic on malformed lookups")
+}
+
+func TestRegisterTokenExtractor(t *testing.T) {
+	var token string
+	app := fiber.New()
+	app.Get("/", func(c *fiber.Ctx) error {
+		c.Locals("token", "locals-token")
+		token = ExtractToken(c, "locals:token")
+		return nil
+	})
+	_, err := app.Test(httptest.NewRequest("GET", "/", nil))
+	require.NoError(t, err, "Request should not fail")
+	assert.Equal(t, "", token, "Unknown sources should not match")
+
+	RegisterTokenExtractor("locals", func(name string) (TokenExtractor, error) {
+		return func(c *fiber.Ctx) string {
+			token, _ := c.Locals(name).(string)
+			return token
+		}, nil
+	})
+	t.Cleanup(func() {
+		tokenExtractorsMu.Lock()
+		delete(tokenExtractors, "locals")
+		tokenExtractorsMu.Unlock()
+	})
+
+	_, err = app.Test(httptest.NewRequest("GET", "/", nil))
+	require.NoError(t, err, "Request should not fail")
+	assert.Equal(t, "locals-token", token, "ExtractToken should use extractors registered after earlier calls")
+
+	extractor := MustCompileTokenLookup("header:Authorization,locals:token")
+
+	app = fiber.New()
+	app.Get("/", func(c *fiber.Ctx) error {
+		c.Locals("token", "locals-token")
+		token = extractor(c)
+		return nil
+	})
+	_, err = app.Test(httptest.NewRequest("GET", "/", nil))
+	require.NoError(t, err, "Request should not fail")
+	assert.Equal(t, "locals-token", token, "Registered extractors should be used")
+}
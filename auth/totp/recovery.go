@@ -0,0 +1,114 @@
+package totp
+
+import (
+	"crypto/rand"
+	"encoding/base32"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/arqut/common/auth/password"
+	"github.com/arqut/common/system"
+)
+
+// recoveryCodeLength is the number of base32 characters of a recovery code,
+// 50 bits of entropy.
+const recoveryCodeLength = 10
+
+var recoveryEncoding = base32.NewEncoding("abcdefghijklmnopqrstuvwxyz234567").WithPadding(base32.NoPadding)
+
+// GenerateRecoveryCodes returns count one-time codes to show once to the user
+// as "xxxxx-xxxxx", and their hashes to store with the account. The codes are
+// hashed by password.Hash with params.
+func GenerateRecoveryCodes(count int, params ...password.Params) (codes []string, hashes []string, err error) {
+	codes = make([]string, 0, count)
+	hashes = make([]string, 0, count)
+	for i := 0; i < count; i++ {
+		raw := make([]byte, 7)
+		if _, err := rand.Read(raw); err != nil {
+			return nil, nil, fmt.Errorf("failed to generate recovery code: %w", err)
+		}
+		code := recoveryEncoding.EncodeToString(raw)[:recoveryCodeLength]
+		hash, err := HashRecoveryCode(code, params...)
+		if err != nil {
+			return nil, nil, err
+		}
+		codes = append(codes, code[:recoveryCodeLength/2]+"-"+code[recoveryCodeLength/2:])
+		hashes = append(hashes, hash)
+	}
+	return codes, hashes, nil
+}
+
+// HashRecoveryCode returns the hash stored for code, a salted password hash
+// so leaked hashes cannot be brute forced offline.
+func HashRecoveryCode(code string, params ...password.Params) (string, error) {
+	return password.Hash(normalizeRecoveryCode(code), params...)
+}
+
+// UseRecoveryCode checks code against the stored hashes of subject, the
+// account they belong to, and returns the hashes left once it is consumed.
+// It returns ErrInvalidRecoveryCode when code matches none of them. Wrong
+// codes count toward options Lockout like the ones sent to Verify, once
+// locked out codes are rejected with password.ErrTooManyAttempts before any
+// hashing.
+//
+// Consuming a code is not atomic: store remaining in place of hashes with a
+// compare-and-swap on the stored hashes, otherwise concurrent requests can
+// both use the same code.
+func UseRecoveryCode(subject string, code string, hashes []string, options ...Options) ([]string, error) {
+	opts, err := newOptions(options...)
+	if err != nil {
+		return hashes, err
+	}
+
+	locked, err := opts.Lockout.Locked(subject)
+	if err != nil {
+		return hashes, fmt.Errorf("failed to read failed attempts: %w", err)
+	}
+	if locked {
+		return hashes, password.ErrTooManyAttempts
+	}
+
+	index, err := matchRecoveryCode(normalizeRecoveryCode(code), hashes)
+	if errors.Is(err, ErrInvalidRecoveryCode) {
+		if _, cacheErr := opts.Lockout.Fail(subject); cacheErr != nil {
+			return hashes, fmt.Errorf("failed to record failed attempt: %w", cacheErr)
+		}
+		return hashes, err
+	}
+	if err != nil {
+		return hashes, err
+	}
+
+	if err := opts.Lockout.Reset(subject); err != nil {
+		system.Logger.Errorf("Error resetting TOTP failures: %v", err)
+	}
+
+	remaining := make([]string, 0, len(hashes)-1)
+	remaining = append(remaining, hashes[:index]...)
+	return append(remaining, hashes[index+1:]...), nil
+}
+
+// matchRecoveryCode returns the index of the hash of code.
+func matchRecoveryCode(code string, hashes []string) (int, error) {
+	if len(code) != recoveryCodeLength {
+		return -1, ErrInvalidRecoveryCode
+	}
+
+	for i, hash := range hashes {
+		_, err := password.Verify(code, hash)
+		if err == nil {
+			return i, nil
+		}
+		if !errors.Is(err, password.ErrMismatchedPassword) {
+			return -1, fmt.Errorf("failed to verify recovery code: %w", err)
+		}
+	}
+
+	return -1, ErrInvalidRecoveryCode
+}
+
+// normalizeRecoveryCode drops the separators and case users may type.
+func normalizeRecoveryCode(code string) string {
+	return strings.ToLower(strings.NewReplacer("-", "", " ", "").Replace(code))
+}
@@ -0,0 +1,93 @@
+package totp
+
+import (
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/arqut/common/auth/password"
+	"github.com/arqut/common/internal/redistest"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// testParams keeps argon2id cheap in tests.
+var testParams = password.Params{Memory: 1024, Iterations: 1, Parallelism: 1}
+
+func TestRecoveryCodes(t *testing.T) {
+	redistest.Start(t)
+
+	codes, hashes, err := GenerateRecoveryCodes(10, testParams)
+	require.NoError(t, err, "GenerateRecoveryCodes should not return an error")
+	require.Len(t, codes, 10, "Every code should be returned")
+	require.Len(t, hashes, 10, "Every hash should be returned")
+
+	seen := make(map[string]bool)
+	for i, code := range codes {
+		assert.Regexp(t, regexp.MustCompile(`^[a-z2-7]{5}-[a-z2-7]{5}$`), code, "Code should be readable")
+		assert.NotContains(t, hashes[i], strings.ReplaceAll(code, "-", ""), "Hash should not hold the code")
+		assert.True(t, strings.HasPrefix(hashes[i], "$argon2id$"), "Codes should be hashed with the password hasher")
+		assert.False(t, seen[code], "Codes should be unique")
+		seen[code] = true
+	}
+
+	remaining, err := UseRecoveryCode("account-1", strings.ToUpper(strings.ReplaceAll(codes[3], "-", " ")), hashes)
+	require.NoError(t, err, "Code should be accepted regardless of case and separators")
+	assert.Len(t, remaining, 9, "Used code should be consumed")
+	assert.NotContains(t, remaining, hashes[3], "Used hash should be dropped")
+
+	_, err = UseRecoveryCode("account-1", codes[3], remaining)
+	assert.ErrorIs(t, err, ErrInvalidRecoveryCode, "Used code should be rejected")
+	_, err = UseRecoveryCode("account-1", "aaaaa-aaaaa", remaining)
+	assert.ErrorIs(t, err, ErrInvalidRecoveryCode, "Unknown code should be rejected")
+}
+
+func TestHashRecoveryCode_Salted(t *testing.T) {
+	redistest.Start(t)
+
+	first, err := HashRecoveryCode("abcde-fghij", testParams)
+	require.NoError(t, err, "HashRecoveryCode should not return an error")
+	second, err := HashRecoveryCode("abcde-fghij", testParams)
+	require.NoError(t, err, "HashRecoveryCode should not return an error")
+	assert.NotEqual(t, first, second, "Hashes should be salted")
+
+	_, err = UseRecoveryCode("account-1", "abcde-fghij", []string{"not a hash"})
+	assert.ErrorIs(t, err, password.ErrUnsupportedHash, "Malformed hashes should be reported")
+}
+
+func TestUseRecoveryCode_Lockout(t *testing.T) {
+	redistest.Start(t)
+
+	codes, hashes, err := GenerateRecoveryCodes(2, testParams)
+	require.NoError(t, err, "GenerateRecoveryCodes should not return an error")
+	opts := Options{Lockout: password.Lockout{MaxAttempts: 3, Duration: time.Minute}}
+
+	for i := 0; i < 3; i++ {
+		_, err = UseRecoveryCode("account-1", "aaaaa-aaaaa", hashes, opts)
+		assert.ErrorIs(t, err, ErrInvalidRecoveryCode, "Wrong codes should be rejected")
+	}
+	remaining, err := UseRecoveryCode("account-1", codes[0], hashes, opts)
+	assert.ErrorIs(t, err, password.ErrTooManyAttempts, "Valid codes should be rejected once locked out")
+	assert.Equal(t, hashes, remaining, "Rejected codes should not be consumed")
+	_, err = UseRecoveryCode("account-1", codes[0], []string{"not a hash"}, opts)
+	assert.ErrorIs(t, err, password.ErrTooManyAttempts, "Locked out subjects should be rejected before hashing")
+
+	secret, err := GenerateSecret()
+	require.NoError(t, err, "GenerateSecret should not return an error")
+	code, err := GenerateCode(secret, time.Now())
+	require.NoError(t, err, "GenerateCode should not return an error")
+	assert.ErrorIs(t, Verify("account-1", secret, code, opts), password.ErrTooManyAttempts, "Recovery code guesses should lock TOTP codes too")
+
+	_, err = UseRecoveryCode("account-2", "aaaaa-aaaaa", hashes, opts)
+	assert.ErrorIs(t, err, ErrInvalidRecoveryCode, "Wrong codes should be rejected")
+	remaining, err = UseRecoveryCode("account-2", codes[1], hashes, opts)
+	require.NoError(t, err, "Valid codes should be accepted before the lockout")
+	assert.Len(t, remaining, 1, "Used code should be consumed")
+	for i := 0; i < 2; i++ {
+		_, err = UseRecoveryCode("account-2", "aaaaa-aaaaa", remaining, opts)
+		assert.ErrorIs(t, err, ErrInvalidRecoveryCode, "Wrong codes should be rejected")
+	}
+	_, err = UseRecoveryCode("account-2", codes[0], remaining, opts)
+	assert.NoError(t, err, "Accepted codes should reset the failures")
+}
@@ -0,0 +1,281 @@
+// Package totp implements RFC 6238 time-based one-time passwords and one-time
+// recovery codes for two-factor authentication.
+package totp
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/sha512"
+	"crypto/subtle"
+	"encoding/base32"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/arqut/common/auth/password"
+	"github.com/arqut/common/cache"
+	"github.com/arqut/common/system"
+)
+
+// Supported HMAC algorithms.
+const (
+	SHA1   = "SHA1"
+	SHA256 = "SHA256"
+	SHA512 = "SHA512"
+)
+
+// secretLength is the size of generated secrets, the RFC 4226 recommended
+// 160 bits.
+const secretLength = 20
+
+// Errors returned by the totp package, use errors.Is to tell them apart.
+var (
+	ErrInvalidCode         = errors.New("invalid one-time code")
+	ErrCodeReused          = errors.New("one-time code already used")
+	ErrInvalidSecret       = errors.New("invalid TOTP secret")
+	ErrInvalidRecoveryCode = errors.New("invalid recovery code")
+	ErrInvalidOptions      = errors.New("invalid TOTP options")
+)
+
+// Options configures the codes, authenticator apps only reliably support the
+// defaults.
+type Options struct {
+	// Issuer shown by authenticator apps, defaults to APP_NAME.
+	Issuer string
+	// Digits of the codes, 6 to 8, defaults to 6.
+	Digits int
+	// Period of a code in whole seconds, defaults to 30 seconds.
+	Period time.Duration
+	// Skew is the number of periods accepted before and after the current
+	// one to allow for clock drift, defaults to 1. Use -1 to disable it.
+	Skew int
+	// Algorithm is SHA1, SHA256 or SHA512, defaults to SHA1.
+	Algorithm string
+	// Prefix namespaces the used codes in the cache, defaults to "auth:totp:".
+	Prefix string
+	// Lockout limits the wrong codes Verify and UseRecoveryCode accept per
+	// subject, both count toward the same lock. Its Prefix defaults to Prefix
+	// followed by "attempts:".
+	Lockout password.Lockout
+}
+
+func newOptions(options ...Options) (Options, error) {
+	var opts Options
+	if len(options) > 0 {
+		opts = options[0]
+	}
+	if opts.Issuer == "" {
+		opts.Issuer = system.Env("APP_NAME")
+	}
+	if opts.Digits == 0 {
+		opts.Digits = 6
+	}
+	if opts.Digits < 6 || opts.Digits > 8 {
+		return Options{}, fmt.Errorf("%w: digits must be between 6 and 8, got %d", ErrInvalidOptions, opts.Digits)
+	}
+	if opts.Period == 0 {
+		opts.Period = 30 * time.Second
+	}
+	if opts.Period < time.Second || opts.Period%time.Second != 0 {
+		return Options{}, fmt.Errorf("%w: period must be whole seconds, got %s", ErrInvalidOptions, opts.Period)
+	}
+	if opts.Skew == 0 {
+		opts.Skew = 1
+	} else if opts.Skew < 0 {
+		opts.Skew = 0
+	}
+	if opts.Algorithm == "" {
+		opts.Algorithm = SHA1
+	}
+	if opts.Prefix == "" {
+		opts.Prefix = "auth:totp:"
+	}
+	if opts.Lockout.Prefix == "" {
+		opts.Lockout.Prefix = opts.Prefix + "attempts:"
+	}
+	return opts, nil
+}
+
+func (o Options) hash() (func() hash.Hash, error) {
+	switch strings.ToUpper(o.Algorithm) {
+	case SHA1:
+		return sha1.New, nil
+	case SHA256:
+		return sha256.New, nil
+	case SHA512:
+		return sha512.New, nil
+	}
+	return nil, fmt.Errorf("unsupported TOTP algorithm %q", o.Algorithm)
+}
+
+// GenerateSecret returns a random base32 secret to store with the account and
+// share with its authenticator app.
+func GenerateSecret() (string, error) {
+	secret := make([]byte, secretLength)
+	if _, err := rand.Read(secret); err != nil {
+		return "", fmt.Errorf("failed to generate secret: %w", err)
+	}
+	return base32.StdEncoding.WithPadding(base32.NoPadding).EncodeToString(secret), nil
+}
+
+// URI returns the otpauth:// provisioning URI of secret, usually shown as a
+// QR code. account identifies the user in the app, like its email.
+func URI(secret string, account string, options ...Options) (string, error) {
+	opts, err := newOptions(options...)
+	if err != nil {
+		return "", err
+	}
+
+	label := url.PathEscape(account)
+	query := url.Values{}
+	query.Set("secret", secret)
+	if opts.Issuer != "" {
+		label = url.PathEscape(opts.Issuer) + ":" + label
+		query.Set("issuer", opts.Issuer)
+	}
+	query.Set("algorithm", strings.ToUpper(opts.Algorithm))
+	query.Set("digits", strconv.Itoa(opts.Digits))
+	query.Set("period", strconv.Itoa(int(opts.Period.Seconds())))
+
+	return "otpauth://totp/" + label + "?" + query.Encode(), nil
+}
+
+// GenerateCode returns the code of secret at t.
+func GenerateCode(secret string, t time.Time, options ...Options) (string, error) {
+	opts, err := newOptions(options...)
+	if err != nil {
+		return "", err
+	}
+	return opts.code(secret, opts.step(t))
+}
+
+// Validate checks code against secret at the current time, accepting Skew
+// periods of drift. It does not protect against replays nor guessing, see
+// Verify.
+func Validate(secret string, code string, options ...Options) error {
+	opts, err := newOptions(options...)
+	if err != nil {
+		return err
+	}
+	_, err = match(secret, code, opts)
+	return err
+}
+
+// Verify is Validate also rejecting codes already accepted for subject, the
+// account the secret belongs to, with ErrCodeReused. Used codes are recorded
+// in the cache package until they expire. Once subject sent Lockout.MaxAttempts
+// wrong codes every code is rejected with password.ErrTooManyAttempts until
+// the lock expires. Like password.Authenticator it fails closed, codes are
+// rejected when the cache fails.
+func Verify(subject string, secret string, code string, options ...Options) error {
+	opts, err := newOptions(options...)
+	if err != nil {
+		return err
+	}
+
+	locked, err := opts.Lockout.Locked(subject)
+	if err != nil {
+		return fmt.Errorf("failed to read failed attempts: %w", err)
+	}
+	if locked {
+		return password.ErrTooManyAttempts
+	}
+
+	step, err := match(secret, code, opts)
+	if errors.Is(err, ErrInvalidCode) {
+		if _, cacheErr := opts.Lockout.Fail(subject); cacheErr != nil {
+			return fmt.Errorf("failed to record failed attempt: %w", cacheErr)
+		}
+		return err
+	}
+	if err != nil {
+		return err
+	}
+
+	sum := sha256.Sum256([]byte(subject))
+	key := fmt.Sprintf("%s%s:%d", opts.Prefix, hex.EncodeToString(sum[:]), step)
+	ttl := time.Duration(2*opts.Skew+1) * opts.Period
+	fresh, err := cache.SetNX(key, "1", ttl)
+	if err != nil {
+		return fmt.Errorf("failed to record used code: %w", err)
+	}
+	if !fresh {
+		return ErrCodeReused
+	}
+
+	if err := opts.Lockout.Reset(subject); err != nil {
+		system.Logger.Errorf("Error resetting TOTP failures: %v", err)
+	}
+
+	return nil
+}
+
+// match returns the time step code was generated for.
+func match(secret string, code string, opts Options) (uint64, error) {
+	code = strings.ReplaceAll(code, " ", "")
+	if len(code) != opts.Digits {
+		return 0, ErrInvalidCode
+	}
+
+	current := opts.step(time.Now())
+	for offset := -opts.Skew; offset <= opts.Skew; offset++ {
+		step := uint64(int64(current) + int64(offset))
+		expected, err := opts.code(secret, step)
+		if err != nil {
+			return 0, err
+		}
+		if subtle.ConstantTimeCompare([]byte(expected), []byte(code)) == 1 {
+			return step, nil
+		}
+	}
+
+	return 0, ErrInvalidCode
+}
+
+func (o Options) step(t time.Time) uint64 {
+	return uint64(t.Unix() / int64(o.Period.Seconds()))
+}
+
+// code is the RFC 4226 HOTP value of step.
+func (o Options) code(secret string, step uint64) (string, error) {
+	key, err := decodeSecret(secret)
+	if err != nil {
+		return "", err
+	}
+	newHash, err := o.hash()
+	if err != nil {
+		return "", err
+	}
+
+	var counter [8]byte
+	binary.BigEndian.PutUint64(counter[:], step)
+	mac := hmac.New(newHash, key)
+	mac.Write(counter[:])
+	sum := mac.Sum(nil)
+
+	offset := sum[len(sum)-1] & 0x0f
+	value := binary.BigEndian.Uint32(sum[offset:offset+4]) & 0x7fffffff
+
+	modulo := uint32(1)
+	for i := 0; i < o.Digits; i++ {
+		modulo *= 10
+	}
+	return fmt.Sprintf("%0*d", o.Digits, value%modulo), nil
+}
+
+func decodeSecret(secret string) ([]byte, error) {
+	secret = strings.ToUpper(strings.ReplaceAll(secret, " ", ""))
+	key, err := base32.StdEncoding.WithPadding(base32.NoPadding).DecodeString(strings.TrimRight(secret, "="))
+	if err != nil || len(key) == 0 {
+		return nil, ErrInvalidSecret
+	}
+	return key, nil
+}
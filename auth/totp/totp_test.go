@@ -0,0 +1,157 @@
+package totp
+
+import (
+	"encoding/base32"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/arqut/common/auth/password"
+	"github.com/arqut/common/internal/redistest"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func rfcSecret(seed string) string {
+	return base32.StdEncoding.EncodeToString([]byte(seed))
+}
+
+func TestGenerateCode_RFC6238(t *testing.T) {
+	seeds := map[string]string{
+		SHA1:   "12345678901234567890",
+		SHA256: "12345678901234567890123456789012",
+		SHA512: "1234567890123456789012345678901234567890123456789012345678901234",
+	}
+	vectors := []struct {
+		time      int64
+		algorithm string
+		code      string
+	}{
+		{59, SHA1, "94287082"},
+		{59, SHA256, "46119246"},
+		{59, SHA512, "90693936"},
+		{1111111109, SHA1, "07081804"},
+		{1111111109, SHA256, "68084774"},
+		{1111111109, SHA512, "25091201"},
+		{2000000000, SHA1, "69279037"},
+		{20000000000, SHA512, "47863826"},
+	}
+
+	for _, vector := range vectors {
+		code, err := GenerateCode(rfcSecret(seeds[vector.algorithm]), time.Unix(vector.time, 0), Options{Digits: 8, Algorithm: vector.algorithm})
+		require.NoError(t, err, "GenerateCode should not return an error")
+		assert.Equal(t, vector.code, code, "Code at %d with %s should match RFC 6238", vector.time, vector.algorithm)
+	}
+
+	_, err := GenerateCode("not base32!", time.Now())
+	assert.ErrorIs(t, err, ErrInvalidSecret, "Malformed secrets should be rejected")
+}
+
+func TestGenerateSecret(t *testing.T) {
+	secret, err := GenerateSecret()
+	require.NoError(t, err, "GenerateSecret should not return an error")
+	assert.Len(t, secret, 32, "Secret should hold 160 bits")
+
+	other, err := GenerateSecret()
+	require.NoError(t, err, "GenerateSecret should not return an error")
+	assert.NotEqual(t, secret, other, "Secrets should be random")
+}
+
+func TestURI(t *testing.T) {
+	uri, err := URI("JBSWY3DPEHPK3PXP", "alice@example.com", Options{Issuer: "Acme Inc"})
+	require.NoError(t, err, "URI should not return an error")
+
+	parsed, err := url.Parse(uri)
+	require.NoError(t, err, "URI should be valid")
+	assert.Equal(t, "otpauth", parsed.Scheme, "URI should use the otpauth scheme")
+	assert.Equal(t, "totp", parsed.Host, "URI should be of type totp")
+	assert.Equal(t, "/Acme Inc:alice@example.com", parsed.Path, "Label should hold the issuer and account")
+
+	query := parsed.Query()
+	assert.Equal(t, "JBSWY3DPEHPK3PXP", query.Get("secret"), "URI should hold the secret")
+	assert.Equal(t, "Acme Inc", query.Get("issuer"), "URI should hold the issuer")
+	assert.Equal(t, "SHA1", query.Get("algorithm"), "URI should hold the algorithm")
+	assert.Equal(t, "6", query.Get("digits"), "URI should hold the digits")
+	assert.Equal(t, "30", query.Get("period"), "URI should hold the period")
+}
+
+func TestValidate_Drift(t *testing.T) {
+	secret, err := GenerateSecret()
+	require.NoError(t, err, "GenerateSecret should not return an error")
+
+	code := func(offset time.Duration) string {
+		code, err := GenerateCode(secret, time.Now().Add(offset))
+		require.NoError(t, err, "GenerateCode should not return an error")
+		return code
+	}
+
+	assert.NoError(t, Validate(secret, code(0)), "Current code should be accepted")
+	assert.NoError(t, Validate(secret, code(-30*time.Second)), "Previous code should be accepted")
+	assert.NoError(t, Validate(secret, code(30*time.Second)), "Next code should be accepted")
+	assert.ErrorIs(t, Validate(secret, code(-90*time.Second)), ErrInvalidCode, "Old codes should be rejected")
+	assert.ErrorIs(t, Validate(secret, code(-30*time.Second), Options{Skew: -1}), ErrInvalidCode, "Drift should be configurable")
+	assert.ErrorIs(t, Validate(secret, "12345"), ErrInvalidCode, "Codes of another length should be rejected")
+}
+
+func TestVerify_Replay(t *testing.T) {
+	redistest.Start(t)
+
+	secret, err := GenerateSecret()
+	require.NoError(t, err, "GenerateSecret should not return an error")
+	code, err := GenerateCode(secret, time.Now())
+	require.NoError(t, err, "GenerateCode should not return an error")
+
+	assert.NoError(t, Verify("account-1", secret, code), "Code should be accepted once")
+	assert.ErrorIs(t, Verify("account-1", secret, code), ErrCodeReused, "Code should not be accepted twice")
+	assert.NoError(t, Verify("account-2", secret, code), "Used codes should be tracked per subject")
+	assert.ErrorIs(t, Verify("account-3", secret, "000000"), ErrInvalidCode, "Wrong codes should be rejected")
+}
+
+func TestOptions_Invalid(t *testing.T) {
+	secret, err := GenerateSecret()
+	require.NoError(t, err, "GenerateSecret should not return an error")
+
+	for _, opts := range []Options{
+		{Period: 500 * time.Millisecond},
+		{Period: -time.Second},
+		{Period: 1500 * time.Millisecond},
+		{Digits: 5},
+		{Digits: 9},
+		{Digits: -1},
+	} {
+		_, err := GenerateCode(secret, time.Now(), opts)
+		assert.ErrorIs(t, err, ErrInvalidOptions, "GenerateCode should reject %+v", opts)
+		assert.ErrorIs(t, Validate(secret, "123456", opts), ErrInvalidOptions, "Validate should reject %+v", opts)
+		_, err = URI(secret, "alice@example.com", opts)
+		assert.ErrorIs(t, err, ErrInvalidOptions, "URI should reject %+v", opts)
+	}
+}
+
+func TestVerify_Lockout(t *testing.T) {
+	redistest.Start(t)
+
+	secret, err := GenerateSecret()
+	require.NoError(t, err, "GenerateSecret should not return an error")
+	code, err := GenerateCode(secret, time.Now())
+	require.NoError(t, err, "GenerateCode should not return an error")
+	wrong := "000000"
+	if code == wrong {
+		wrong = "111111"
+	}
+	opts := Options{Lockout: password.Lockout{MaxAttempts: 3, Duration: time.Minute}}
+
+	for i := 0; i < 3; i++ {
+		assert.ErrorIs(t, Verify("account-1", secret, wrong, opts), ErrInvalidCode, "Wrong codes should be rejected")
+	}
+	assert.ErrorIs(t, Verify("account-1", secret, code, opts), password.ErrTooManyAttempts, "Valid codes should be rejected once locked out")
+	assert.NoError(t, Verify("account-2", secret, code, opts), "Lockouts should be tracked per subject")
+
+	assert.ErrorIs(t, Verify("account-3", secret, wrong, opts), ErrInvalidCode, "Wrong codes should be rejected")
+	assert.NoError(t, Verify("account-3", secret, code, opts), "Valid codes should be accepted before the lockout")
+	for i := 0; i < 2; i++ {
+		assert.ErrorIs(t, Verify("account-3", secret, wrong, opts), ErrInvalidCode, "Wrong codes should be rejected")
+	}
+	nextCode, err := GenerateCode(secret, time.Now().Add(30*time.Second))
+	require.NoError(t, err, "GenerateCode should not return an error")
+	assert.NoError(t, Verify("account-3", secret, nextCode, opts), "Accepted codes should reset the failures")
+}
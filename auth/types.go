@@ -6,13 +6,19 @@ import (
 )
 
 type AuthTokenData struct {
-	ID        uint64     `json:"id" gorm:"primaryKey"`
-	PublicID  string     `json:"publicId" gorm:"type:varchar(8);unique"`
-	Name      string     `json:"name" gorm:"type:varchar(128);"`
-	Email     string     `json:"email" gorm:"type:varchar(128);uniqueIndex"`
-	AvatarUrl string     `json:"avatarUrl" gorm:"type:varchar(256)"`
-	IsAdmin   bool       `json:"isAdmin"`
-	Meta      *types.Map `json:"meta,omitempty"`
+	ID          uint64     `json:"id" gorm:"primaryKey"`
+	PublicID    string     `json:"publicId" gorm:"type:varchar(8);unique"`
+	Name        string     `json:"name" gorm:"type:varchar(128);"`
+	Email       string     `json:"email" gorm:"type:varchar(128);uniqueIndex"`
+	AvatarUrl   string     `json:"avatarUrl" gorm:"type:varchar(256)"`
+	IsAdmin     bool       `json:"isAdmin"`
+	Roles       []string   `json:"roles,omitempty" gorm:"serializer:json"`
+	Permissions []string   `json:"permissions,omitempty" gorm:"serializer:json"`
+	Scopes      []string   `json:"scopes,omitempty" gorm:"serializer:json"`
+	Meta        *types.Map `json:"meta,omitempty"`
+	// MFAAt is the Unix time the account last passed a second factor, set it
+	// before issuing the token, see RequireMFA.
+	MFAAt int64 `json:"mfaAt,omitempty" gorm:"-"`
 }
 
 type AuthValidateResponse struct {
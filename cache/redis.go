@@ -3,6 +3,7 @@ package cache
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"time"
 
 	"github.com/arqut/common/database"
@@ -16,6 +17,15 @@ var (
 	// redisClient *redis.Client
 )
 
+// ErrNotFound is returned by Get and GetObj for missing or expired keys.
+var ErrNotFound = redis.Nil
+
+// IsNotFound reports whether err is ErrNotFound, so callers do not depend on
+// the cache backend.
+func IsNotFound(err error) bool {
+	return errors.Is(err, ErrNotFound)
+}
+
 type RedisCache struct {
 	redisClient       *redis.Client
 	defaultExpiration time.Duration
@@ -64,6 +74,21 @@ func Del(key string) error {
 	return instance.Del(key)
 }
 
+// Exists check if key exists
+func Exists(key string) (bool, error) {
+	return instance.Exists(key)
+}
+
+// SetNX set string value only if the key does not exist yet
+func SetNX(key string, value string, expiration ...time.Duration) (bool, error) {
+	return instance.SetNX(key, value, expiration...)
+}
+
+// Incr increments a counter, the expiration is set when the counter is created
+func Incr(key string, expiration ...time.Duration) (int64, error) {
+	return instance.Incr(key, expiration...)
+}
+
 func getExpiration(expiration ...time.Duration) time.Duration {
 	if len(expiration) > 0 {
 		return expiration[0]
@@ -106,6 +131,28 @@ func (ins *RedisCache) Del(key string) error {
 	return ins.redisClient.Del(context.TODO(), key).Err()
 }
 
+func (ins *RedisCache) Exists(key string) (bool, error) {
+	n, err := ins.redisClient.Exists(context.TODO(), key).Result()
+	return n > 0, err
+}
+
+func (ins *RedisCache) SetNX(key string, value string, expiration ...time.Duration) (bool, error) {
+	return ins.redisClient.SetNX(context.TODO(), key, value, ins.getExpiration(expiration...)).Result()
+}
+
+func (ins *RedisCache) Incr(key string, expiration ...time.Duration) (int64, error) {
+	n, err := ins.redisClient.Incr(context.TODO(), key).Result()
+	if err != nil {
+		return 0, err
+	}
+	if n == 1 {
+		if err := ins.redisClient.Expire(context.TODO(), key, ins.getExpiration(expiration...)).Err(); err != nil {
+			return n, err
+		}
+	}
+	return n, nil
+}
+
 func (ins *RedisCache) getExpiration(expiration ...time.Duration) time.Duration {
 	if len(expiration) > 0 {
 		return expiration[0]
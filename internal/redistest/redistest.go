@@ -0,0 +1,206 @@
+// Package redistest provides an in-memory Redis server for the tests of the
+// packages using the cache package.
+package redistest
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/arqut/common/cache"
+	"github.com/redis/go-redis/v9"
+)
+
+// Server is a minimal in-memory RESP server covering the commands used
+// through the cache package.
+type Server struct {
+	mu      sync.Mutex
+	values  map[string]string
+	expires map[string]time.Time
+}
+
+// Start starts a Server and points the cache package to it until the end of
+// the test.
+func Start(t testing.TB) *Server {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to listen: %v", err)
+	}
+	t.Cleanup(func() { listener.Close() })
+
+	r := &Server{values: make(map[string]string), expires: make(map[string]time.Time)}
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go r.serve(conn)
+		}
+	}()
+
+	client := redis.NewClient(&redis.Options{Addr: listener.Addr().String(), Protocol: 2})
+	t.Cleanup(func() { client.Close() })
+	cache.InitRedisCache(client)
+	return r
+}
+
+// Keys returns the live keys.
+func (r *Server) Keys() []string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	var keys []string
+	for key := range r.values {
+		if r.alive(key) {
+			keys = append(keys, key)
+		}
+	}
+	return keys
+}
+
+// TTL returns the remaining lifetime of key, zero if it has none.
+func (r *Server) TTL(key string) time.Duration {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if expiry, ok := r.expires[key]; ok {
+		return time.Until(expiry)
+	}
+	return 0
+}
+
+func (r *Server) alive(key string) bool {
+	if expiry, ok := r.expires[key]; ok && time.Now().After(expiry) {
+		delete(r.values, key)
+		delete(r.expires, key)
+		return false
+	}
+	_, ok := r.values[key]
+	return ok
+}
+
+func (r *Server) serve(conn net.Conn) {
+	defer conn.Close()
+	reader := bufio.NewReader(conn)
+	for {
+		args, err := readCommand(reader)
+		if err != nil {
+			return
+		}
+		if _, err := io.WriteString(conn, r.exec(args)); err != nil {
+			return
+		}
+	}
+}
+
+func (r *Server) exec(args []string) string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	switch strings.ToUpper(args[0]) {
+	case "PING":
+		return "+PONG\r\n"
+	case "SELECT", "CLIENT":
+		return "+OK\r\n"
+	case "GET":
+		if !r.alive(args[1]) {
+			return "$-1\r\n"
+		}
+		return bulk(r.values[args[1]])
+	case "SET":
+		key, nx, ttl := args[1], false, time.Duration(0)
+		for i := 3; i < len(args); i++ {
+			switch strings.ToUpper(args[i]) {
+			case "NX":
+				nx = true
+			case "EX":
+				n, _ := strconv.Atoi(args[i+1])
+				ttl = time.Duration(n) * time.Second
+				i++
+			case "PX":
+				n, _ := strconv.Atoi(args[i+1])
+				ttl = time.Duration(n) * time.Millisecond
+				i++
+			}
+		}
+		if nx && r.alive(key) {
+			return "$-1\r\n"
+		}
+		r.values[key] = args[2]
+		delete(r.expires, key)
+		if ttl > 0 {
+			r.expires[key] = time.Now().Add(ttl)
+		}
+		return "+OK\r\n"
+	case "DEL", "EXISTS":
+		count := 0
+		for _, key := range args[1:] {
+			if r.alive(key) {
+				count++
+				if strings.ToUpper(args[0]) == "DEL" {
+					delete(r.values, key)
+					delete(r.expires, key)
+				}
+			}
+		}
+		return fmt.Sprintf(":%d\r\n", count)
+	case "INCR":
+		n := 0
+		if r.alive(args[1]) {
+			n, _ = strconv.Atoi(r.values[args[1]])
+		}
+		n++
+		r.values[args[1]] = strconv.Itoa(n)
+		return fmt.Sprintf(":%d\r\n", n)
+	case "EXPIRE", "PEXPIRE":
+		if !r.alive(args[1]) {
+			return ":0\r\n"
+		}
+		n, _ := strconv.Atoi(args[2])
+		unit := time.Second
+		if strings.ToUpper(args[0]) == "PEXPIRE" {
+			unit = time.Millisecond
+		}
+		r.expires[args[1]] = time.Now().Add(time.Duration(n) * unit)
+		return ":1\r\n"
+	}
+	return fmt.Sprintf("-ERR unknown command '%s'\r\n", args[0])
+}
+
+func bulk(value string) string {
+	return fmt.Sprintf("$%d\r\n%s\r\n", len(value), value)
+}
+
+func readCommand(reader *bufio.Reader) ([]string, error) {
+	line, err := reader.ReadString('\n')
+	if err != nil {
+		return nil, err
+	}
+	count, err := strconv.Atoi(strings.TrimSpace(line)[1:])
+	if err != nil {
+		return nil, err
+	}
+
+	args := make([]string, count)
+	for i := range args {
+		line, err := reader.ReadString('\n')
+		if err != nil {
+			return nil, err
+		}
+		length, err := strconv.Atoi(strings.TrimSpace(line)[1:])
+		if err != nil {
+			return nil, err
+		}
+		value := make([]byte, length+2)
+		if _, err := io.ReadFull(reader, value); err != nil {
+			return nil, err
+		}
+		args[i] = string(value[:length])
+	}
+	return args, nil
+}
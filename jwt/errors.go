@@ -0,0 +1,29 @@
+package jwt
+
+import "errors"
+
+// Errors returned by the KeyManager. They are wrapped with additional context,
+// use errors.Is to test for them.
+var (
+	ErrMalformedToken   = errors.New("malformed token")
+	ErrUnknownKey       = errors.New("unknown key")
+	ErrDecryptionFailed = errors.New("failed to decrypt token")
+	ErrInvalidSignature = errors.New("invalid signature")
+	ErrValidationOnly   = errors.New("not allowed in validation only mode")
+	ErrSigningMode      = errors.New("not allowed in signing mode")
+	ErrEncryptionMode   = errors.New("not allowed in encryption mode")
+	ErrNoKeys           = errors.New("no keys available")
+
+	ErrUnsupportedAlgorithm = errors.New("unsupported signature algorithm")
+
+	ErrTokenExpired     = errors.New("token has expired")
+	ErrTokenNotYetValid = errors.New("token is not valid yet")
+	ErrIssuedAtInFuture = errors.New("token issued in the future")
+	ErrAudienceMismatch = errors.New("invalid audience")
+	ErrIssuerMismatch   = errors.New("invalid issuer")
+	ErrSubjectMismatch  = errors.New("invalid subject")
+	ErrInvalidClaim     = errors.New("invalid claim")
+	ErrTokenRevoked     = errors.New("token has been revoked")
+
+	ErrRevocationDisabled = errors.New("no revocation store configured")
+)
@@ -0,0 +1,210 @@
+package jwt
+
+import (
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"crypto/x509"
+	"fmt"
+	"io"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/gofiber/fiber/v2/log"
+	"github.com/lestrrat-go/jwx/v3/jwa"
+	"github.com/lestrrat-go/jwx/v3/jwk"
+)
+
+// JWKSPath is the well-known path JWKSAPIHandler is usually mounted on.
+const JWKSPath = "/.well-known/jwks.json"
+
+// PublicKeySet returns the public part of the current signing keys as an
+// RFC 7517 key set. Encryption keys are secret and never included.
+func (km *KeyManager) PublicKeySet() (jwk.Set, error) {
+	keys, err := km.GetCurrentKeys()
+	if err != nil {
+		return nil, err
+	}
+
+	set := jwk.NewSet()
+	for _, key := range keys {
+		if key.Algorithm == "" {
+			continue
+		}
+
+		publicKey, err := x509.ParsePKIXPublicKey(key.PublicKey)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse public key: %w", err)
+		}
+
+		jwkKey, err := jwk.Import(publicKey)
+		if err != nil {
+			return nil, fmt.Errorf("failed to import public key: %w", err)
+		}
+		jwkKey.Set(jwk.KeyIDKey, key.KeyID())
+		jwkKey.Set(jwk.AlgorithmKey, key.Algorithm)
+		jwkKey.Set(jwk.KeyUsageKey, jwk.ForSignature)
+
+		if err := set.AddKey(jwkKey); err != nil {
+			return nil, fmt.Errorf("failed to add key to set: %w", err)
+		}
+	}
+
+	return set, nil
+}
+
+// JWKSAPIHandler serves the public signing keys in RFC 7517 format, mount it
+// on JWKSPath.
+func (km *KeyManager) JWKSAPIHandler(c *fiber.Ctx) error {
+	set, err := km.PublicKeySet()
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to build key set",
+		})
+	}
+
+	return c.JSON(set)
+}
+
+// JWKSStore is a read only KeyStore fetching public keys from a JWKS endpoint,
+// either JWKSAPIHandler or any third-party one.
+type JWKSStore struct {
+	url    string
+	client *http.Client
+
+	cache     []KeyEntry
+	cacheMu   sync.RWMutex
+	cacheTTL  time.Duration
+	lastFetch time.Time
+}
+
+// NewJWKSStore initializes a new JWKSStore.
+// - url: The JWKS endpoint to fetch keys from.
+// - cacheTTL: Duration to keep the cached keys before refreshing.
+func NewJWKSStore(url string, cacheTTL time.Duration) *JWKSStore {
+	return &JWKSStore{
+		url: url,
+		client: &http.Client{
+			Timeout: 10 * time.Second, // Adjust as needed
+		},
+		cacheTTL: cacheTTL,
+	}
+}
+
+// SaveKey is not supported for JWKSStore as it's intended for fetching keys.
+func (s *JWKSStore) SaveKey(entry KeyEntry) error {
+	return fmt.Errorf("SaveKey is not supported by JWKSStore")
+}
+
+// GetAllKeys retrieves the signing keys of the key set, or from the cache if valid.
+// Keys without kid, meant for encryption or of unsupported types are skipped.
+func (s *JWKSStore) GetAllKeys() ([]KeyEntry, error) {
+	s.cacheMu.RLock()
+	if time.Since(s.lastFetch) < s.cacheTTL && s.cache != nil {
+		keysCopy := make([]KeyEntry, len(s.cache))
+		copy(keysCopy, s.cache)
+		s.cacheMu.RUnlock()
+		return keysCopy, nil
+	}
+	s.cacheMu.RUnlock()
+
+	resp, err := s.client.Get(s.url)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch key set: %w", err)
+	}
+	defer resp.Body.Close()
+
+	bodyBytes, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read response body: %w", err)
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("remote service returned status %d: %s", resp.StatusCode, string(bodyBytes))
+	}
+
+	set, err := jwk.Parse(bodyBytes)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse key set: %w", err)
+	}
+
+	fetchedKeys := make([]KeyEntry, 0, set.Len())
+	for i := 0; i < set.Len(); i++ {
+		key, _ := set.Key(i)
+
+		var kid string
+		if err := key.Get(jwk.KeyIDKey, &kid); err != nil || kid == "" {
+			continue
+		}
+
+		var use string
+		if err := key.Get(jwk.KeyUsageKey, &use); err == nil && use != string(jwk.ForSignature) {
+			continue
+		}
+
+		var publicKey interface{}
+		if err := jwk.Export(key, &publicKey); err != nil {
+			log.Warnf("Skipping JWKS key %q: %v", kid, err)
+			continue
+		}
+
+		var algorithm string
+		var alg jwa.KeyAlgorithm
+		if err := key.Get(jwk.AlgorithmKey, &alg); err == nil {
+			algorithm = alg.String()
+		} else {
+			algorithm = inferAlgorithm(publicKey)
+		}
+		if algorithm == "" {
+			log.Warnf("Skipping JWKS key %q: unsupported key type", kid)
+			continue
+		}
+
+		publicKeyBytes, err := x509.MarshalPKIXPublicKey(publicKey)
+		if err != nil {
+			log.Warnf("Skipping JWKS key %q: %v", kid, err)
+			continue
+		}
+
+		fetchedKeys = append(fetchedKeys, KeyEntry{
+			Kid:       kid,
+			Info:      []byte(kid),
+			Algorithm: algorithm,
+			PublicKey: publicKeyBytes,
+		})
+	}
+
+	sortKeys(fetchedKeys)
+
+	// Update cache
+	s.cacheMu.Lock()
+	s.cache = fetchedKeys
+	s.lastFetch = time.Now()
+	s.cacheMu.Unlock()
+
+	keysCopy := make([]KeyEntry, len(fetchedKeys))
+	copy(keysCopy, fetchedKeys)
+	return keysCopy, nil
+}
+
+// inferAlgorithm picks the signature algorithm for keys published without alg.
+func inferAlgorithm(publicKey interface{}) string {
+	switch key := publicKey.(type) {
+	case ed25519.PublicKey:
+		return AlgEdDSA
+	case *ecdsa.PublicKey:
+		switch key.Curve {
+		case elliptic.P384():
+			return "ES384"
+		case elliptic.P521():
+			return "ES512"
+		}
+		return AlgES256
+	case *rsa.PublicKey:
+		return AlgRS256
+	}
+	return ""
+}
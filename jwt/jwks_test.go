@@ -0,0 +1,214 @@
+package jwt
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/lestrrat-go/jwx/v3/jwa"
+	"github.com/lestrrat-go/jwx/v3/jwk"
+	"github.com/lestrrat-go/jwx/v3/jws"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestKeyManager_JWKSAPIHandler(t *testing.T) {
+	km, err := NewSigningKeyManager(AlgES256, 1*time.Hour, NewInMemoryKeyStore())
+	require.NoError(t, err, "Failed to create signing KeyManager")
+	defer km.Shutdown()
+
+	app := fiber.New()
+	app.Get(JWKSPath, km.JWKSAPIHandler)
+
+	resp, err := app.Test(httptest.NewRequest("GET", JWKSPath, nil))
+	require.NoError(t, err, "Request should not fail")
+	defer resp.Body.Close()
+
+	var body struct {
+		Keys []map[string]interface{} `json:"keys"`
+	}
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&body), "Response should be valid JSON")
+	require.Len(t, body.Keys, 1, "Handler should publish the current signing key")
+
+	key := body.Keys[0]
+	assert.Equal(t, "EC", key["kty"], "Key type should be published")
+	assert.Equal(t, AlgES256, key["alg"], "Key algorithm should be published")
+	assert.Equal(t, "sig", key["use"], "Key usage should be published")
+	assert.Equal(t, km.currentKey.KeyID(), key["kid"], "Key ID should match the kid header of issued tokens")
+	assert.NotContains(t, key, "d", "Private key material should not be published")
+}
+
+func TestKeyManager_JWKSAPIHandler_SkipsEncryptionKeys(t *testing.T) {
+	km, err := NewIssuerKeyManager(1*time.Hour, NewInMemoryKeyStore())
+	require.NoError(t, err, "Failed to create KeyManager")
+	defer km.Shutdown()
+
+	set, err := km.PublicKeySet()
+	require.NoError(t, err, "PublicKeySet should not return an error")
+	assert.Equal(t, 0, set.Len(), "Encryption keys should never be published")
+}
+
+func TestJWKSStore_VerifyWithIssuerKeys(t *testing.T) {
+	km, err := NewSigningKeyManager(AlgEdDSA, 1*time.Hour, NewInMemoryKeyStore())
+	require.NoError(t, err, "Failed to create signing KeyManager")
+	defer km.Shutdown()
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		set, err := km.PublicKeySet()
+		require.NoError(t, err, "PublicKeySet should not return an error")
+		json.NewEncoder(w).Encode(set)
+	}))
+	t.Cleanup(server.Close)
+
+	validator, err := NewValidationKeyManager(NewJWKSStore(server.URL, 10*time.Minute))
+	require.NoError(t, err, "Failed to create Validation KeyManager")
+
+	token, err := km.IssueJWS([]byte("signed payload"), nil)
+	require.NoError(t, err, "IssueJWS should not return an error")
+
+	verified, err := validator.VerifyJWS(token)
+	assert.NoError(t, err, "VerifyJWS should not return an error")
+	assert.Equal(t, []byte("signed payload"), verified, "Verified payload should match original payload")
+}
+
+func TestJWKSStore_ThirdPartyKeys(t *testing.T) {
+	privateKey, err := ecdsa.GenerateKey(elliptic.P384(), rand.Reader)
+	require.NoError(t, err, "Failed to generate key")
+
+	publicJWK, err := jwk.Import(&privateKey.PublicKey)
+	require.NoError(t, err, "Failed to import key")
+	publicJWK.Set(jwk.KeyIDKey, "third-party-1")
+
+	set := jwk.NewSet()
+	set.AddKey(publicJWK)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(set)
+	}))
+	t.Cleanup(server.Close)
+
+	store := NewJWKSStore(server.URL, 10*time.Minute)
+	keys, err := store.GetAllKeys()
+	require.NoError(t, err, "GetAllKeys should not return an error")
+	require.Len(t, keys, 1, "Store should return the published key")
+	assert.Equal(t, "third-party-1", keys[0].KeyID(), "Store should keep the published kid")
+	assert.Equal(t, "ES384", keys[0].Algorithm, "Store should infer the algorithm from the key")
+
+	headers := jws.NewHeaders()
+	headers.Set(jws.KeyIDKey, "third-party-1")
+	token, err := jws.Sign([]byte("third-party payload"), jws.WithKey(jwa.ES384(), privateKey, jws.WithProtectedHeaders(headers)))
+	require.NoError(t, err, "Failed to sign token")
+
+	validator, err := NewValidationKeyManager(store)
+	require.NoError(t, err, "Failed to create Validation KeyManager")
+
+	verified, err := validator.VerifyJWS(token)
+	assert.NoError(t, err, "VerifyJWS should not return an error")
+	assert.Equal(t, []byte("third-party payload"), verified, "Verified payload should match original payload")
+
+	headers.Set(jws.KeyIDKey, "third-party-2")
+	token, err = jws.Sign([]byte("third-party payload"), jws.WithKey(jwa.ES384(), privateKey, jws.WithProtectedHeaders(headers)))
+	require.NoError(t, err, "Failed to sign token")
+
+	_, err = validator.VerifyJWS(token)
+	assert.ErrorIs(t, err, ErrUnknownKey, "VerifyJWS should match keys by kid")
+}
+
+// thirdPartyJWKS serves count ES256 keys without expiry, plus a symmetric key
+// the store cannot use, and returns the private keys by kid.
+func thirdPartyJWKS(t *testing.T, count int) (string, map[string]*ecdsa.PrivateKey) {
+	privateKeys := make(map[string]*ecdsa.PrivateKey, count)
+	set := jwk.NewSet()
+	for i := 0; i < count; i++ {
+		privateKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+		require.NoError(t, err, "Failed to generate key")
+		publicJWK, err := jwk.Import(&privateKey.PublicKey)
+		require.NoError(t, err, "Failed to import key")
+		kid := fmt.Sprintf("third-party-%d", i)
+		publicJWK.Set(jwk.KeyIDKey, kid)
+		set.AddKey(publicJWK)
+		privateKeys[kid] = privateKey
+	}
+
+	symmetricJWK, err := jwk.Import([]byte("symmetric secret"))
+	require.NoError(t, err, "Failed to import key")
+	symmetricJWK.Set(jwk.KeyIDKey, "symmetric")
+	set.AddKey(symmetricJWK)
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(set)
+	}))
+	t.Cleanup(server.Close)
+
+	return server.URL, privateKeys
+}
+
+func signThirdParty(t *testing.T, kid string, privateKey *ecdsa.PrivateKey, claims map[string]interface{}) []byte {
+	payload, err := json.Marshal(claims)
+	require.NoError(t, err, "Failed to encode claims")
+	headers := jws.NewHeaders()
+	headers.Set(jws.KeyIDKey, kid)
+	token, err := jws.Sign(payload, jws.WithKey(jwa.ES256(), privateKey, jws.WithProtectedHeaders(headers)))
+	require.NoError(t, err, "Failed to sign token")
+	return token
+}
+
+func TestJWKSStore_KeepsAllPublishedKeys(t *testing.T) {
+	url, privateKeys := thirdPartyJWKS(t, 5)
+
+	store := NewJWKSStore(url, 10*time.Minute)
+	keys, err := store.GetAllKeys()
+	require.NoError(t, err, "Unsupported keys should not fail the key set")
+	assert.Len(t, keys, 5, "Unsupported keys should be skipped")
+
+	validator, err := NewValidationKeyManager(store, KeyManagerOptions{GracePeriod: time.Hour})
+	require.NoError(t, err, "Failed to create Validation KeyManager")
+	defer validator.Shutdown()
+
+	for kid, privateKey := range privateKeys {
+		token := signThirdParty(t, kid, privateKey, map[string]interface{}{"sub": "user-1"})
+		_, err := validator.VerifyJWS(token)
+		assert.NoError(t, err, "Tokens signed by any published key should be verified, kid %s", kid)
+	}
+}
+
+func TestJWKSStore_ThirdPartyClaims(t *testing.T) {
+	url, privateKeys := thirdPartyJWKS(t, 1)
+	validator, err := NewValidationKeyManager(NewJWKSStore(url, 10*time.Minute))
+	require.NoError(t, err, "Failed to create Validation KeyManager")
+	defer validator.Shutdown()
+
+	kid, privateKey := "third-party-0", privateKeys["third-party-0"]
+	now := time.Now()
+
+	tests := []struct {
+		name    string
+		claims  map[string]interface{}
+		opts    []ValidatorOption
+		wantErr error
+	}{
+		{"Valid", map[string]interface{}{"exp": now.Add(time.Hour).Unix(), "aud": "api", "iss": "idp"}, []ValidatorOption{WithAudience("api"), WithIssuer("idp")}, nil},
+		{"Expired", map[string]interface{}{"exp": now.Add(-24 * time.Hour).Unix()}, nil, ErrTokenExpired},
+		{"Not Yet Valid", map[string]interface{}{"nbf": now.Add(time.Hour).Unix()}, nil, ErrTokenNotYetValid},
+		{"Issued In Future", map[string]interface{}{"iat": now.Add(time.Hour).Unix()}, []ValidatorOption{WithIssuedAtSkew(time.Minute)}, ErrIssuedAtInFuture},
+		{"Audience Mismatch", map[string]interface{}{"aud": []string{"web"}}, []ValidatorOption{WithAudience("api")}, ErrAudienceMismatch},
+		{"Issuer Mismatch", map[string]interface{}{"iss": "other"}, []ValidatorOption{WithIssuer("idp")}, ErrIssuerMismatch},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := validator.VerifyJWS(signThirdParty(t, kid, privateKey, tt.claims), tt.opts...)
+			if tt.wantErr == nil {
+				assert.NoError(t, err, "VerifyJWS should accept valid payload claims")
+			} else {
+				assert.ErrorIs(t, err, tt.wantErr, "VerifyJWS should validate the payload claims")
+			}
+		})
+	}
+}
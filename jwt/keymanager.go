@@ -16,6 +16,7 @@ import (
 	"golang.org/x/crypto/hkdf"
 
 	"github.com/arqut/common/system"
+	"github.com/arqut/common/utils"
 )
 
 type JWEOptions struct {
@@ -26,19 +27,30 @@ type JWEOptions struct {
 type KeyManager struct {
 	currentKey     KeyEntry
 	keyHistory     []KeyEntry
+	keysByID       map[string]KeyEntry // currentKey and keyHistory indexed by kid
 	rotationPeriod time.Duration
 	mu             sync.RWMutex
 	store          KeyStore
 	validationOnly bool
+	algorithm      string // Signature algorithm in signing mode, empty for encryption
+	options        KeyManagerOptions
+	instanceID     string // Rotation lease holder, see RotationLocker
 	ctx            context.Context
 	cancel         context.CancelFunc
+
+	loadMu            sync.Mutex // Serializes store fetches with their update of the keys
+	refreshMu         sync.Mutex
+	lastForcedRefresh time.Time
 }
 
 type KeyResponse struct {
-	ID     uint      `json:"id,omitempty"` // Omit if not using GormKeyStore
-	Key    string    `json:"key"`          // Base64 encoded key
-	Info   string    `json:"info"`         // Base64 encoded info
-	Expiry time.Time `json:"expiry"`       // Expiration time
+	ID        uint      `json:"id,omitempty"`        // Omit if not using GormKeyStore
+	Key       string    `json:"key"`                 // Base64 encoded key, empty for signing keys
+	Info      string    `json:"info"`                // Base64 encoded info
+	Expiry    time.Time `json:"expiry"`              // Expiration time
+	Algorithm string    `json:"algorithm,omitempty"` // Signature algorithm of signing keys
+	PublicKey string    `json:"publicKey,omitempty"` // Base64 encoded PKIX public key of signing keys
+	Kid       string    `json:"kid,omitempty"`       // Key ID of keys from external sources
 }
 
 func WithKeyManager(keyManager *KeyManager, handler func(*fiber.Ctx, *KeyManager) error) func(c *fiber.Ctx) error {
@@ -48,58 +60,55 @@ func WithKeyManager(keyManager *KeyManager, handler func(*fiber.Ctx, *KeyManager
 }
 
 // NewIssuerKeyManager creates a new KeyManager in issuer & validation mode.
-func NewIssuerKeyManager(rotationPeriod time.Duration, store KeyStore) (*KeyManager, error) {
+func NewIssuerKeyManager(rotationPeriod time.Duration, store KeyStore, options ...KeyManagerOptions) (*KeyManager, error) {
+	return newIssuerKeyManager(rotationPeriod, store, "", options...)
+}
+
+func newIssuerKeyManager(rotationPeriod time.Duration, store KeyStore, algorithm string, options ...KeyManagerOptions) (*KeyManager, error) {
+	instanceID, err := utils.GenerateID()
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate instance id: %w", err)
+	}
+
 	ctx, cancel := context.WithCancel(context.Background())
 	km := &KeyManager{
 		rotationPeriod: rotationPeriod,
 		store:          store,
 		validationOnly: false,
+		algorithm:      algorithm,
+		options:        newKeyManagerOptions(options...),
+		instanceID:     instanceID,
 		ctx:            ctx,
 		cancel:         cancel,
 	}
+	km.configureStore()
 
 	// Fetch all existing keys from the store
-	keys, err := store.GetAllKeys()
-	if err != nil {
-		return nil, fmt.Errorf("failed to retrieve keys from store: %v", err)
+	if err := km.loadKeys(); err != nil {
+		cancel()
+		return nil, err
 	}
 
-	if len(keys) > 0 {
-		// Set the most recent key as the current key
-		km.currentKey = keys[len(keys)-1]
-		if len(keys) > 1 {
-			km.keyHistory = keys[:len(keys)-1]
-		}
-
-		if len(keys) > 1 {
-			// Determine the start index to keep only the last three keys
-			start := 0
-			if len(keys)-1 > 2 {
-				start = len(keys) - 1 - 2
-			}
-			km.keyHistory = keys[start : len(keys)-1]
-		} else {
-			km.keyHistory = keys
-		}
+	// Rotate if there is no key yet or the current key is nearing expiration
+	system.Logger.Info("Check if rotating key is required...")
+	if err := km.rotateIfDue(); err != nil {
+		cancel()
+		return nil, fmt.Errorf("failed to rotate key: %w", err)
+	}
 
-		// Check if the current key is nearing expiration
-		timeUntilExpiry := time.Until(km.currentKey.Expiry)
-		// Define a threshold for rotation, e.g., rotate 10% before expiry
-		rotationThreshold := time.Duration(float64(km.rotationPeriod) * 0.1)
-		system.Logger.Info("Check if rotating key is required...")
-		if timeUntilExpiry <= rotationThreshold {
-			system.Logger.Info("Current key is nearing expiration. Rotating key...")
-			if err := km.rotateKey(); err != nil {
-				return nil, fmt.Errorf("failed to rotate key: %v", err)
-			}
-		}
-	} else {
-		// No existing keys found; generate an initial key
-		system.Logger.Info("No existing keys found. Generating initial key...")
-		if err := km.rotateKey(); err != nil {
-			return nil, fmt.Errorf("failed to initialize key manager: %v", err)
+	// Another instance holds the rotation lease, wait for its initial key
+	deadline := time.Now().Add(rotationLockTTL)
+	for km.currentKey.Key == nil && time.Now().Before(deadline) {
+		time.Sleep(rotationLockRetry)
+		if err := km.rotateIfDue(); err != nil {
+			cancel()
+			return nil, fmt.Errorf("failed to rotate key: %w", err)
 		}
 	}
+	if km.currentKey.Key == nil {
+		cancel()
+		return nil, fmt.Errorf("failed to initialize key manager: %w", ErrNoKeys)
+	}
 
 	go km.startKeyRotation()
 
@@ -107,86 +116,178 @@ func NewIssuerKeyManager(rotationPeriod time.Duration, store KeyStore) (*KeyMana
 }
 
 // NewValidationKeyManager creates a new KeyManager in validation only mode,
-// retrieving keys from the provided store.
-func NewValidationKeyManager(store KeyStore) (*KeyManager, error) {
+// retrieving keys from the provided store. Keys are refreshed in the background
+// until Shutdown is called.
+func NewValidationKeyManager(store KeyStore, options ...KeyManagerOptions) (*KeyManager, error) {
+	ctx, cancel := context.WithCancel(context.Background())
 	km := &KeyManager{
 		store:          store,
 		validationOnly: true,
+		options:        newKeyManagerOptions(options...),
+		ctx:            ctx,
+		cancel:         cancel,
 	}
+	km.configureStore()
 
 	err := km.RefreshKeys()
 	if err != nil {
+		cancel()
 		return nil, err
 	}
 
+	go km.startKeyRefresh()
+
 	return km, nil
 }
 
+// RefreshKeys reloads the keys from the store. It is safe to call concurrently
+// with token validation.
 func (km *KeyManager) RefreshKeys() error {
+	km.loadMu.Lock()
+	defer km.loadMu.Unlock()
+
 	keys, err := km.store.GetAllKeys()
 	if err != nil {
-		return fmt.Errorf("failed to retrieve keys from store: %v", err)
+		return fmt.Errorf("failed to retrieve keys from store: %w", err)
 	}
 
 	if len(keys) == 0 {
-		return fmt.Errorf("no keys available in store for validation")
+		return fmt.Errorf("%w in store for validation", ErrNoKeys)
 	}
 
-	km.currentKey = keys[0]
-	if len(keys) > 1 {
-		km.keyHistory = keys[1:]
-	}
+	km.setKeys(keys)
 
 	return nil
 }
 
+// setKeys replaces the keys with keys ordered as returned by KeyStore.GetAllKeys,
+// the last one becomes the current key.
+func (km *KeyManager) setKeys(keys []KeyEntry) {
+	km.mu.Lock()
+	defer km.mu.Unlock()
+
+	km.currentKey = keys[len(keys)-1]
+	km.keyHistory = km.options.retain(keys[:len(keys)-1], km.currentKey, time.Now())
+	km.indexKeys()
+}
+
+// configureStore applies the retention options to stores that support it.
+func (km *KeyManager) configureStore() {
+	if store, ok := km.store.(RetentionStore); ok {
+		store.SetRetention(km.options.HistoryDepth, km.options.GracePeriod)
+	}
+}
+
+// indexKeys rebuilds keysByID, the caller must hold the write lock.
+func (km *KeyManager) indexKeys() {
+	km.keysByID = make(map[string]KeyEntry, len(km.keyHistory)+1)
+	for _, key := range km.keyHistory {
+		km.keysByID[key.KeyID()] = key
+	}
+	km.keysByID[km.currentKey.KeyID()] = km.currentKey
+}
+
+// keyForID returns the key matching kid. Keys are refreshed from the store when
+// the kid is unknown, it may belong to a key issued after the last refresh by
+// another instance sharing the store.
+func (km *KeyManager) keyForID(kid string, signing bool) (KeyEntry, error) {
+	km.mu.RLock()
+	keyEntry, ok := km.keysByID[kid]
+	km.mu.RUnlock()
+
+	if !ok {
+		if err := km.refreshForUnknownKid(); err != nil {
+			return KeyEntry{}, fmt.Errorf("%w: kid %q, refresh failed: %v", ErrUnknownKey, kid, err)
+		}
+		km.mu.RLock()
+		keyEntry, ok = km.keysByID[kid]
+		km.mu.RUnlock()
+	}
+
+	if !ok || (keyEntry.Algorithm != "") != signing {
+		return KeyEntry{}, fmt.Errorf("%w: kid %q", ErrUnknownKey, kid)
+	}
+
+	return keyEntry, nil
+}
+
 func (km *KeyManager) rotateKey() error {
 	if km.validationOnly {
-		return fmt.Errorf("rotateKey not allowed in validation only mode")
+		return fmt.Errorf("rotateKey %w", ErrValidationOnly)
 	}
 
 	km.mu.Lock()
 	defer km.mu.Unlock()
 
-	newKey := make([]byte, 32)
-	if _, err := rand.Read(newKey); err != nil {
-		return fmt.Errorf("failed to generate new key: %v", err)
+	newKey, err := km.generateKey()
+	if err != nil {
+		return err
 	}
 
-	newInfo := []byte(fmt.Sprintf("encryption-key-%d", time.Now().Unix()))
-	newExpiry := time.Now().Add(km.rotationPeriod)
-
 	if km.currentKey.Key != nil {
-		km.keyHistory = append(km.keyHistory, km.currentKey)
-		if len(km.keyHistory) > 3 {
-			km.keyHistory = km.keyHistory[1:len(km.keyHistory)]
-		}
+		km.keyHistory = km.options.retain(append(km.keyHistory, km.currentKey), newKey, time.Now())
 	}
 
-	km.currentKey = KeyEntry{
-		Key:    newKey,
-		Info:   newInfo,
-		Expiry: newExpiry,
-	}
+	km.currentKey = newKey
+	km.indexKeys()
 
 	if err := km.store.SaveKey(km.currentKey); err != nil {
-		return fmt.Errorf("failed to save key: %v", err)
+		return fmt.Errorf("failed to save key: %w", err)
 	}
+	system.Logger.Infof("Key rotated successfully.")
 
 	return nil
 }
 
+// generateKey creates a new key entry for the mode of the KeyManager.
+func (km *KeyManager) generateKey() (KeyEntry, error) {
+	entry := KeyEntry{
+		Expiry:    time.Now().Add(km.rotationPeriod),
+		Algorithm: km.algorithm,
+	}
+
+	// The random suffix keeps the kid unique across issuers sharing a second
+	id, err := utils.GenerateID()
+	if err != nil {
+		return entry, fmt.Errorf("failed to generate key id: %w", err)
+	}
+
+	if km.algorithm != "" {
+		privateKey, publicKey, err := generateSigningKey(km.algorithm)
+		if err != nil {
+			return entry, err
+		}
+		entry.Key = privateKey
+		entry.PublicKey = publicKey
+		entry.Info = []byte(fmt.Sprintf("signing-key-%d-%s", time.Now().Unix(), id))
+		return entry, nil
+	}
+
+	newKey := make([]byte, 32)
+	if _, err := rand.Read(newKey); err != nil {
+		return entry, fmt.Errorf("failed to generate new key: %w", err)
+	}
+	entry.Key = newKey
+	entry.Info = []byte(fmt.Sprintf("encryption-key-%d-%s", time.Now().Unix(), id))
+	return entry, nil
+}
+
+// startKeyRotation checks the current key every rotation threshold, so keys
+// rotated by another instance sharing the store are picked up before the
+// current one expires.
 func (km *KeyManager) startKeyRotation() {
-	ticker := time.NewTicker(km.rotationPeriod)
+	interval := km.rotationThreshold()
+	if interval <= 0 {
+		interval = km.rotationPeriod
+	}
+	ticker := time.NewTicker(interval)
 	defer ticker.Stop()
 
 	for {
 		select {
 		case <-ticker.C:
-			if err := km.rotateKey(); err != nil {
+			if err := km.rotateIfDue(); err != nil {
 				system.Logger.Errorf("Error rotating key: %v", err)
-			} else {
-				system.Logger.Infof("Key rotated successfully.")
 			}
 		case <-km.ctx.Done():
 			system.Logger.Infof("Key rotation goroutine shutting down.")
@@ -195,6 +296,8 @@ func (km *KeyManager) startKeyRotation() {
 	}
 }
 
+// GetCurrentKeys returns the current key followed by the previous keys still
+// within the retention options, most recent first.
 func (km *KeyManager) GetCurrentKeys() ([]KeyEntry, error) {
 	km.mu.RLock()
 	defer km.mu.RUnlock()
@@ -204,15 +307,10 @@ func (km *KeyManager) GetCurrentKeys() ([]KeyEntry, error) {
 	// Start with the current key
 	currentKeys = append(currentKeys, km.currentKey)
 
-	// Determine how many historical keys to include
-	historyCount := 2 // since we already have the current key
-	if len(km.keyHistory) < historyCount {
-		historyCount = len(km.keyHistory)
-	}
-
-	// Append the required number of historical keys
-	if historyCount > 0 {
-		currentKeys = append(currentKeys, km.keyHistory[len(km.keyHistory)-historyCount:]...)
+	// Append the historical keys still within the retention options
+	history := km.options.retain(km.keyHistory, km.currentKey, time.Now())
+	for i := len(history) - 1; i >= 0; i-- {
+		currentKeys = append(currentKeys, history[i])
 	}
 
 	return currentKeys, nil
@@ -229,9 +327,17 @@ func (km *KeyManager) GetCurrentKeysAPIHandler(c *fiber.Ctx) error {
 	var response []KeyResponse
 	for _, key := range keys {
 		kr := KeyResponse{
-			Expiry: key.Expiry,
-			Key:    base64.StdEncoding.EncodeToString(key.Key),
-			Info:   base64.StdEncoding.EncodeToString(key.Info),
+			Expiry:    key.Expiry,
+			Info:      base64.StdEncoding.EncodeToString(key.Info),
+			Algorithm: key.Algorithm,
+			Kid:       key.Kid,
+		}
+
+		// Never publish the private part of signing keys
+		if key.Algorithm != "" {
+			kr.PublicKey = base64.StdEncoding.EncodeToString(key.PublicKey)
+		} else {
+			kr.Key = base64.StdEncoding.EncodeToString(key.Key)
 		}
 
 		// Include ID only if it's set (useful for GormKeyStore)
@@ -248,7 +354,7 @@ func (km *KeyManager) GetCurrentKeysAPIHandler(c *fiber.Ctx) error {
 func generateSalt() ([]byte, error) {
 	salt := make([]byte, 32)
 	if _, err := rand.Read(salt); err != nil {
-		return nil, fmt.Errorf("failed to generate salt: %v", err)
+		return nil, fmt.Errorf("failed to generate salt: %w", err)
 	}
 	return salt, nil
 }
@@ -257,48 +363,50 @@ func deriveKey(masterKey, salt, info []byte) ([]byte, error) {
 	hkdf := hkdf.New(sha256.New, masterKey, salt, info)
 	derivedKey := make([]byte, 32)
 	if _, err := hkdf.Read(derivedKey); err != nil {
-		return nil, fmt.Errorf("failed to derive key: %v", err)
+		return nil, fmt.Errorf("failed to derive key: %w", err)
 	}
 	return derivedKey, nil
 }
 
+// Shutdown stops the background key rotation or refresh.
 func (km *KeyManager) Shutdown() {
-	km.cancel()
+	if km.cancel != nil {
+		km.cancel()
+	}
 }
 
 func (km *KeyManager) IssueJWE(payload []byte, opts *JWEOptions) ([]byte, error) {
 	if km.validationOnly {
-		return nil, fmt.Errorf("IssueJWE not allowed in validation only mode")
+		return nil, fmt.Errorf("IssueJWE %w", ErrValidationOnly)
+	}
+	if km.algorithm != "" {
+		return nil, fmt.Errorf("IssueJWE %w", ErrSigningMode)
 	}
 
-	km.mu.RLock()
-	
-	log.Info("Try to check if current key expired")
-	log.Info("Time now: ", time.Now())
-	log.Info("Key expiry: ", km.currentKey.Expiry)
-
-	if time.Now().After(km.currentKey.Expiry) {
-		km.mu.RUnlock()
-		if err := km.rotateKey(); err != nil {
-			return nil, fmt.Errorf("failed to rotate key: %v", err)
-		}
-		km.mu.RLock()
+	currentKey, err := km.issuingKey()
+	if err != nil {
+		return nil, err
 	}
-	defer km.mu.RUnlock()
 
 	salt, err := generateSalt()
 	if err != nil {
-		return nil, fmt.Errorf("failed to generate salt: %v", err)
+		return nil, fmt.Errorf("failed to generate salt: %w", err)
 	}
 
-	derivedKey, err := deriveKey(km.currentKey.Key, salt, km.currentKey.Info)
+	derivedKey, err := deriveKey(currentKey.Key, salt, currentKey.Info)
 	if err != nil {
-		return nil, fmt.Errorf("failed to derive key: %v", err)
+		return nil, fmt.Errorf("failed to derive key: %w", err)
+	}
+
+	jti, err := GenerateTokenID()
+	if err != nil {
+		return nil, err
 	}
 
 	headers := jwe.NewHeaders()
 	headers.Set("salt", base64.StdEncoding.EncodeToString(salt))
-	headers.Set("kid", base64.StdEncoding.EncodeToString(km.currentKey.Info))
+	headers.Set("kid", currentKey.KeyID())
+	headers.Set("jti", jti)
 	headers.Set("iat", time.Now().Unix())
 
 	if opts != nil {
@@ -317,107 +425,101 @@ func (km *KeyManager) IssueJWE(payload []byte, opts *JWEOptions) ([]byte, error)
 		jwe.WithProtectedHeaders(headers),
 	)
 	if err != nil {
-		return nil, fmt.Errorf("failed to encrypt JWE: %v", err)
+		return nil, fmt.Errorf("failed to encrypt JWE: %w", err)
 	}
 
 	return encrypted, nil
 }
 
-func (km *KeyManager) DecryptJWE(token []byte) ([]byte, error) {
+// issuingKey returns the current key, rotating it first if it has expired.
+func (km *KeyManager) issuingKey() (KeyEntry, error) {
 	km.mu.RLock()
-	defer km.mu.RUnlock()
 
-	msg, err := jwe.Parse(token)
-	if err != nil {
-		return nil, fmt.Errorf("failed to parse JWE: %v", err)
-	}
+	log.Info("Try to check if current key expired")
+	log.Info("Time now: ", time.Now())
+	log.Info("Key expiry: ", km.currentKey.Expiry)
 
-	headers := msg.ProtectedHeaders()
-	var expiration float64
-	if err := headers.Get("exp", &expiration); err == nil {
-		expTime := time.Unix(int64(expiration), 0)
-		if time.Now().After(expTime) {
-			return nil, fmt.Errorf("token has expired")
+	if time.Now().After(km.currentKey.Expiry) {
+		km.mu.RUnlock()
+		if err := km.rotateIfDue(); err != nil {
+			return KeyEntry{}, fmt.Errorf("failed to rotate key: %w", err)
 		}
+		km.mu.RLock()
 	}
+	defer km.mu.RUnlock()
 
-	var saltStr string
-	if err := headers.Get("salt", &saltStr); err != nil {
-		return nil, fmt.Errorf("failed to get salt from headers: %v", err)
-	}
+	return km.currentKey, nil
+}
 
-	saltBytes, err := base64.StdEncoding.DecodeString(saltStr)
+// Decrypt decrypts a JWE issued by a KeyManager sharing the same keys and
+// validates its protected headers. exp and nbf are always checked when present,
+// further checks are added through opts. Revoked tokens are rejected with
+// ErrTokenRevoked.
+func (km *KeyManager) Decrypt(token []byte, opts ...ValidatorOption) ([]byte, error) {
+	decrypted, headers, err := km.decrypt(token, opts...)
 	if err != nil {
-		return nil, fmt.Errorf("failed to decode salt: %v", err)
+		return nil, err
 	}
 
-	allKeys := append([]KeyEntry{km.currentKey}, km.keyHistory...)
-
-	for _, keyEntry := range allKeys {
-		derivedKey, err := deriveKey(keyEntry.Key, saltBytes, keyEntry.Info)
-		if err != nil {
-			continue
-		}
-		decrypted, err := jwe.Decrypt(token, jwe.WithKey(jwa.DIRECT(), derivedKey))
-		if err == nil {
-			return decrypted, nil
-		}
+	if err := km.checkRevocation(headers); err != nil {
+		return nil, err
 	}
 
-	return nil, fmt.Errorf("failed to decrypt JWE with any known key")
+	return decrypted, nil
 }
 
-// This need to be rafactored to have generic DecryptJWE with possibility to pass header validators
-// for example: expiration, audience, issuer, etc.
-func (km *KeyManager) DecryptJWEForAudience(token []byte, audience string) ([]byte, error) {
-	km.mu.RLock()
-	defer km.mu.RUnlock()
-
+func (km *KeyManager) decrypt(token []byte, opts ...ValidatorOption) ([]byte, Headers, error) {
 	msg, err := jwe.Parse(token)
 	if err != nil {
-		return nil, fmt.Errorf("failed to parse JWE: %v", err)
+		return nil, nil, fmt.Errorf("%w: failed to parse JWE: %w", ErrMalformedToken, err)
 	}
 
 	headers := msg.ProtectedHeaders()
-	var expiration float64
-	if err := headers.Get("exp", &expiration); err == nil {
-		expTime := time.Unix(int64(expiration), 0)
-		if time.Now().After(expTime) {
-			return nil, fmt.Errorf("token has expired")
-		}
+	kid, ok := headers.KeyID()
+	if !ok {
+		return nil, nil, fmt.Errorf("%w: missing kid header", ErrMalformedToken)
 	}
 
-	var audienceStr string
-	if err := headers.Get("aud", &audienceStr); err != nil {
-		return nil, fmt.Errorf("failed to get audience from headers: %v", err)
+	var saltStr string
+	if err := headers.Get("salt", &saltStr); err != nil {
+		return nil, nil, fmt.Errorf("%w: failed to get salt from headers: %w", ErrMalformedToken, err)
 	}
 
-	if audienceStr != audience {
-		return nil, fmt.Errorf("invalid audience")
+	saltBytes, err := base64.StdEncoding.DecodeString(saltStr)
+	if err != nil {
+		return nil, nil, fmt.Errorf("%w: failed to decode salt: %w", ErrMalformedToken, err)
 	}
 
-	var saltStr string
-	if err := headers.Get("salt", &saltStr); err != nil {
-		return nil, fmt.Errorf("failed to get salt from headers: %v", err)
+	keyEntry, err := km.keyForID(kid, false)
+	if err != nil {
+		return nil, nil, err
 	}
 
-	saltBytes, err := base64.StdEncoding.DecodeString(saltStr)
+	derivedKey, err := deriveKey(keyEntry.Key, saltBytes, keyEntry.Info)
 	if err != nil {
-		return nil, fmt.Errorf("failed to decode salt: %v", err)
+		return nil, nil, fmt.Errorf("%w: %w", ErrDecryptionFailed, err)
 	}
 
-	allKeys := append([]KeyEntry{km.currentKey}, km.keyHistory...)
+	decrypted, err := jwe.Decrypt(token, jwe.WithKey(jwa.DIRECT(), derivedKey))
+	if err != nil {
+		return nil, nil, fmt.Errorf("%w: %w", ErrDecryptionFailed, err)
+	}
 
-	for _, keyEntry := range allKeys {
-		derivedKey, err := deriveKey(keyEntry.Key, saltBytes, keyEntry.Info)
-		if err != nil {
-			continue
-		}
-		decrypted, err := jwe.Decrypt(token, jwe.WithKey(jwa.DIRECT(), derivedKey))
-		if err == nil {
-			return decrypted, nil
-		}
+	// The protected headers are only authenticated once decryption succeeded,
+	// so tampered tokens are reported as ErrDecryptionFailed before any claim.
+	if err := newValidation(opts...).validate(headers); err != nil {
+		return nil, nil, err
 	}
 
-	return nil, fmt.Errorf("failed to decrypt JWE with any known key")
-}
\ No newline at end of file
+	return decrypted, headers, nil
+}
+
+// DecryptJWE decrypts a JWE, only checking its exp and nbf headers.
+func (km *KeyManager) DecryptJWE(token []byte) ([]byte, error) {
+	return km.Decrypt(token)
+}
+
+// DecryptJWEForAudience decrypts a JWE whose aud header must contain audience.
+func (km *KeyManager) DecryptJWEForAudience(token []byte, audience string) ([]byte, error) {
+	return km.Decrypt(token, WithAudience(audience))
+}
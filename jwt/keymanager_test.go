@@ -3,13 +3,17 @@ package jwt
 
 import (
 	"os"
+	"path/filepath"
 	"strconv"
+	"sync"
 	"testing"
 	"time"
 
 	"github.com/arqut/common/system"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
 )
 
 // TestMain sets up the environment before running tests.
@@ -59,7 +63,7 @@ func TestKeyManager_KeyRotation(t *testing.T) {
 	km, err := NewIssuerKeyManager(rotationPeriod, store)
 	require.NoError(t, err, "Failed to create KeyManager")
 
-	initialKey := km.currentKey
+	initialKey := currentTestKey(t, km)
 	require.NotNil(t, initialKey.Key, "Initial key should not be nil")
 
 	// Wait for rotation to occur
@@ -70,7 +74,7 @@ func TestKeyManager_KeyRotation(t *testing.T) {
 	require.NoError(t, err, "GetAllKeys should not return an error")
 	require.Len(t, keys, 2, "There should be two keys after rotation")
 
-	newKey := km.currentKey
+	newKey := currentTestKey(t, km)
 	assert.NotEqual(t, initialKey.Key, newKey.Key, "New key should be different from the initial key")
 	assert.Equal(t, initialKey, keys[0], "Initial key should be in the key history")
 	assert.Equal(t, newKey, keys[1], "New key should be the current key")
@@ -171,12 +175,19 @@ func TestKeyManager_Shutdown(t *testing.T) {
 	time.Sleep(2 * time.Second)
 
 	// Check that no new keys are added after shutdown
-	initialKey := km.currentKey
+	initialKey := currentTestKey(t, km)
 	time.Sleep(2 * time.Second)
 	keys, err := store.GetAllKeys()
 	require.NoError(t, err, "GetAllKeys should not return an error")
 	require.Len(t, keys, 1, "There should still be only one key after shutdown")
-	assert.Equal(t, initialKey, km.currentKey, "Current key should remain unchanged after shutdown")
+	assert.Equal(t, initialKey, currentTestKey(t, km), "Current key should remain unchanged after shutdown")
+}
+
+// currentTestKey returns the current key without racing the rotation goroutine.
+func currentTestKey(t *testing.T, km *KeyManager) KeyEntry {
+	keys, err := km.GetCurrentKeys()
+	require.NoError(t, err, "GetCurrentKeys should not return an error")
+	return keys[0]
 }
 
 func TestKeyManager_DecryptJWE_WithInvalidKey(t *testing.T) {
@@ -201,6 +212,34 @@ func TestKeyManager_DecryptJWE_WithInvalidKey(t *testing.T) {
 	assert.Nil(t, decrypted, "Decrypted payload should be nil for corrupted token")
 }
 
+func TestKeyManager_DecryptJWE_Errors(t *testing.T) {
+	km, err := NewIssuerKeyManager(1*time.Hour, NewInMemoryKeyStore())
+	require.NoError(t, err, "Failed to create KeyManager")
+	defer km.Shutdown()
+
+	otherStore := NewInMemoryKeyStore()
+	otherStore.SaveKey(KeyEntry{
+		Key:    []byte("another_issuer_master_key_32byte"),
+		Info:   []byte("another-issuer-key"),
+		Expiry: time.Now().Add(1 * time.Hour),
+	})
+	other, err := NewIssuerKeyManager(1*time.Hour, otherStore)
+	require.NoError(t, err, "Failed to create KeyManager")
+	defer other.Shutdown()
+
+	_, err = km.DecryptJWE([]byte("not a token"))
+	assert.ErrorIs(t, err, ErrMalformedToken, "DecryptJWE should report malformed tokens")
+
+	token, err := other.IssueJWE([]byte("test payload"), nil)
+	require.NoError(t, err, "IssueJWE should not return an error")
+
+	_, err = km.DecryptJWE(token)
+	assert.ErrorIs(t, err, ErrUnknownKey, "DecryptJWE should report tokens from unknown keys")
+
+	_, err = NewValidationKeyManager(NewInMemoryKeyStore())
+	assert.ErrorIs(t, err, ErrNoKeys, "NewValidationKeyManager should fail on an empty store")
+}
+
 func TestKeyManager_IssueJWE_InValidationOnlyMode(t *testing.T) {
 	store := NewInMemoryKeyStore()
 
@@ -220,7 +259,7 @@ func TestKeyManager_IssueJWE_InValidationOnlyMode(t *testing.T) {
 
 	// Attempt to issue JWE in validation-only mode
 	_, err = kmValidator.IssueJWE(payload, nil)
-	assert.Error(t, err, "IssueJWE should return an error in validation-only mode")
+	assert.ErrorIs(t, err, ErrValidationOnly, "IssueJWE should return an error in validation-only mode")
 }
 
 func TestKeyManager_DeriveKey(t *testing.T) {
@@ -321,24 +360,24 @@ func TestGetCurrentKeys_WithMoreThanFourHistoryKeys(t *testing.T) {
 	}
 
 	// Define the expected keys:
-	// - Current Key: Key5 (last inserted)
-	// - Two most recent historical keys: Key3 and Key4
+	// - Current Key: Key6 (last inserted)
+	// - Two most recent historical keys, most recent first: Key5 and Key4
 	expectedKeys := []KeyEntry{
 		{
-			Key:    []byte{6}, // Key5
+			Key:    []byte{6}, // Key6
 			Info:   []byte("info6"),
 			Expiry: now.Add(5 * time.Hour),
 		},
 		{
-			Key:    []byte{4}, // Key3
-			Info:   []byte("info4"),
-			Expiry: now.Add(3 * time.Hour),
-		},
-		{
-			Key:    []byte{5}, // Key4
+			Key:    []byte{5}, // Key5
 			Info:   []byte("info5"),
 			Expiry: now.Add(4 * time.Hour),
 		},
+		{
+			Key:    []byte{4}, // Key4
+			Info:   []byte("info4"),
+			Expiry: now.Add(3 * time.Hour),
+		},
 	}
 
 	// Assert that the returned keys match the expected keys
@@ -356,3 +395,205 @@ func TestGetCurrentKeys_WithMoreThanFourHistoryKeys(t *testing.T) {
 		}
 	}
 }
+
+func TestKeyManager_Decrypt_RefreshesOnUnknownKid(t *testing.T) {
+	store := NewInMemoryKeyStore()
+
+	kmIssuer, err := NewIssuerKeyManager(1*time.Hour, store)
+	require.NoError(t, err, "Failed to create Issuer KeyManager")
+	defer kmIssuer.Shutdown()
+
+	kmValidator, err := NewValidationKeyManager(store)
+	require.NoError(t, err, "Failed to create Validation KeyManager")
+
+	// Rotate after the validator loaded its keys
+	require.NoError(t, kmIssuer.rotateKey(), "rotateKey should not return an error")
+
+	payload := []byte("payload for new key")
+	token, err := kmIssuer.IssueJWE(payload, nil)
+	require.NoError(t, err, "IssueJWE should not return an error")
+
+	decrypted, err := kmValidator.DecryptJWE(token)
+	assert.NoError(t, err, "DecryptJWE should refresh keys for an unknown kid")
+	assert.Equal(t, payload, decrypted, "Decrypted payload should match original payload")
+	assert.Contains(t, kmValidator.keysByID, kmIssuer.currentKey.KeyID(), "Refreshed keys should be indexed by kid")
+}
+
+func TestKeyManager_UniqueKeyIDs(t *testing.T) {
+	km1, err := NewIssuerKeyManager(1*time.Hour, NewInMemoryKeyStore())
+	require.NoError(t, err, "Failed to create KeyManager")
+	defer km1.Shutdown()
+
+	km2, err := NewIssuerKeyManager(1*time.Hour, NewInMemoryKeyStore())
+	require.NoError(t, err, "Failed to create KeyManager")
+	defer km2.Shutdown()
+
+	assert.NotEqual(t, km1.currentKey.KeyID(), km2.currentKey.KeyID(), "Keys created in the same second should have distinct kids")
+}
+
+func TestKeyManager_HistoryDepth(t *testing.T) {
+	store := NewInMemoryKeyStore()
+	now := time.Now()
+	for i := 0; i < 6; i++ {
+		require.NoError(t, store.SaveKey(KeyEntry{
+			Key:    []byte{byte(i + 1)},
+			Info:   []byte("info" + strconv.Itoa(i+1)),
+			Expiry: now.Add(time.Duration(i+1) * time.Hour),
+		}))
+	}
+
+	km, err := NewIssuerKeyManager(24*time.Hour, store, KeyManagerOptions{HistoryDepth: 4})
+	require.NoError(t, err, "Failed to create KeyManager")
+	defer km.Shutdown()
+
+	keys, err := km.GetCurrentKeys()
+	require.NoError(t, err, "GetCurrentKeys should not return an error")
+	assert.Len(t, keys, 5, "Current key and four previous keys should be kept")
+	assert.Len(t, km.keysByID, 5, "Only retained keys should be indexed")
+
+	require.NoError(t, km.rotateKey(), "rotateKey should not return an error")
+	assert.Len(t, km.keyHistory, 4, "Rotation should keep HistoryDepth previous keys")
+}
+
+func TestKeyManager_GracePeriod(t *testing.T) {
+	store := NewInMemoryKeyStore()
+	now := time.Now()
+	store.SaveKey(KeyEntry{Key: []byte{1}, Info: []byte("long-expired"), Expiry: now.Add(-2 * time.Hour)})
+	store.SaveKey(KeyEntry{Key: []byte{2}, Info: []byte("recently-expired"), Expiry: now.Add(-10 * time.Minute)})
+	store.SaveKey(KeyEntry{Key: []byte{3}, Info: []byte("current"), Expiry: now.Add(24 * time.Hour)})
+
+	km, err := NewIssuerKeyManager(24*time.Hour, store, KeyManagerOptions{GracePeriod: 30 * time.Minute})
+	require.NoError(t, err, "Failed to create KeyManager")
+	defer km.Shutdown()
+
+	keys, err := km.GetCurrentKeys()
+	require.NoError(t, err, "GetCurrentKeys should not return an error")
+	require.Len(t, keys, 2, "Keys expired past the grace period should be dropped")
+	assert.Equal(t, []byte("current"), keys[0].Info, "Current key should be first")
+	assert.Equal(t, []byte("recently-expired"), keys[1].Info, "Keys within the grace period should be kept")
+
+	_, err = km.keyForID(KeyEntry{Info: []byte("long-expired")}.KeyID(), false)
+	assert.ErrorIs(t, err, ErrUnknownKey, "Dropped keys should no longer validate tokens")
+}
+
+func TestKeyManager_SharedStoreRotation(t *testing.T) {
+	db, err := gorm.Open(sqlite.Open(filepath.Join(t.TempDir(), "keys.db")), &gorm.Config{})
+	require.NoError(t, err, "Should open SQLite without error")
+	sqlDB, err := db.DB()
+	require.NoError(t, err, "Should get the SQL database")
+	sqlDB.SetMaxOpenConns(1)
+
+	store := NewGormKeyStore(db)
+	replicas := make([]*KeyManager, 3)
+	for i := range replicas {
+		replicas[i], err = NewIssuerKeyManager(1*time.Hour, store)
+		require.NoError(t, err, "Failed to create KeyManager")
+		defer replicas[i].Shutdown()
+	}
+
+	var count int64
+	db.Model(&KeyEntry{}).Count(&count)
+	assert.Equal(t, int64(1), count, "Only one replica should create the initial key")
+	for _, km := range replicas[1:] {
+		assert.Equal(t, replicas[0].currentKey.KeyID(), km.currentKey.KeyID(), "Replicas should share the current key")
+	}
+
+	// Bring the current key close to expiry and let every replica check it at once
+	db.Model(&KeyEntry{}).Where("1 = 1").Update("expiry", time.Now().Add(time.Minute))
+	var wg sync.WaitGroup
+	for _, km := range replicas {
+		wg.Add(1)
+		go func(km *KeyManager) {
+			defer wg.Done()
+			assert.NoError(t, km.rotateIfDue(), "rotateIfDue should not return an error")
+		}(km)
+	}
+	wg.Wait()
+
+	// Replicas that lost the lease pick up the new key on their next check
+	for _, km := range replicas {
+		require.NoError(t, km.rotateIfDue(), "rotateIfDue should not return an error")
+	}
+
+	db.Model(&KeyEntry{}).Count(&count)
+	assert.Equal(t, int64(2), count, "Only one replica should rotate the key")
+	for _, km := range replicas[1:] {
+		assert.Equal(t, replicas[0].currentKey.KeyID(), km.currentKey.KeyID(), "Replicas should agree on the rotated key")
+	}
+
+	// A replica rotating on its own is picked up when its tokens are decrypted
+	require.NoError(t, replicas[0].rotateKey(), "rotateKey should not return an error")
+	token := issueTestToken(t, replicas[0], nil)
+	_, err = replicas[1].DecryptJWE(token)
+	assert.NoError(t, err, "Replicas should load keys saved by another replica")
+}
+
+func TestKeyManager_RefreshKeys_Ordering(t *testing.T) {
+	db, err := gorm.Open(sqlite.Open(":memory:"), &gorm.Config{})
+	require.NoError(t, err, "Should connect to in-memory SQLite without error")
+
+	store := NewGormKeyStore(db)
+	now := time.Now()
+	for _, i := range []int{2, 3, 1} {
+		store.SaveKey(KeyEntry{
+			Key:    []byte{byte(i)},
+			Info:   []byte("info" + strconv.Itoa(i)),
+			Expiry: now.Add(time.Duration(i) * time.Hour),
+		})
+	}
+
+	km, err := NewValidationKeyManager(store)
+	require.NoError(t, err, "Failed to create Validation KeyManager")
+	defer km.Shutdown()
+
+	keys, err := km.GetCurrentKeys()
+	require.NoError(t, err, "GetCurrentKeys should not return an error")
+	require.Len(t, keys, 3, "All keys should be loaded")
+	assert.Equal(t, []byte("info3"), keys[0].Info, "Key expiring last should be the current key")
+	assert.Equal(t, []byte("info2"), keys[1].Info, "Previous keys should be most recent first")
+	assert.Equal(t, []byte("info1"), keys[2].Info, "Previous keys should be most recent first")
+}
+
+// TestKeyManager_ConcurrentRefresh is meant to run with -race.
+func TestKeyManager_ConcurrentRefresh(t *testing.T) {
+	store := NewInMemoryKeyStore()
+
+	kmIssuer, err := NewIssuerKeyManager(1*time.Hour, store, KeyManagerOptions{HistoryDepth: 100})
+	require.NoError(t, err, "Failed to create Issuer KeyManager")
+	defer kmIssuer.Shutdown()
+
+	kmValidator, err := NewValidationKeyManager(store, KeyManagerOptions{HistoryDepth: 100})
+	require.NoError(t, err, "Failed to create Validation KeyManager")
+	defer kmValidator.Shutdown()
+
+	payload := []byte("validated payload")
+	token := issueTestToken(t, kmIssuer, nil)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(3)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 50; j++ {
+				decrypted, err := kmValidator.DecryptJWE(token)
+				assert.NoError(t, err, "DecryptJWE should not return an error")
+				assert.Equal(t, payload, decrypted, "Decrypted payload should match original payload")
+			}
+		}()
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 50; j++ {
+				assert.NoError(t, kmValidator.RefreshKeys(), "RefreshKeys should not return an error")
+			}
+		}()
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 5; j++ {
+				assert.NoError(t, kmIssuer.rotateKey(), "rotateKey should not return an error")
+				_, err := kmIssuer.GetCurrentKeys()
+				assert.NoError(t, err, "GetCurrentKeys should not return an error")
+			}
+		}()
+	}
+	wg.Wait()
+}
@@ -0,0 +1,110 @@
+package jwt
+
+import "time"
+
+const (
+	// DefaultHistoryDepth is the number of previous keys kept when
+	// KeyManagerOptions.HistoryDepth is not set.
+	DefaultHistoryDepth = 2
+	// DefaultRefreshInterval is how often validation only managers reload
+	// their keys when KeyManagerOptions.RefreshInterval is not set.
+	DefaultRefreshInterval = 5 * time.Minute
+	// DefaultMinRefreshInterval is the minimum delay between refreshes forced
+	// by unknown kids when KeyManagerOptions.MinRefreshInterval is not set.
+	DefaultMinRefreshInterval = 30 * time.Second
+)
+
+// KeyManagerOptions configures how long a KeyManager keeps previous keys
+// around to validate tokens issued before a rotation.
+type KeyManagerOptions struct {
+	// HistoryDepth is the number of previous keys kept next to the current
+	// one, defaults to DefaultHistoryDepth. It should cover the longest token
+	// lifetime divided by the rotation period.
+	HistoryDepth int
+	// GracePeriod drops previous keys once they are expired for longer than
+	// this, usually the longest token lifetime. Zero keeps them until they
+	// fall out of HistoryDepth.
+	GracePeriod time.Duration
+	// RefreshInterval is how often validation only managers reload their keys
+	// from the store in the background, defaults to DefaultRefreshInterval.
+	RefreshInterval time.Duration
+	// RefreshJitter is the maximum random delay added to every RefreshInterval
+	// so instances do not hit the store at once, defaults to a tenth of it.
+	RefreshJitter time.Duration
+	// MinRefreshInterval is the minimum delay between refreshes forced by a
+	// token with an unknown kid, defaults to DefaultMinRefreshInterval. Unknown
+	// kids are rejected without reaching the store in between.
+	MinRefreshInterval time.Duration
+	// Revocations is consulted by Decrypt and VerifyJWS to reject revoked
+	// tokens, revocation is disabled when nil.
+	Revocations RevocationStore
+}
+
+// RetentionStore is implemented by key stores that bound the keys returned by
+// GetAllKeys, the KeyManager configures it from its KeyManagerOptions so the
+// store, the history and the published keys agree. SetRetention may be called
+// while another KeyManager sharing the store refreshes its keys.
+type RetentionStore interface {
+	SetRetention(historyDepth int, gracePeriod time.Duration)
+}
+
+func newKeyManagerOptions(options ...KeyManagerOptions) KeyManagerOptions {
+	var opts KeyManagerOptions
+	if len(options) > 0 {
+		opts = options[0]
+	}
+	if opts.HistoryDepth <= 0 {
+		opts.HistoryDepth = DefaultHistoryDepth
+	}
+	if opts.RefreshInterval <= 0 {
+		opts.RefreshInterval = DefaultRefreshInterval
+	}
+	if opts.RefreshJitter <= 0 {
+		opts.RefreshJitter = opts.RefreshInterval / 10
+	}
+	if opts.MinRefreshInterval <= 0 {
+		opts.MinRefreshInterval = DefaultMinRefreshInterval
+	}
+	return opts
+}
+
+// retain returns the previous keys that are still within the grace period,
+// limited to the HistoryDepth most recent ones of each algorithm, so stores
+// shared by encryption and signing issuers keep the history of both. history
+// is ordered from the oldest to the most recent key. The most recent key of
+// another algorithm than current is the current key of that mode and does not
+// count toward its depth. Keys without expiry, like the ones of a JWKS, are
+// retired by their publisher and always kept.
+func (o KeyManagerOptions) retain(history []KeyEntry, current KeyEntry, now time.Time) []KeyEntry {
+	retained := make([]KeyEntry, 0, len(history))
+	expiring := make(map[string]int)
+	for _, key := range history {
+		if !key.Expiry.IsZero() {
+			if o.GracePeriod > 0 && now.After(key.Expiry.Add(o.GracePeriod)) {
+				continue
+			}
+			expiring[key.Algorithm]++
+		}
+		retained = append(retained, key)
+	}
+
+	drop := make(map[string]int, len(expiring))
+	for algorithm, count := range expiring {
+		depth := o.HistoryDepth
+		if algorithm != current.Algorithm {
+			depth++
+		}
+		drop[algorithm] = count - depth
+	}
+
+	kept := retained[:0]
+	for _, key := range retained {
+		if drop[key.Algorithm] > 0 && !key.Expiry.IsZero() {
+			drop[key.Algorithm]--
+			continue
+		}
+		kept = append(kept, key)
+	}
+
+	return kept
+}
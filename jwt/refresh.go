@@ -0,0 +1,50 @@
+package jwt
+
+import (
+	"fmt"
+	"math/rand/v2"
+	"time"
+
+	"github.com/arqut/common/system"
+)
+
+// refreshForUnknownKid reloads the keys after a token with an unknown kid, at
+// most once per MinRefreshInterval so forged tokens cannot hammer the store.
+func (km *KeyManager) refreshForUnknownKid() error {
+	km.refreshMu.Lock()
+	defer km.refreshMu.Unlock()
+
+	if time.Since(km.lastForcedRefresh) < km.options.MinRefreshInterval {
+		return fmt.Errorf("last refresh less than %v ago", km.options.MinRefreshInterval)
+	}
+	km.lastForcedRefresh = time.Now()
+
+	if km.validationOnly {
+		return km.RefreshKeys()
+	}
+	return km.loadKeys()
+}
+
+// startKeyRefresh reloads the keys of a validation only manager every
+// RefreshInterval plus a random jitter until Shutdown.
+func (km *KeyManager) startKeyRefresh() {
+	timer := time.NewTimer(km.nextRefresh())
+	defer timer.Stop()
+
+	for {
+		select {
+		case <-timer.C:
+			if err := km.RefreshKeys(); err != nil {
+				system.Logger.Errorf("Error refreshing keys: %v", err)
+			}
+			timer.Reset(km.nextRefresh())
+		case <-km.ctx.Done():
+			system.Logger.Infof("Key refresh goroutine shutting down.")
+			return
+		}
+	}
+}
+
+func (km *KeyManager) nextRefresh() time.Duration {
+	return km.options.RefreshInterval + rand.N(km.options.RefreshJitter)
+}
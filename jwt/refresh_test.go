@@ -0,0 +1,80 @@
+package jwt
+
+import (
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// countingStore counts the calls to GetAllKeys of the wrapped store.
+type countingStore struct {
+	KeyStore
+	fetches atomic.Int32
+}
+
+func (s *countingStore) GetAllKeys() ([]KeyEntry, error) {
+	s.fetches.Add(1)
+	return s.KeyStore.GetAllKeys()
+}
+
+func TestKeyManager_ForcedRefreshIsThrottled(t *testing.T) {
+	store := &countingStore{KeyStore: NewInMemoryKeyStore()}
+
+	kmIssuer, err := NewIssuerKeyManager(1*time.Hour, store)
+	require.NoError(t, err, "Failed to create Issuer KeyManager")
+	defer kmIssuer.Shutdown()
+
+	kmValidator, err := NewValidationKeyManager(store, KeyManagerOptions{MinRefreshInterval: time.Hour})
+	require.NoError(t, err, "Failed to create Validation KeyManager")
+	defer kmValidator.Shutdown()
+
+	// The first unknown kid triggers a refresh
+	require.NoError(t, kmIssuer.rotateKey(), "rotateKey should not return an error")
+	_, err = kmValidator.DecryptJWE(issueTestToken(t, kmIssuer, nil))
+	assert.NoError(t, err, "DecryptJWE should refresh keys for an unknown kid")
+
+	// Further unknown kids are rejected without reaching the store
+	otherIssuer, err := NewIssuerKeyManager(1*time.Hour, NewInMemoryKeyStore())
+	require.NoError(t, err, "Failed to create Issuer KeyManager")
+	defer otherIssuer.Shutdown()
+	forged := issueTestToken(t, otherIssuer, nil)
+
+	fetches := store.fetches.Load()
+	for i := 0; i < 10; i++ {
+		_, err = kmValidator.DecryptJWE(forged)
+		assert.ErrorIs(t, err, ErrUnknownKey, "DecryptJWE should reject unknown kids")
+	}
+	assert.Equal(t, fetches, store.fetches.Load(), "Unknown kids should not refresh keys before MinRefreshInterval")
+}
+
+func TestKeyManager_BackgroundRefresh(t *testing.T) {
+	store := &countingStore{KeyStore: NewInMemoryKeyStore()}
+
+	kmIssuer, err := NewIssuerKeyManager(1*time.Hour, store)
+	require.NoError(t, err, "Failed to create Issuer KeyManager")
+	defer kmIssuer.Shutdown()
+
+	kmValidator, err := NewValidationKeyManager(store, KeyManagerOptions{
+		RefreshInterval: 20 * time.Millisecond,
+		RefreshJitter:   10 * time.Millisecond,
+	})
+	require.NoError(t, err, "Failed to create Validation KeyManager")
+
+	require.NoError(t, kmIssuer.rotateKey(), "rotateKey should not return an error")
+	kid := kmIssuer.currentKey.KeyID()
+	assert.Eventually(t, func() bool {
+		kmValidator.mu.RLock()
+		defer kmValidator.mu.RUnlock()
+		_, ok := kmValidator.keysByID[kid]
+		return ok
+	}, time.Second, 10*time.Millisecond, "Validation KeyManager should refresh keys in the background")
+
+	kmValidator.Shutdown()
+	time.Sleep(50 * time.Millisecond)
+	fetches := store.fetches.Load()
+	time.Sleep(100 * time.Millisecond)
+	assert.Equal(t, fetches, store.fetches.Load(), "Shutdown should stop the background refresh")
+}
@@ -0,0 +1,172 @@
+package jwt
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/arqut/common/cache"
+	"github.com/arqut/common/utils"
+)
+
+// jtiLength is the length of the random token ID stamped by IssueJWE and IssueJWS.
+const jtiLength = 24
+
+// DefaultRevocationPrefix namespaces the keys written by CacheRevocationStore.
+const DefaultRevocationPrefix = "jwt:revoked:"
+
+// RevocationStore keeps the IDs of revoked tokens until the tokens expire.
+type RevocationStore interface {
+	// Revoke marks jti as revoked until expiresAt, a zero expiresAt never expires.
+	Revoke(jti string, expiresAt time.Time) error
+	IsRevoked(jti string) (bool, error)
+}
+
+// InMemoryRevocationStore is an in-memory implementation of RevocationStore,
+// only suitable for a single instance.
+type InMemoryRevocationStore struct {
+	mu      sync.RWMutex
+	revoked map[string]time.Time
+}
+
+// NewInMemoryRevocationStore initializes a new in-memory revocation store.
+func NewInMemoryRevocationStore() *InMemoryRevocationStore {
+	return &InMemoryRevocationStore{revoked: make(map[string]time.Time)}
+}
+
+// Revoke marks jti as revoked and drops the entries of expired tokens.
+func (s *InMemoryRevocationStore) Revoke(jti string, expiresAt time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	for id, expiry := range s.revoked {
+		if !expiry.IsZero() && now.After(expiry) {
+			delete(s.revoked, id)
+		}
+	}
+	s.revoked[jti] = expiresAt
+	return nil
+}
+
+// IsRevoked reports whether jti is revoked and its token not expired yet.
+func (s *InMemoryRevocationStore) IsRevoked(jti string) (bool, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	expiry, ok := s.revoked[jti]
+	return ok && (expiry.IsZero() || time.Now().Before(expiry)), nil
+}
+
+// CacheRevocationStore keeps revoked token IDs in Redis through the cache
+// package, so revocations are shared between instances. cache must be
+// initialized before use.
+type CacheRevocationStore struct {
+	prefix string
+}
+
+// NewCacheRevocationStore initializes a new CacheRevocationStore, prefix
+// defaults to DefaultRevocationPrefix.
+func NewCacheRevocationStore(prefix ...string) *CacheRevocationStore {
+	if len(prefix) > 0 {
+		return &CacheRevocationStore{prefix: prefix[0]}
+	}
+	return &CacheRevocationStore{prefix: DefaultRevocationPrefix}
+}
+
+// Revoke stores jti with a TTL ending at the token expiry.
+func (s *CacheRevocationStore) Revoke(jti string, expiresAt time.Time) error {
+	var ttl time.Duration // No expiration
+	if !expiresAt.IsZero() {
+		ttl = time.Until(expiresAt)
+		if ttl <= 0 {
+			return nil
+		}
+	}
+	return cache.Set(s.prefix+jti, "1", ttl)
+}
+
+// IsRevoked reports whether jti is stored.
+func (s *CacheRevocationStore) IsRevoked(jti string) (bool, error) {
+	return cache.Exists(s.prefix + jti)
+}
+
+// Revoke revokes an issued JWE or JWS until its expiry. Tokens already
+// expired or revoked are ignored, the token must be authentic.
+func (km *KeyManager) Revoke(token []byte) error {
+	if km.options.Revocations == nil {
+		return ErrRevocationDisabled
+	}
+
+	var headers Headers
+	var err error
+	if bytes.Count(token, []byte(".")) == 4 {
+		_, headers, err = km.decrypt(token)
+	} else {
+		_, headers, err = km.verify(token)
+	}
+	if errors.Is(err, ErrTokenExpired) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	var jti string
+	if err := headers.Get("jti", &jti); err != nil || jti == "" {
+		return fmt.Errorf("%w: missing jti header", ErrMalformedToken)
+	}
+
+	expiresAt, _, err := timeHeader(headers, "exp")
+	if err != nil {
+		return err
+	}
+
+	return km.RevokeID(jti, expiresAt)
+}
+
+// RevokeID revokes the token with the given jti until expiresAt, a zero
+// expiresAt keeps the revocation forever.
+func (km *KeyManager) RevokeID(jti string, expiresAt time.Time) error {
+	if km.options.Revocations == nil {
+		return ErrRevocationDisabled
+	}
+	if err := km.options.Revocations.Revoke(jti, expiresAt); err != nil {
+		return fmt.Errorf("failed to revoke token: %w", err)
+	}
+	return nil
+}
+
+// checkRevocation rejects authenticated tokens whose jti has been revoked.
+// Tokens without jti cannot be revoked and are accepted.
+func (km *KeyManager) checkRevocation(headers Headers) error {
+	if km.options.Revocations == nil || !headers.Has("jti") {
+		return nil
+	}
+
+	var jti string
+	if err := headers.Get("jti", &jti); err != nil {
+		return fmt.Errorf("%w: invalid jti header: %w", ErrMalformedToken, err)
+	}
+
+	revoked, err := km.options.Revocations.IsRevoked(jti)
+	if err != nil {
+		return fmt.Errorf("failed to check revocation: %w", err)
+	}
+	if revoked {
+		return fmt.Errorf("%w: jti %q", ErrTokenRevoked, jti)
+	}
+
+	return nil
+}
+
+// GenerateTokenID returns a random token ID for the jti header.
+func GenerateTokenID() (string, error) {
+	jti, err := utils.GenerateRandomString(jtiLength)
+	if err != nil {
+		return "", fmt.Errorf("failed to generate jti: %w", err)
+	}
+	return jti, nil
+}
@@ -0,0 +1,110 @@
+package jwt
+
+import (
+	"testing"
+	"time"
+
+	"github.com/lestrrat-go/jwx/v3/jwe"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestKeyManager_IssueJWE_StampsJTI(t *testing.T) {
+	km, err := NewIssuerKeyManager(1*time.Hour, NewInMemoryKeyStore())
+	require.NoError(t, err, "Failed to create KeyManager")
+	defer km.Shutdown()
+
+	jtis := make(map[string]bool)
+	for i := 0; i < 10; i++ {
+		msg, err := jwe.Parse(issueTestToken(t, km, nil))
+		require.NoError(t, err, "Issued token should parse")
+
+		var jti string
+		require.NoError(t, msg.ProtectedHeaders().Get("jti", &jti), "Issued token should have a jti header")
+		assert.Len(t, jti, jtiLength, "jti should have the expected length")
+		jtis[jti] = true
+	}
+	assert.Len(t, jtis, 10, "Every token should have a unique jti")
+}
+
+func TestKeyManager_Revoke(t *testing.T) {
+	store := NewInMemoryKeyStore()
+	revocations := NewInMemoryRevocationStore()
+
+	km, err := NewIssuerKeyManager(1*time.Hour, store, KeyManagerOptions{Revocations: revocations})
+	require.NoError(t, err, "Failed to create KeyManager")
+	defer km.Shutdown()
+
+	validator, err := NewValidationKeyManager(store, KeyManagerOptions{Revocations: revocations})
+	require.NoError(t, err, "Failed to create Validation KeyManager")
+	defer validator.Shutdown()
+
+	revoked := issueTestToken(t, km, &JWEOptions{ExpiresIn: time.Hour})
+	other := issueTestToken(t, km, &JWEOptions{ExpiresIn: time.Hour})
+
+	require.NoError(t, km.Revoke(revoked), "Revoke should not return an error")
+	assert.NoError(t, km.Revoke(revoked), "Revoking twice should not return an error")
+
+	_, err = km.DecryptJWE(revoked)
+	assert.ErrorIs(t, err, ErrTokenRevoked, "Revoked token should be rejected")
+	_, err = validator.DecryptJWE(revoked)
+	assert.ErrorIs(t, err, ErrTokenRevoked, "Validators sharing the revocation store should reject it")
+
+	_, err = km.DecryptJWE(other)
+	assert.NoError(t, err, "Other tokens should stay valid")
+
+	_, err = km.DecryptJWE(tamperedCopy(revoked))
+	assert.ErrorIs(t, err, ErrDecryptionFailed, "Revocation should only be checked on authentic tokens")
+	assert.ErrorIs(t, km.Revoke(tamperedCopy(other)), ErrDecryptionFailed, "Revoke should only accept authentic tokens")
+}
+
+func TestKeyManager_RevokeJWS(t *testing.T) {
+	km, err := NewSigningKeyManager(AlgEdDSA, 1*time.Hour, NewInMemoryKeyStore(), KeyManagerOptions{
+		Revocations: NewInMemoryRevocationStore(),
+	})
+	require.NoError(t, err, "Failed to create signing KeyManager")
+	defer km.Shutdown()
+
+	token, err := km.IssueJWS([]byte("signed payload"), &JWSOptions{ExpiresIn: time.Hour})
+	require.NoError(t, err, "IssueJWS should not return an error")
+
+	require.NoError(t, km.Revoke(token), "Revoke should not return an error")
+	_, err = km.VerifyJWS(token)
+	assert.ErrorIs(t, err, ErrTokenRevoked, "Revoked token should be rejected")
+}
+
+func TestKeyManager_Revoke_WithoutStore(t *testing.T) {
+	km, err := NewIssuerKeyManager(1*time.Hour, NewInMemoryKeyStore())
+	require.NoError(t, err, "Failed to create KeyManager")
+	defer km.Shutdown()
+
+	assert.Error(t, km.Revoke(issueTestToken(t, km, nil)), "Revoke should fail without a revocation store")
+}
+
+func TestInMemoryRevocationStore_Expiry(t *testing.T) {
+	store := NewInMemoryRevocationStore()
+
+	require.NoError(t, store.Revoke("expiring", time.Now().Add(50*time.Millisecond)))
+	require.NoError(t, store.Revoke("forever", time.Time{}))
+
+	revoked, _ := store.IsRevoked("expiring")
+	assert.True(t, revoked, "Entry should be revoked until the token expiry")
+	revoked, _ = store.IsRevoked("unknown")
+	assert.False(t, revoked, "Unknown jti should not be revoked")
+
+	time.Sleep(100 * time.Millisecond)
+	revoked, _ = store.IsRevoked("expiring")
+	assert.False(t, revoked, "Entry should expire with the token")
+	revoked, _ = store.IsRevoked("forever")
+	assert.True(t, revoked, "Entry without expiry should be kept")
+
+	require.NoError(t, store.Revoke("other", time.Time{}))
+	assert.NotContains(t, store.revoked, "expiring", "Expired entries should be dropped")
+}
+
+// tamperedCopy returns a tampered copy of token, see tamperToken.
+func tamperedCopy(token []byte) []byte {
+	copied := append([]byte(nil), token...)
+	tamperToken(copied)
+	return copied
+}
@@ -0,0 +1,115 @@
+package jwt
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/arqut/common/system"
+)
+
+const (
+	// rotationLockName is the name of the lease in stores holding several locks.
+	rotationLockName = "key-rotation"
+	// rotationLockTTL bounds how long a crashed instance can block rotation.
+	rotationLockTTL = 30 * time.Second
+	// rotationLockRetry is the delay between attempts of a new instance
+	// waiting for the lease holder to save the initial key.
+	rotationLockRetry = 100 * time.Millisecond
+)
+
+// RotationLocker is implemented by key stores shared between several issuer
+// instances. Only the instance holding the rotation lease generates a new key,
+// the others load it from the store on their next refresh.
+type RotationLocker interface {
+	// AcquireRotationLock takes the lease for holder, or renews it if holder
+	// already owns it. It returns false while another holder owns an
+	// unexpired lease.
+	AcquireRotationLock(holder string, ttl time.Duration) (bool, error)
+	// ReleaseRotationLock releases the lease if it is owned by holder.
+	ReleaseRotationLock(holder string) error
+}
+
+// KeyRotationLock is the lease row used by GormKeyStore to coordinate rotation.
+type KeyRotationLock struct {
+	Name      string `gorm:"primaryKey"`
+	Holder    string
+	ExpiresAt time.Time
+}
+
+// rotationThreshold is how long before its expiry the current key is rotated.
+func (km *KeyManager) rotationThreshold() time.Duration {
+	return time.Duration(float64(km.rotationPeriod) * 0.1)
+}
+
+// rotationDue reports whether the current key is missing or nearing expiration.
+func (km *KeyManager) rotationDue() bool {
+	km.mu.RLock()
+	defer km.mu.RUnlock()
+
+	return time.Until(km.currentKey.Expiry) <= km.rotationThreshold()
+}
+
+// rotateIfDue rotates the current key when it is nearing expiration. With a
+// RotationLocker store only the lease holder rotates, the other instances
+// pick up the key it saved.
+func (km *KeyManager) rotateIfDue() error {
+	locker, ok := km.store.(RotationLocker)
+	if !ok {
+		if !km.rotationDue() {
+			return nil
+		}
+		return km.rotateKey()
+	}
+
+	// Another instance may have rotated since the last refresh
+	if err := km.loadKeys(); err != nil {
+		return err
+	}
+	if !km.rotationDue() {
+		return nil
+	}
+
+	acquired, err := locker.AcquireRotationLock(km.instanceID, rotationLockTTL)
+	if err != nil {
+		return fmt.Errorf("failed to acquire rotation lock: %w", err)
+	}
+	if !acquired {
+		system.Logger.Infof("Key rotation is handled by another instance.")
+		return nil
+	}
+	defer func() {
+		if err := locker.ReleaseRotationLock(km.instanceID); err != nil {
+			system.Logger.Errorf("Error releasing rotation lock: %v", err)
+		}
+	}()
+
+	// The previous lease holder may have saved a key in the meantime
+	if err := km.loadKeys(); err != nil {
+		return err
+	}
+	if !km.rotationDue() {
+		return nil
+	}
+
+	return km.rotateKey()
+}
+
+// loadKeys replaces the keys of an issuer with the ones of its mode in the
+// store, the key expiring last becomes the current key.
+func (km *KeyManager) loadKeys() error {
+	km.loadMu.Lock()
+	defer km.loadMu.Unlock()
+
+	keys, err := km.store.GetAllKeys()
+	if err != nil {
+		return fmt.Errorf("failed to retrieve keys from store: %w", err)
+	}
+
+	keys = keysForAlgorithm(keys, km.algorithm)
+	if len(keys) == 0 {
+		return nil
+	}
+	km.setKeys(keys)
+
+	return nil
+}
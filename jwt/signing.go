@@ -0,0 +1,261 @@
+package jwt
+
+import (
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/lestrrat-go/jwx/v3/jwa"
+	"github.com/lestrrat-go/jwx/v3/jws"
+)
+
+// Supported signature algorithms for signing mode.
+const (
+	AlgEdDSA = "EdDSA"
+	AlgES256 = "ES256"
+	AlgRS256 = "RS256"
+)
+
+// JWSOptions configures IssueJWS, headers are written to the protected header.
+type JWSOptions = JWEOptions
+
+// NewSigningKeyManager creates a new KeyManager in issuer & validation mode
+// that signs tokens with the given algorithm instead of encrypting them.
+// Private keys stay in the store, validators only need the public keys.
+func NewSigningKeyManager(algorithm string, rotationPeriod time.Duration, store KeyStore, options ...KeyManagerOptions) (*KeyManager, error) {
+	if _, err := signatureAlgorithm(algorithm); err != nil {
+		return nil, err
+	}
+	return newIssuerKeyManager(rotationPeriod, store, algorithm, options...)
+}
+
+// IssueJWS signs payload with the current signing key. The jti, iat and exp
+// claims are written to the protected header and, when payload is a JSON
+// object, to the payload where RFC 7519 validators expect them, without
+// overriding claims it already sets. Other payloads can only be validated by
+// VerifyJWS.
+func (km *KeyManager) IssueJWS(payload []byte, opts *JWSOptions) ([]byte, error) {
+	if km.validationOnly {
+		return nil, fmt.Errorf("IssueJWS %w", ErrValidationOnly)
+	}
+	if km.algorithm == "" {
+		return nil, fmt.Errorf("IssueJWS %w", ErrEncryptionMode)
+	}
+
+	currentKey, err := km.issuingKey()
+	if err != nil {
+		return nil, err
+	}
+
+	alg, err := signatureAlgorithm(currentKey.Algorithm)
+	if err != nil {
+		return nil, err
+	}
+
+	privateKey, err := x509.ParsePKCS8PrivateKey(currentKey.Key)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse signing key: %w", err)
+	}
+
+	jti, err := GenerateTokenID()
+	if err != nil {
+		return nil, err
+	}
+
+	claims := map[string]interface{}{
+		"jti": jti,
+		"iat": time.Now().Unix(),
+	}
+	if opts != nil && opts.ExpiresIn > 0 {
+		claims["exp"] = time.Now().Add(opts.ExpiresIn).Unix()
+	}
+
+	headers := jws.NewHeaders()
+	headers.Set("kid", currentKey.KeyID())
+	for k, v := range claims {
+		headers.Set(k, v)
+	}
+	if opts != nil {
+		for k, v := range opts.Headers {
+			headers.Set(k, v)
+		}
+	}
+
+	payload, err = withPayloadClaims(payload, claims)
+	if err != nil {
+		return nil, err
+	}
+
+	signed, err := jws.Sign(payload, jws.WithKey(alg, privateKey, jws.WithProtectedHeaders(headers)))
+	if err != nil {
+		return nil, fmt.Errorf("failed to sign JWS: %w", err)
+	}
+
+	return signed, nil
+}
+
+// VerifyJWS verifies the signature of a JWS against the public key matching
+// its kid and validates its claims like Decrypt does. The registered claims
+// are read from JSON payloads, falling back to the protected headers.
+func (km *KeyManager) VerifyJWS(token []byte, opts ...ValidatorOption) ([]byte, error) {
+	payload, headers, err := km.verify(token, opts...)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := km.checkRevocation(headers); err != nil {
+		return nil, err
+	}
+
+	return payload, nil
+}
+
+func (km *KeyManager) verify(token []byte, opts ...ValidatorOption) ([]byte, Headers, error) {
+	msg, err := jws.Parse(token)
+	if err != nil {
+		return nil, nil, fmt.Errorf("%w: failed to parse JWS: %w", ErrMalformedToken, err)
+	}
+
+	signatures := msg.Signatures()
+	if len(signatures) != 1 {
+		return nil, nil, fmt.Errorf("%w: expected one signature, got %d", ErrMalformedToken, len(signatures))
+	}
+
+	headers := signatures[0].ProtectedHeaders()
+	kid, ok := headers.KeyID()
+	if !ok {
+		return nil, nil, fmt.Errorf("%w: missing kid header", ErrMalformedToken)
+	}
+
+	keyEntry, err := km.keyForID(kid, true)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	alg, err := verificationAlgorithm(keyEntry.Algorithm)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	publicKey, err := x509.ParsePKIXPublicKey(keyEntry.PublicKey)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to parse public key: %w", err)
+	}
+
+	payload, err := jws.Verify(token, jws.WithKey(alg, publicKey))
+	if err != nil {
+		return nil, nil, fmt.Errorf("%w: %w", ErrInvalidSignature, err)
+	}
+
+	claims := registeredClaims(payload, headers)
+	if err := newValidation(opts...).validate(claims); err != nil {
+		return nil, nil, err
+	}
+
+	return payload, claims, nil
+}
+
+// withPayloadClaims adds claims to payload when it is a JSON object, keeping
+// the values it already sets. Other payloads are returned unchanged.
+func withPayloadClaims(payload []byte, claims map[string]interface{}) ([]byte, error) {
+	var object map[string]json.RawMessage
+	if json.Unmarshal(payload, &object) != nil || object == nil {
+		return payload, nil
+	}
+
+	for name, value := range claims {
+		if _, ok := object[name]; ok {
+			continue
+		}
+		raw, err := json.Marshal(value)
+		if err != nil {
+			return nil, fmt.Errorf("failed to encode %q claim: %w", name, err)
+		}
+		object[name] = raw
+	}
+
+	encoded, err := json.Marshal(object)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode payload: %w", err)
+	}
+	return encoded, nil
+}
+
+func signatureAlgorithm(algorithm string) (jwa.SignatureAlgorithm, error) {
+	switch algorithm {
+	case AlgEdDSA:
+		return jwa.EdDSA(), nil
+	case AlgES256:
+		return jwa.ES256(), nil
+	case AlgRS256:
+		return jwa.RS256(), nil
+	}
+	return jwa.EmptySignatureAlgorithm(), fmt.Errorf("%w: %q", ErrUnsupportedAlgorithm, algorithm)
+}
+
+// verificationAlgorithm resolves the algorithm of a public key. Any asymmetric
+// algorithm is accepted so keys published by third-party JWKS can be used.
+func verificationAlgorithm(algorithm string) (jwa.SignatureAlgorithm, error) {
+	alg, ok := jwa.LookupSignatureAlgorithm(algorithm)
+	if !ok || alg.IsSymmetric() || alg == jwa.NoSignature() {
+		return jwa.EmptySignatureAlgorithm(), fmt.Errorf("%w: %q", ErrUnsupportedAlgorithm, algorithm)
+	}
+	return alg, nil
+}
+
+// generateSigningKey returns a new PKCS #8 private key and its PKIX public key.
+func generateSigningKey(algorithm string) ([]byte, []byte, error) {
+	var privateKey, publicKey interface{}
+	switch algorithm {
+	case AlgEdDSA:
+		pub, priv, err := ed25519.GenerateKey(rand.Reader)
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to generate new key: %w", err)
+		}
+		privateKey, publicKey = priv, pub
+	case AlgES256:
+		priv, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to generate new key: %w", err)
+		}
+		privateKey, publicKey = priv, &priv.PublicKey
+	case AlgRS256:
+		priv, err := rsa.GenerateKey(rand.Reader, 2048)
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to generate new key: %w", err)
+		}
+		privateKey, publicKey = priv, &priv.PublicKey
+	default:
+		return nil, nil, fmt.Errorf("%w: %q", ErrUnsupportedAlgorithm, algorithm)
+	}
+
+	privateBytes, err := x509.MarshalPKCS8PrivateKey(privateKey)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to encode private key: %w", err)
+	}
+
+	publicBytes, err := x509.MarshalPKIXPublicKey(publicKey)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to encode public key: %w", err)
+	}
+
+	return privateBytes, publicBytes, nil
+}
+
+// keysForAlgorithm filters keys down to the ones of the given algorithm,
+// an empty algorithm selects the encryption keys.
+func keysForAlgorithm(keys []KeyEntry, algorithm string) []KeyEntry {
+	filtered := make([]KeyEntry, 0, len(keys))
+	for _, key := range keys {
+		if key.Algorithm == algorithm {
+			filtered = append(filtered, key)
+		}
+	}
+	return filtered
+}
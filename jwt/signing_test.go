@@ -0,0 +1,142 @@
+package jwt
+
+import (
+	"encoding/json"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestKeyManager_IssueAndVerifyJWS(t *testing.T) {
+	for _, alg := range []string{AlgEdDSA, AlgES256, AlgRS256} {
+		t.Run(alg, func(t *testing.T) {
+			km, err := NewSigningKeyManager(alg, 1*time.Hour, NewInMemoryKeyStore())
+			require.NoError(t, err, "Failed to create signing KeyManager")
+			defer km.Shutdown()
+
+			payload := []byte("signed payload")
+			token, err := km.IssueJWS(payload, &JWSOptions{
+				ExpiresIn: 30 * time.Minute,
+				Headers:   map[string]interface{}{"aud": "api"},
+			})
+			require.NoError(t, err, "IssueJWS should not return an error")
+
+			verified, err := km.VerifyJWS(token, WithAudience("api"))
+			assert.NoError(t, err, "VerifyJWS should not return an error")
+			assert.Equal(t, payload, verified, "Verified payload should match original payload")
+
+			_, err = km.VerifyJWS(token, WithAudience("web"))
+			assert.ErrorIs(t, err, ErrAudienceMismatch, "VerifyJWS should run validators")
+		})
+	}
+}
+
+func TestKeyManager_VerifyJWS_PublicKeysOnly(t *testing.T) {
+	issuerStore := NewInMemoryKeyStore()
+	km, err := NewSigningKeyManager(AlgEdDSA, 1*time.Hour, issuerStore)
+	require.NoError(t, err, "Failed to create signing KeyManager")
+	defer km.Shutdown()
+
+	token, err := km.IssueJWS([]byte("signed payload"), nil)
+	require.NoError(t, err, "IssueJWS should not return an error")
+
+	// Validators only receive the public part of the keys
+	keys, err := issuerStore.GetAllKeys()
+	require.NoError(t, err, "GetAllKeys should not return an error")
+	validatorStore := NewInMemoryKeyStore()
+	for _, key := range keys {
+		key.Key = nil
+		validatorStore.SaveKey(key)
+	}
+
+	validator, err := NewValidationKeyManager(validatorStore)
+	require.NoError(t, err, "Failed to create Validation KeyManager")
+
+	verified, err := validator.VerifyJWS(token)
+	assert.NoError(t, err, "VerifyJWS should only need the public key")
+	assert.Equal(t, []byte("signed payload"), verified, "Verified payload should match original payload")
+
+	_, err = validator.IssueJWS([]byte("signed payload"), nil)
+	assert.ErrorIs(t, err, ErrValidationOnly, "IssueJWS should return an error in validation-only mode")
+}
+
+func TestKeyManager_VerifyJWS_TamperedSignature(t *testing.T) {
+	km, err := NewSigningKeyManager(AlgES256, 1*time.Hour, NewInMemoryKeyStore())
+	require.NoError(t, err, "Failed to create signing KeyManager")
+	defer km.Shutdown()
+
+	token, err := km.IssueJWS([]byte("signed payload"), nil)
+	require.NoError(t, err, "IssueJWS should not return an error")
+
+	tamperToken(token)
+	_, err = km.VerifyJWS(token)
+	assert.ErrorIs(t, err, ErrInvalidSignature, "VerifyJWS should reject a tampered signature")
+}
+
+func TestKeyManager_SigningModeMismatch(t *testing.T) {
+	_, err := NewSigningKeyManager("HS256", 1*time.Hour, NewInMemoryKeyStore())
+	assert.ErrorIs(t, err, ErrUnsupportedAlgorithm, "NewSigningKeyManager should reject unsupported algorithms")
+
+	signer, err := NewSigningKeyManager(AlgEdDSA, 1*time.Hour, NewInMemoryKeyStore())
+	require.NoError(t, err, "Failed to create signing KeyManager")
+	defer signer.Shutdown()
+
+	_, err = signer.IssueJWE([]byte("payload"), nil)
+	assert.ErrorIs(t, err, ErrSigningMode, "IssueJWE should return an error in signing mode")
+
+	encrypter, err := NewIssuerKeyManager(1*time.Hour, NewInMemoryKeyStore())
+	require.NoError(t, err, "Failed to create KeyManager")
+	defer encrypter.Shutdown()
+
+	_, err = encrypter.IssueJWS([]byte("payload"), nil)
+	assert.ErrorIs(t, err, ErrEncryptionMode, "IssueJWS should return an error in encryption mode")
+}
+
+func TestGetCurrentKeysAPIHandler_SigningKeys(t *testing.T) {
+	km, err := NewSigningKeyManager(AlgEdDSA, 1*time.Hour, NewInMemoryKeyStore())
+	require.NoError(t, err, "Failed to create signing KeyManager")
+	defer km.Shutdown()
+
+	app := fiber.New()
+	app.Get("/keys", km.GetCurrentKeysAPIHandler)
+
+	resp, err := app.Test(httptest.NewRequest("GET", "/keys", nil))
+	require.NoError(t, err, "Request should not fail")
+	defer resp.Body.Close()
+
+	var keys []KeyResponse
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&keys), "Response should be valid JSON")
+	require.Len(t, keys, 1, "Handler should publish the current key")
+	assert.Empty(t, keys[0].Key, "Handler should not publish private keys")
+	assert.NotEmpty(t, keys[0].PublicKey, "Handler should publish the public key")
+	assert.Equal(t, AlgEdDSA, keys[0].Algorithm, "Handler should publish the key algorithm")
+}
+
+func TestKeyManager_IssueJWS_PayloadClaims(t *testing.T) {
+	km, err := NewSigningKeyManager(AlgEdDSA, 1*time.Hour, NewInMemoryKeyStore())
+	require.NoError(t, err, "Failed to create signing KeyManager")
+	defer km.Shutdown()
+
+	token, err := km.IssueJWS([]byte(`{"sub":"user-1","iat":1}`), &JWSOptions{ExpiresIn: 30 * time.Minute})
+	require.NoError(t, err, "IssueJWS should not return an error")
+
+	payload, err := km.VerifyJWS(token)
+	require.NoError(t, err, "VerifyJWS should not return an error")
+
+	var claims map[string]interface{}
+	require.NoError(t, json.Unmarshal(payload, &claims), "Payload should stay a JSON object")
+	assert.Equal(t, "user-1", claims["sub"], "Payload claims should be kept")
+	assert.Equal(t, float64(1), claims["iat"], "Claims set by the payload should not be overridden")
+	assert.NotEmpty(t, claims["jti"], "jti should be written to the payload")
+	assert.InDelta(t, time.Now().Add(30*time.Minute).Unix(), claims["exp"], 5, "exp should be written to the payload")
+
+	token, err = km.IssueJWS([]byte("signed payload"), nil)
+	require.NoError(t, err, "IssueJWS should not return an error")
+	payload, err = km.VerifyJWS(token)
+	require.NoError(t, err, "VerifyJWS should not return an error")
+	assert.Equal(t, []byte("signed payload"), payload, "Other payloads should not be changed")
+}
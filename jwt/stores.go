@@ -11,26 +11,54 @@ import (
 	"time"
 
 	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
 )
 
-// KeyEntry represents an encryption key with associated metadata.
+// KeyEntry represents an encryption or signing key with associated metadata.
+// Signing keys hold the PKCS #8 private key in Key and the PKIX public key in
+// PublicKey, validators only need the latter.
 type KeyEntry struct {
-	ID     uint `gorm:"primaryKey"` // For GORM
-	Key    []byte
-	Info   []byte
-	Expiry time.Time
+	ID        uint `gorm:"primaryKey"` // For GORM
+	Key       []byte
+	Info      []byte
+	Expiry    time.Time
+	Algorithm string // Signature algorithm, empty for encryption keys
+	PublicKey []byte
+	Kid       string // Key ID of keys from external sources, see KeyID
+}
+
+// KeyID returns the kid identifying the key in token headers. Unless set
+// explicitly it is the base64 encoded Info.
+func (e KeyEntry) KeyID() string {
+	if e.Kid != "" {
+		return e.Kid
+	}
+	return base64.StdEncoding.EncodeToString(e.Info)
 }
 
 // KeyStore defines methods for persisting and retrieving key entries.
 type KeyStore interface {
 	SaveKey(entry KeyEntry) error
+	// GetAllKeys returns the keys ordered by Expiry ascending, the last one
+	// is the current key. Keys with the same Expiry keep the order of the
+	// underlying source.
 	GetAllKeys() ([]KeyEntry, error)
 }
 
+// sortKeys orders keys as required by KeyStore.GetAllKeys.
+func sortKeys(keys []KeyEntry) {
+	sort.SliceStable(keys, func(i, j int) bool {
+		return keys[i].Expiry.Before(keys[j].Expiry)
+	})
+}
+
 // InMemoryKeyStore is an in-memory implementation of KeyStore.
 type InMemoryKeyStore struct {
 	mu   sync.RWMutex
 	keys []KeyEntry
+
+	lockHolder string
+	lockExpiry time.Time
 }
 
 // NewInMemoryKeyStore initializes a new in-memory key store.
@@ -52,21 +80,58 @@ func (s *InMemoryKeyStore) GetAllKeys() ([]KeyEntry, error) {
 	defer s.mu.RUnlock()
 	copied := make([]KeyEntry, len(s.keys))
 	copy(copied, s.keys)
-	sort.Slice(copied[:], func(i, j int) bool {
-		return copied[i].Expiry.Before(copied[j].Expiry)
-	})
+	sortKeys(copied)
 	return copied, nil
 }
 
+// AcquireRotationLock takes the rotation lease for holder, see RotationLocker.
+func (s *InMemoryKeyStore) AcquireRotationLock(holder string, ttl time.Duration) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.lockHolder != "" && s.lockHolder != holder && time.Now().Before(s.lockExpiry) {
+		return false, nil
+	}
+	s.lockHolder = holder
+	s.lockExpiry = time.Now().Add(ttl)
+	return true, nil
+}
+
+// ReleaseRotationLock releases the rotation lease if it is owned by holder.
+func (s *InMemoryKeyStore) ReleaseRotationLock(holder string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.lockHolder == holder {
+		s.lockHolder = ""
+	}
+	return nil
+}
+
 // GormKeyStore uses GORM to persist key entries.
 type GormKeyStore struct {
 	db *gorm.DB
+
+	retentionMu  sync.RWMutex
+	historyDepth int
+	gracePeriod  time.Duration
 }
 
-// NewGormKeyStore initializes a new GormKeyStore and migrates the KeyEntry schema.
+// NewGormKeyStore initializes a new GormKeyStore and migrates the KeyEntry and
+// KeyRotationLock schemas.
 func NewGormKeyStore(db *gorm.DB) *GormKeyStore {
-	db.AutoMigrate(&KeyEntry{})
-	return &GormKeyStore{db: db}
+	db.AutoMigrate(&KeyEntry{}, &KeyRotationLock{})
+	return &GormKeyStore{db: db, historyDepth: DefaultHistoryDepth}
+}
+
+// SetRetention limits GetAllKeys to the current key plus historyDepth previous
+// keys of each algorithm, skipping the ones expired for longer than gracePeriod. It is safe to
+// call concurrently with GetAllKeys. A store has a single retention, so every
+// KeyManager sharing it should use the same options: the last one created
+// configures it for all of them.
+func (s *GormKeyStore) SetRetention(historyDepth int, gracePeriod time.Duration) {
+	s.retentionMu.Lock()
+	defer s.retentionMu.Unlock()
+	s.historyDepth = historyDepth
+	s.gracePeriod = gracePeriod
 }
 
 // SaveKey saves a key entry using GORM.
@@ -74,11 +139,63 @@ func (s *GormKeyStore) SaveKey(entry KeyEntry) error {
 	return s.db.Create(&entry).Error
 }
 
-// GetAllKeys retrieves all saved key entries using GORM.
+// GetAllKeys retrieves the most recent key entries of each algorithm within
+// the retention using GORM, so encryption and signing issuers sharing the
+// database do not evict each other's keys.
 func (s *GormKeyStore) GetAllKeys() ([]KeyEntry, error) {
+	s.retentionMu.RLock()
+	historyDepth, gracePeriod := s.historyDepth, s.gracePeriod
+	s.retentionMu.RUnlock()
+
+	var algorithms []string
+	if err := s.db.Model(&KeyEntry{}).Distinct("algorithm").Pluck("algorithm", &algorithms).Error; err != nil {
+		return nil, err
+	}
+
 	var keys []KeyEntry
-	err := s.db.Order("expiry DESC").Limit(3).Find(&keys).Error
-	return keys, err
+	for _, algorithm := range algorithms {
+		var entries []KeyEntry
+		query := s.db.Where("algorithm = ?", algorithm).Order("expiry DESC").Limit(historyDepth + 1)
+		if gracePeriod > 0 {
+			query = query.Where("expiry > ?", time.Now().Add(-gracePeriod))
+		}
+		if err := query.Find(&entries).Error; err != nil {
+			return nil, err
+		}
+		keys = append(keys, entries...)
+	}
+	sortKeys(keys)
+	return keys, nil
+}
+
+// AcquireRotationLock takes the rotation lease for holder, see RotationLocker.
+// Both statements are atomic so concurrent instances cannot both succeed.
+func (s *GormKeyStore) AcquireRotationLock(holder string, ttl time.Duration) (bool, error) {
+	now := time.Now()
+	lock := KeyRotationLock{Name: rotationLockName, Holder: holder, ExpiresAt: now.Add(ttl)}
+
+	// Create the lease if nobody took it yet
+	result := s.db.Clauses(clause.OnConflict{DoNothing: true}).Create(&lock)
+	if result.Error != nil {
+		return false, result.Error
+	}
+	if result.RowsAffected == 1 {
+		return true, nil
+	}
+
+	// Otherwise take it over if it expired, or renew it if it is ours
+	result = s.db.Model(&KeyRotationLock{}).
+		Where("name = ? AND (holder = ? OR expires_at < ?)", rotationLockName, holder, now).
+		Updates(map[string]interface{}{"holder": holder, "expires_at": lock.ExpiresAt})
+	if result.Error != nil {
+		return false, result.Error
+	}
+	return result.RowsAffected == 1, nil
+}
+
+// ReleaseRotationLock releases the rotation lease if it is owned by holder.
+func (s *GormKeyStore) ReleaseRotationLock(holder string) error {
+	return s.db.Where("name = ? AND holder = ?", rotationLockName, holder).Delete(&KeyRotationLock{}).Error
 }
 
 type RemoteStore struct {
@@ -128,14 +245,14 @@ func (rs *RemoteStore) GetAllKeys() ([]KeyEntry, error) {
 	// Create a new request to allow setting the Authorization header
 	req, err := http.NewRequest("GET", rs.remoteURL, nil)
 	if err != nil {
-		return nil, fmt.Errorf("failed to create new request: %v", err)
+		return nil, fmt.Errorf("failed to create new request: %w", err)
 	}
 	// Set the API key as a Bearer token in the Authorization header
 	req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", rs.apiKey))
 
 	resp, err := rs.client.Do(req)
 	if err != nil {
-		return nil, fmt.Errorf("failed to fetch keys from remote service: %v", err)
+		return nil, fmt.Errorf("failed to fetch keys from remote service: %w", err)
 	}
 	defer resp.Body.Close()
 
@@ -146,12 +263,12 @@ func (rs *RemoteStore) GetAllKeys() ([]KeyEntry, error) {
 
 	bodyBytes, err := io.ReadAll(resp.Body)
 	if err != nil {
-		return nil, fmt.Errorf("failed to read response body: %v", err)
+		return nil, fmt.Errorf("failed to read response body: %w", err)
 	}
 
 	var keyResponses []KeyResponse
 	if err := json.Unmarshal(bodyBytes, &keyResponses); err != nil {
-		return nil, fmt.Errorf("failed to parse JSON response: %v", err)
+		return nil, fmt.Errorf("failed to parse JSON response: %w", err)
 	}
 
 	// Convert KeyResponse to KeyEntry
@@ -159,22 +276,35 @@ func (rs *RemoteStore) GetAllKeys() ([]KeyEntry, error) {
 	for _, kr := range keyResponses {
 		keyBytes, err := base64.StdEncoding.DecodeString(kr.Key)
 		if err != nil {
-			return nil, fmt.Errorf("failed to decode key: %v", err)
+			return nil, fmt.Errorf("failed to decode key: %w", err)
 		}
 
 		infoBytes, err := base64.StdEncoding.DecodeString(kr.Info)
 		if err != nil {
-			return nil, fmt.Errorf("failed to decode info: %v", err)
+			return nil, fmt.Errorf("failed to decode info: %w", err)
+		}
+
+		var publicKeyBytes []byte
+		if kr.PublicKey != "" {
+			publicKeyBytes, err = base64.StdEncoding.DecodeString(kr.PublicKey)
+			if err != nil {
+				return nil, fmt.Errorf("failed to decode public key: %w", err)
+			}
 		}
 
 		fetchedKeys = append(fetchedKeys, KeyEntry{
-			ID:     kr.ID,
-			Key:    keyBytes,
-			Info:   infoBytes,
-			Expiry: kr.Expiry,
+			ID:        kr.ID,
+			Key:       keyBytes,
+			Info:      infoBytes,
+			Expiry:    kr.Expiry,
+			Algorithm: kr.Algorithm,
+			PublicKey: publicKeyBytes,
+			Kid:       kr.Kid,
 		})
 	}
 
+	sortKeys(fetchedKeys)
+
 	// Update cache
 	rs.cacheMu.Lock()
 	rs.cache = fetchedKeys
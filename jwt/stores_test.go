@@ -301,3 +301,117 @@ func TestRemoteStore_GetAllKeys_CacheBehavior(t *testing.T) {
 //     // Wait for all goroutines to finish
 //     wg.Wait()
 // }
+
+func TestGormKeyStore_SetRetention(t *testing.T) {
+	db, err := gorm.Open(sqlite.Open(":memory:"), &gorm.Config{})
+	assert.NoError(t, err, "Should connect to in-memory SQLite without error")
+
+	store := NewGormKeyStore(db)
+	now := time.Now()
+	store.SaveKey(KeyEntry{Key: []byte("expired"), Info: []byte("expired"), Expiry: now.Add(-2 * time.Hour)})
+	for i := 1; i <= 5; i++ {
+		store.SaveKey(KeyEntry{
+			Key:    []byte("key" + strconv.Itoa(i)),
+			Info:   []byte("info" + strconv.Itoa(i)),
+			Expiry: now.Add(time.Duration(i) * time.Hour),
+		})
+	}
+
+	keys, err := store.GetAllKeys()
+	assert.NoError(t, err, "GetAllKeys should not return an error")
+	assert.Len(t, keys, DefaultHistoryDepth+1, "Store should default to DefaultHistoryDepth previous keys")
+
+	store.SetRetention(10, time.Hour)
+	keys, err = store.GetAllKeys()
+	assert.NoError(t, err, "GetAllKeys should not return an error")
+	assert.Len(t, keys, 5, "Keys expired past the grace period should be skipped")
+
+	// The manager configures the store from its options
+	km, err := NewIssuerKeyManager(24*time.Hour, store, KeyManagerOptions{HistoryDepth: 3})
+	assert.NoError(t, err, "Failed to create KeyManager")
+	defer km.Shutdown()
+	assert.Equal(t, 3, store.historyDepth, "KeyManager should apply its history depth to the store")
+	assert.Equal(t, time.Duration(0), store.gracePeriod, "KeyManager should apply its grace period to the store")
+
+	// Managers sharing the store configure it while others refresh their keys
+	validator, err := NewValidationKeyManager(store, KeyManagerOptions{HistoryDepth: 3, RefreshInterval: time.Millisecond})
+	assert.NoError(t, err, "Failed to create Validation KeyManager")
+	defer validator.Shutdown()
+	for i := 0; i < 20; i++ {
+		store.SetRetention(3, 0)
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestGormKeyStore_MixedModes(t *testing.T) {
+	db, err := gorm.Open(sqlite.Open(":memory:"), &gorm.Config{})
+	assert.NoError(t, err, "Should connect to in-memory SQLite without error")
+
+	store := NewGormKeyStore(db)
+	options := KeyManagerOptions{HistoryDepth: 2}
+	encrypting, err := NewIssuerKeyManager(24*time.Hour, store, options)
+	assert.NoError(t, err, "Failed to create KeyManager")
+	defer encrypting.Shutdown()
+	signing, err := NewSigningKeyManager(AlgES256, 24*time.Hour, store, options)
+	assert.NoError(t, err, "Failed to create signing KeyManager")
+	defer signing.Shutdown()
+
+	encrypted, err := encrypting.IssueJWE([]byte("encrypted payload"), nil)
+	assert.NoError(t, err, "IssueJWE should not return an error")
+	signed, err := signing.IssueJWS([]byte("signed payload"), nil)
+	assert.NoError(t, err, "IssueJWS should not return an error")
+
+	// Both tokens stay within HistoryDepth of their own mode
+	assert.NoError(t, encrypting.rotateKey(), "rotateKey should not return an error")
+	for i := 0; i < 2; i++ {
+		assert.NoError(t, signing.rotateKey(), "rotateKey should not return an error")
+	}
+
+	keys, err := store.GetAllKeys()
+	assert.NoError(t, err, "GetAllKeys should not return an error")
+	assert.Len(t, keysForAlgorithm(keys, ""), 2, "Signing keys should not evict encryption keys")
+	assert.Len(t, keysForAlgorithm(keys, AlgES256), 3, "Signing keys should be limited to their own history")
+
+	validator, err := NewValidationKeyManager(store, options)
+	assert.NoError(t, err, "Failed to create Validation KeyManager")
+	defer validator.Shutdown()
+
+	decrypted, err := validator.Decrypt(encrypted)
+	assert.NoError(t, err, "Encrypted tokens should be decrypted within the history of their mode")
+	assert.Equal(t, []byte("encrypted payload"), decrypted, "Decrypted payload should match original payload")
+	verified, err := validator.VerifyJWS(signed)
+	assert.NoError(t, err, "Signed tokens should be verified within the history of their mode")
+	assert.Equal(t, []byte("signed payload"), verified, "Verified payload should match original payload")
+
+	assert.NoError(t, encrypting.loadKeys(), "loadKeys should not return an error")
+	_, err = encrypting.Decrypt(encrypted)
+	assert.NoError(t, err, "Issuers should keep their history after reloading a shared store")
+}
+
+func TestGormKeyStore_RotationLock(t *testing.T) {
+	db, err := gorm.Open(sqlite.Open(":memory:"), &gorm.Config{})
+	assert.NoError(t, err, "Should connect to in-memory SQLite without error")
+
+	store := NewGormKeyStore(db)
+
+	acquired, err := store.AcquireRotationLock("replica-a", time.Minute)
+	assert.NoError(t, err, "AcquireRotationLock should not return an error")
+	assert.True(t, acquired, "First holder should take the lease")
+
+	acquired, _ = store.AcquireRotationLock("replica-b", time.Minute)
+	assert.False(t, acquired, "Lease should not be taken while held")
+
+	acquired, _ = store.AcquireRotationLock("replica-a", time.Minute)
+	assert.True(t, acquired, "Holder should renew its own lease")
+
+	assert.NoError(t, store.ReleaseRotationLock("replica-b"), "ReleaseRotationLock should not return an error")
+	acquired, _ = store.AcquireRotationLock("replica-b", time.Minute)
+	assert.False(t, acquired, "Only the holder should release the lease")
+
+	assert.NoError(t, store.ReleaseRotationLock("replica-a"), "ReleaseRotationLock should not return an error")
+	acquired, _ = store.AcquireRotationLock("replica-b", -time.Second)
+	assert.True(t, acquired, "Released lease should be taken")
+
+	acquired, _ = store.AcquireRotationLock("replica-a", time.Minute)
+	assert.True(t, acquired, "Expired lease should be taken over")
+}
@@ -0,0 +1,279 @@
+package jwt
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+)
+
+// Headers gives read access to the protected headers of a token.
+type Headers interface {
+	Get(string, interface{}) error
+	Has(string) bool
+}
+
+// Validator checks the protected headers of a token and returns an error
+// if the token must be rejected.
+type Validator func(headers Headers) error
+
+// ValidatorOption configures the checks performed by KeyManager.Decrypt.
+type ValidatorOption func(*validation)
+
+type validation struct {
+	clock         func() time.Time
+	leeway        time.Duration
+	requireExp    bool
+	issuedAtSkew  time.Duration
+	checkIssuedAt bool
+	validators    []Validator
+}
+
+// WithClock overrides the time source used for exp, nbf and iat checks.
+func WithClock(clock func() time.Time) ValidatorOption {
+	return func(v *validation) {
+		v.clock = clock
+	}
+}
+
+// WithLeeway allows exp and nbf to be off by the given duration to account
+// for clock drift between issuer and validator.
+func WithLeeway(leeway time.Duration) ValidatorOption {
+	return func(v *validation) {
+		v.leeway = leeway
+	}
+}
+
+// WithRequiredExpiration rejects tokens without an exp header.
+func WithRequiredExpiration() ValidatorOption {
+	return func(v *validation) {
+		v.requireExp = true
+	}
+}
+
+// WithIssuedAtSkew rejects tokens whose iat is further in the future than skew.
+func WithIssuedAtSkew(skew time.Duration) ValidatorOption {
+	return func(v *validation) {
+		v.checkIssuedAt = true
+		v.issuedAtSkew = skew
+	}
+}
+
+// WithAudience requires the aud header, a single string or a list, to
+// contain at least one of the given audiences.
+func WithAudience(audiences ...string) ValidatorOption {
+	return WithValidator(func(headers Headers) error {
+		values, err := stringListHeader(headers, "aud")
+		if err != nil {
+			return fmt.Errorf("%w: %v", ErrAudienceMismatch, err)
+		}
+		for _, value := range values {
+			for _, audience := range audiences {
+				if value == audience {
+					return nil
+				}
+			}
+		}
+		return ErrAudienceMismatch
+	})
+}
+
+// WithIssuer requires the iss header to equal issuer.
+func WithIssuer(issuer string) ValidatorOption {
+	return WithValidator(func(headers Headers) error {
+		var iss string
+		if err := headers.Get("iss", &iss); err != nil || iss != issuer {
+			return ErrIssuerMismatch
+		}
+		return nil
+	})
+}
+
+// WithSubject requires the sub header to equal subject.
+func WithSubject(subject string) ValidatorOption {
+	return WithValidator(func(headers Headers) error {
+		var sub string
+		if err := headers.Get("sub", &sub); err != nil || sub != subject {
+			return ErrSubjectMismatch
+		}
+		return nil
+	})
+}
+
+// WithHeader requires the named header to be present and accepted by predicate.
+func WithHeader(name string, predicate func(value interface{}) bool) ValidatorOption {
+	return WithValidator(func(headers Headers) error {
+		var value interface{}
+		if err := headers.Get(name, &value); err != nil {
+			return fmt.Errorf("%w: missing %q header", ErrInvalidClaim, name)
+		}
+		if !predicate(value) {
+			return fmt.Errorf("%w: %q header rejected", ErrInvalidClaim, name)
+		}
+		return nil
+	})
+}
+
+// WithValidator adds a custom validator that runs after the built-in checks.
+func WithValidator(validator Validator) ValidatorOption {
+	return func(v *validation) {
+		v.validators = append(v.validators, validator)
+	}
+}
+
+func newValidation(opts ...ValidatorOption) *validation {
+	v := &validation{
+		clock: time.Now,
+	}
+	for _, opt := range opts {
+		opt(v)
+	}
+	return v
+}
+
+// validate runs the time based checks followed by the configured validators.
+// exp and nbf are always enforced when present.
+func (v *validation) validate(headers Headers) error {
+	now := v.clock()
+
+	exp, ok, err := timeHeader(headers, "exp")
+	if err != nil {
+		return err
+	}
+	if ok {
+		if now.After(exp.Add(v.leeway)) {
+			return ErrTokenExpired
+		}
+	} else if v.requireExp {
+		return fmt.Errorf("%w: missing \"exp\" header", ErrInvalidClaim)
+	}
+
+	nbf, ok, err := timeHeader(headers, "nbf")
+	if err != nil {
+		return err
+	}
+	if ok && now.Add(v.leeway).Before(nbf) {
+		return ErrTokenNotYetValid
+	}
+
+	if v.checkIssuedAt {
+		iat, ok, err := timeHeader(headers, "iat")
+		if err != nil {
+			return err
+		}
+		if ok && iat.After(now.Add(v.issuedAtSkew)) {
+			return ErrIssuedAtInFuture
+		}
+	}
+
+	for _, validator := range v.validators {
+		if err := validator(headers); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// registeredNames are the RFC 7519 registered claim names.
+var registeredNames = map[string]bool{
+	"iss": true, "sub": true, "aud": true, "exp": true, "nbf": true, "iat": true, "jti": true,
+}
+
+// payloadClaims reads the registered claims from a JSON payload and any other
+// name, or registered claims the payload does not set, from the protected
+// headers.
+type payloadClaims struct {
+	claims  map[string]json.RawMessage
+	headers Headers
+}
+
+// registeredClaims returns the Headers validated for a signed payload, the
+// headers themselves unless payload is a JSON object.
+func registeredClaims(payload []byte, headers Headers) Headers {
+	var object map[string]json.RawMessage
+	if json.Unmarshal(payload, &object) != nil || object == nil {
+		return headers
+	}
+
+	claims := make(map[string]json.RawMessage)
+	for name, value := range object {
+		if registeredNames[name] {
+			claims[name] = value
+		}
+	}
+	return payloadClaims{claims: claims, headers: headers}
+}
+
+func (p payloadClaims) Has(name string) bool {
+	if _, ok := p.claims[name]; ok {
+		return true
+	}
+	return p.headers.Has(name)
+}
+
+func (p payloadClaims) Get(name string, value interface{}) error {
+	if raw, ok := p.claims[name]; ok {
+		return json.Unmarshal(raw, value)
+	}
+	return p.headers.Get(name, value)
+}
+
+// timeHeader reads a NumericDate header. The boolean reports whether the
+// header was present.
+func timeHeader(headers Headers, name string) (time.Time, bool, error) {
+	if !headers.Has(name) {
+		return time.Time{}, false, nil
+	}
+
+	var value interface{}
+	if err := headers.Get(name, &value); err != nil {
+		return time.Time{}, false, fmt.Errorf("%w: %q header: %v", ErrInvalidClaim, name, err)
+	}
+
+	var seconds int64
+	switch n := value.(type) {
+	case float64:
+		seconds = int64(n)
+	case int64:
+		seconds = n
+	case int:
+		seconds = int64(n)
+	case json.Number:
+		i, err := n.Int64()
+		if err != nil {
+			return time.Time{}, false, fmt.Errorf("%w: %q header: %v", ErrInvalidClaim, name, err)
+		}
+		seconds = i
+	default:
+		return time.Time{}, false, fmt.Errorf("%w: %q header is not a number", ErrInvalidClaim, name)
+	}
+
+	return time.Unix(seconds, 0), true, nil
+}
+
+// stringListHeader reads a header that holds either a string or a list of strings.
+func stringListHeader(headers Headers, name string) ([]string, error) {
+	var value interface{}
+	if err := headers.Get(name, &value); err != nil {
+		return nil, fmt.Errorf("missing %q header", name)
+	}
+
+	switch v := value.(type) {
+	case string:
+		return []string{v}, nil
+	case []string:
+		return v, nil
+	case []interface{}:
+		values := make([]string, 0, len(v))
+		for _, item := range v {
+			s, ok := item.(string)
+			if !ok {
+				return nil, fmt.Errorf("%q header contains a non-string value", name)
+			}
+			values = append(values, s)
+		}
+		return values, nil
+	default:
+		return nil, fmt.Errorf("%q header is not a string or list of strings", name)
+	}
+}
@@ -0,0 +1,143 @@
+package jwt
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func issueTestToken(t *testing.T, km *KeyManager, opts *JWEOptions) []byte {
+	token, err := km.IssueJWE([]byte("validated payload"), opts)
+	require.NoError(t, err, "IssueJWE should not return an error")
+	return token
+}
+
+func TestKeyManager_Decrypt_Validators(t *testing.T) {
+	km, err := NewIssuerKeyManager(1*time.Hour, NewInMemoryKeyStore())
+	require.NoError(t, err, "Failed to create KeyManager")
+	defer km.Shutdown()
+
+	token := issueTestToken(t, km, &JWEOptions{
+		ExpiresIn: 30 * time.Minute,
+		Headers: map[string]interface{}{
+			"aud":  []string{"api", "web"},
+			"iss":  "arqut",
+			"sub":  "user-1",
+			"role": "admin",
+		},
+	})
+
+	errCustom := errors.New("custom")
+
+	tests := []struct {
+		name    string
+		opts    []ValidatorOption
+		wantErr error
+	}{
+		{"No Validators", nil, nil},
+		{"Audience In List", []ValidatorOption{WithAudience("web")}, nil},
+		{"Any Audience Matches", []ValidatorOption{WithAudience("mobile", "api")}, nil},
+		{"Audience Mismatch", []ValidatorOption{WithAudience("mobile")}, ErrAudienceMismatch},
+		{"Issuer", []ValidatorOption{WithIssuer("arqut")}, nil},
+		{"Issuer Mismatch", []ValidatorOption{WithIssuer("other")}, ErrIssuerMismatch},
+		{"Subject", []ValidatorOption{WithSubject("user-1")}, nil},
+		{"Subject Mismatch", []ValidatorOption{WithSubject("user-2")}, ErrSubjectMismatch},
+		{"Required Expiration", []ValidatorOption{WithRequiredExpiration()}, nil},
+		{"Expired By Clock", []ValidatorOption{WithClock(func() time.Time { return time.Now().Add(time.Hour) })}, ErrTokenExpired},
+		{"Expired Within Leeway", []ValidatorOption{
+			WithClock(func() time.Time { return time.Now().Add(31 * time.Minute) }),
+			WithLeeway(5 * time.Minute),
+		}, nil},
+		{"Issued In Future", []ValidatorOption{
+			WithClock(func() time.Time { return time.Now().Add(-10 * time.Minute) }),
+			WithIssuedAtSkew(time.Minute),
+		}, ErrIssuedAtInFuture},
+		{"Header Predicate", []ValidatorOption{WithHeader("role", func(v interface{}) bool { return v == "admin" })}, nil},
+		{"Header Predicate Rejects", []ValidatorOption{WithHeader("role", func(v interface{}) bool { return v == "user" })}, ErrInvalidClaim},
+		{"Missing Header", []ValidatorOption{WithHeader("tenant", func(v interface{}) bool { return true })}, ErrInvalidClaim},
+		{"Custom Validator", []ValidatorOption{WithValidator(func(h Headers) error { return errCustom })}, errCustom},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			decrypted, err := km.Decrypt(token, tt.opts...)
+			if tt.wantErr != nil {
+				assert.ErrorIs(t, err, tt.wantErr, "Decrypt should return the expected error")
+				assert.Nil(t, decrypted, "Decrypted payload should be nil on validation failure")
+				return
+			}
+			assert.NoError(t, err, "Decrypt should not return an error")
+			assert.Equal(t, []byte("validated payload"), decrypted, "Decrypted payload should match original payload")
+		})
+	}
+}
+
+func TestKeyManager_Decrypt_NotBefore(t *testing.T) {
+	km, err := NewIssuerKeyManager(1*time.Hour, NewInMemoryKeyStore())
+	require.NoError(t, err, "Failed to create KeyManager")
+	defer km.Shutdown()
+
+	token := issueTestToken(t, km, &JWEOptions{
+		Headers: map[string]interface{}{
+			"nbf": time.Now().Add(10 * time.Minute).Unix(),
+		},
+	})
+
+	_, err = km.Decrypt(token)
+	assert.ErrorIs(t, err, ErrTokenNotYetValid, "Decrypt should reject a token before nbf")
+
+	_, err = km.Decrypt(token, WithRequiredExpiration())
+	assert.ErrorIs(t, err, ErrInvalidClaim, "Decrypt should reject a token without exp when required")
+
+	_, err = km.Decrypt(token, WithClock(func() time.Time { return time.Now().Add(11 * time.Minute) }))
+	assert.NoError(t, err, "Decrypt should accept a token after nbf")
+}
+
+// tamperToken changes one character of the last token segment while keeping it
+// valid base64url, so the token still parses but fails authentication.
+func tamperToken(token []byte) {
+	if token[len(token)-2] == 'A' {
+		token[len(token)-2] = 'B'
+	} else {
+		token[len(token)-2] = 'A'
+	}
+}
+
+func TestKeyManager_Decrypt_Tampered(t *testing.T) {
+	km, err := NewIssuerKeyManager(1*time.Hour, NewInMemoryKeyStore())
+	require.NoError(t, err, "Failed to create KeyManager")
+	defer km.Shutdown()
+
+	token := issueTestToken(t, km, nil)
+	tamperToken(token)
+
+	_, err = km.Decrypt(token)
+	assert.ErrorIs(t, err, ErrDecryptionFailed, "Decrypt should report tampered tokens as decryption failures")
+
+	expired := issueTestToken(t, km, &JWEOptions{Headers: map[string]interface{}{"exp": time.Now().Add(-time.Hour).Unix()}})
+	tamperToken(expired)
+
+	_, err = km.Decrypt(expired, WithAudience("api"))
+	assert.ErrorIs(t, err, ErrDecryptionFailed, "Decrypt should authenticate tokens before checking their claims")
+}
+
+func TestKeyManager_DecryptJWEForAudience(t *testing.T) {
+	km, err := NewIssuerKeyManager(1*time.Hour, NewInMemoryKeyStore())
+	require.NoError(t, err, "Failed to create KeyManager")
+	defer km.Shutdown()
+
+	token := issueTestToken(t, km, &JWEOptions{Headers: map[string]interface{}{"aud": "api"}})
+
+	_, err = km.DecryptJWEForAudience(token, "api")
+	assert.NoError(t, err, "DecryptJWEForAudience should accept the matching audience")
+
+	_, err = km.DecryptJWEForAudience(token, "web")
+	assert.ErrorIs(t, err, ErrAudienceMismatch, "DecryptJWEForAudience should reject other audiences")
+
+	noAud := issueTestToken(t, km, nil)
+	_, err = km.DecryptJWEForAudience(noAud, "api")
+	assert.ErrorIs(t, err, ErrAudienceMismatch, "DecryptJWEForAudience should reject tokens without aud")
+}
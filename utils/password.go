@@ -3,6 +3,9 @@ package utils
 import "golang.org/x/crypto/bcrypt"
 
 // Encode Generate return a hashed password
+//
+// Deprecated: use auth/password.Hash, which does not panic and verifies these
+// hashes while upgrading them.
 func HashPassword(raw string) string {
 	hash, err := bcrypt.GenerateFromPassword([]byte(raw), 10)
 